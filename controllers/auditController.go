@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/natron-io/tenant-api/util"
+)
+
+// GetAuditEvents returns the audit trail of a tenant
+func GetAuditEvents(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	events, err := util.GetAuditEvents(tenant)
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(events)
+}
+
+// GetAllAuditEvents returns the audit trail of all tenants for the platform admins, filtered by the tenant query
+func GetAllAuditEvents(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+
+	events, err := util.GetAuditEvents(c.Query("tenant"))
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(events)
+}
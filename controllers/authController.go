@@ -1,8 +1,8 @@
 package controllers
 
 import (
-	"encoding/json"
-	"fmt"
+	"errors"
+	"net/url"
 	"strings"
 	"time"
 
@@ -11,22 +11,100 @@ import (
 	"github.com/natron-io/tenant-api/util"
 )
 
-// GetGithubTeams returns the redirect url
-func GithubLogin(c *fiber.Ctx) error {
+// Login redirects to the login of the identity provider
+func Login(c *fiber.Ctx) error {
 
 	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
 
-	redirectURL := fmt.Sprintf("https://github.com/login/oauth/authorize?scope=read:org&client_id=%s&redirect_uri=%s",
-		util.CLIENT_ID, util.CALLBACK_URL+"/login/github/callback")
+	provider, err := util.GetIdentityProvider(c.Params("provider"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Unknown identity provider",
+		})
+	}
 
+	// the state protects the callback against csrf and is bound to the browser with a cookie
+	state, codeVerifier, err := util.CreateLoginState(provider.Name(), util.GetCallbackURL(provider), time.Now())
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	redirectURL := provider.AuthCodeURL(util.GetCallbackURL(provider), state, util.CodeChallenge(codeVerifier))
+	if redirectURL == "" {
+		return c.Status(502).JSON(fiber.Map{
+			"message": "Identity provider not available",
+		})
+	}
+
+	c.Cookie(stateCookie(provider, state, time.Now().Add(10*time.Minute)))
 	return c.Redirect(redirectURL)
 }
 
-// FrontendGithubLogin gets the github data and sends it to LoggedIn()
-func FrontendGithubLogin(c *fiber.Ctx) error {
+// FrontendLoginState returns a new state with the url of the authorization request of the frontend with the redirect uri of the body,
+// the PKCE code verifier of the state stays on the server
+func FrontendLoginState(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+
+	provider, err := util.GetIdentityProvider(c.Params("provider"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Unknown identity provider",
+		})
+	}
+
+	var data map[string]string
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&data); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid request body",
+			})
+		}
+	}
+
+	redirectURL := data["redirect_uri"]
+	if redirectURL == "" {
+		redirectURL = util.GetCallbackURL(provider)
+	}
+
+	state, codeVerifier, err := util.CreateLoginState(provider.Name(), redirectURL, time.Now())
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	authCodeURL := provider.AuthCodeURL(redirectURL, state, util.CodeChallenge(codeVerifier))
+	if authCodeURL == "" {
+		return c.Status(502).JSON(fiber.Map{
+			"message": "Identity provider not available",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"state":        state,
+		"redirect_uri": redirectURL,
+		"url":          authCodeURL,
+	})
+}
+
+// FrontendLogin authenticates the code of the identity provider sent by the frontend with the state of FrontendLoginState and sends it to LoggedIn()
+func FrontendLogin(c *fiber.Ctx) error {
 
 	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
 
+	provider, err := util.GetIdentityProvider(c.Params("provider"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Unknown identity provider",
+		})
+	}
+
 	var data map[string]string
 
 	if err := c.BodyParser(&data); err != nil {
@@ -36,107 +114,405 @@ func FrontendGithubLogin(c *fiber.Ctx) error {
 		})
 	}
 
-	// get access_token from data
-	if githubCode := data["github_code"]; githubCode == "" {
+	// github_code is kept for the existing frontends
+	code := data["code"]
+	if code == "" {
+		code = data["github_code"]
+	}
+	if code == "" {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Invalid request body",
 		})
-	} else {
-		githubAccessToken := util.GetGithubAccessToken(githubCode)
-		githubData := util.GetGithubTeams(githubAccessToken)
+	}
 
-		return LoggedIn(c, githubData)
+	// the redirect uri must match the one of the authorization request of the frontend
+	redirectURL := data["redirect_uri"]
+	if redirectURL == "" {
+		redirectURL = util.GetCallbackURL(provider)
 	}
 
+	// the state must have been issued for the authorization request with the redirect uri, its code verifier is used once
+	codeVerifier, err := util.ConsumeLoginState(data["state"], provider.Name(), redirectURL, time.Now())
+	if errors.Is(err, util.ErrInvalidLoginState) {
+		util.WarningLogger.Printf("IP %s login with %s has an invalid state", c.IP(), provider.Name())
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid state",
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	identity, err := provider.Authenticate(c.Context(), code, redirectURL, codeVerifier)
+	if err != nil {
+		util.WarningLogger.Printf("IP %s login with %s failed: %s", c.IP(), provider.Name(), err)
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+
+	return LoggedIn(c, identity)
 }
 
-// GithubCallback handles the callback with the code query param
-func GithubCallback(c *fiber.Ctx) error {
+// Callback handles the callback of the identity provider with the code query param
+func Callback(c *fiber.Ctx) error {
 
 	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
 
+	provider, err := util.GetIdentityProvider(c.Params("provider"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Unknown identity provider",
+		})
+	}
+
+	// the state must be the one of the login of this browser
+	state := c.Query("state")
+	if state == "" || state != c.Cookies(stateCookieName) {
+		util.WarningLogger.Printf("IP %s login with %s has an invalid state", c.IP(), provider.Name())
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid state",
+		})
+	}
+	c.Cookie(stateCookie(provider, "", time.Unix(0, 0)))
+
+	codeVerifier, err := util.ConsumeLoginState(state, provider.Name(), util.GetCallbackURL(provider), time.Now())
+	if errors.Is(err, util.ErrInvalidLoginState) {
+		util.WarningLogger.Printf("IP %s login with %s has an invalid state", c.IP(), provider.Name())
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid state",
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	// the identity provider redirects with an error if the user denied the login
+	if providerError := c.Query("error"); providerError != "" {
+		util.WarningLogger.Printf("IP %s login with %s failed: %s", c.IP(), provider.Name(), providerError)
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+
 	// get code from "code" query param
 	code := c.Query("code")
+	if code == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Missing code",
+		})
+	}
 
-	githubAccessToken := util.GetGithubAccessToken(code)
-	githubData := util.GetGithubTeams(githubAccessToken)
+	identity, err := provider.Authenticate(c.Context(), code, util.GetCallbackURL(provider), codeVerifier)
+	if err != nil {
+		util.WarningLogger.Printf("IP %s login with %s failed: %s", c.IP(), provider.Name(), err)
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
 
-	return LoggedIn(c, githubData)
+	return LoggedIn(c, identity)
 }
 
-// LoggedIn handles the login and returns the token
-func LoggedIn(c *fiber.Ctx, githubData string) error {
-	if githubData == "" {
+// stateCookieName is the cookie with the state of the login of the browser
+const stateCookieName = "tenant_api_state"
+
+// stateCookie returns the state cookie of the callback of the identity provider, which expires at the provided time
+func stateCookie(provider util.IdentityProvider, state string, expires time.Time) *fiber.Cookie {
+	// the path of the callback in the browser, CALLBACK_URL can have a path prefix
+	path := "/login/" + provider.Name() + "/callback"
+	if callbackURL, err := url.Parse(util.GetCallbackURL(provider)); err == nil && callbackURL.Path != "" {
+		path = callbackURL.Path
+	}
+
+	return &fiber.Cookie{
+		Name:     stateCookieName,
+		Value:    state,
+		Path:     path,
+		Expires:  expires,
+		Secure:   strings.HasPrefix(util.CALLBACK_URL, "https://"),
+		HTTPOnly: true,
+		// the cookie is sent on the top level redirect of the identity provider
+		SameSite: "Lax",
+	}
+}
+
+// LoggedIn handles the login and returns the access token with the tenants and roles of the groups of the identity and the refresh token of the new session,
+// platform admins have no tenants in the token as they are admin of every tenant
+func LoggedIn(c *fiber.Ctx, identity util.Identity) error {
+	if !hasTenants(identity) {
 		// return unauthorized
 		return c.Status(401).JSON(fiber.Map{
 			"message": "Unauthorized",
 		})
 	}
 
-	// parse responsebody to map array
-	var githubDataMap []map[string]interface{}
-	if err := json.Unmarshal([]byte(githubData), &githubDataMap); err != nil {
+	session, refreshToken, err := util.CreateSession(identity, time.Now())
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
 		return c.Status(500).JSON(fiber.Map{
-			"message": "Internal server error",
+			"message": "Internal Server Error",
+		})
+	}
+
+	return sendTokens(c, identity, session, refreshToken)
+}
+
+// Refresh checks the groups of the user of the refresh token again and returns a new access token and refresh token,
+// the session is revoked if the user has no tenants anymore
+func Refresh(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+
+	var data map[string]string
+
+	if err := c.BodyParser(&data); err != nil || data["refresh_token"] == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
 		})
 	}
 
-	// get each github team slug
-	var githubTeamSlugs []string
-	for _, githubTeam := range githubDataMap {
-		githubTeamSlugs = append(githubTeamSlugs, githubTeam["slug"].(string))
+	now := time.Now()
+	identity, session, refreshToken, err := util.RefreshSession(c.Context(), data["refresh_token"], now)
+	if err != nil {
+		if !errors.Is(err, util.ErrInvalidRefreshToken) {
+			util.WarningLogger.Printf("IP %s refresh failed: %s", c.IP(), err)
+		}
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
 	}
 
-	if githubTeamSlugs == nil {
-		// return unauthorized
+	if !hasTenants(identity) {
+		util.WarningLogger.Printf("IP %s %s has no tenants anymore, revoking the session", c.IP(), identity.Subject)
+		if err := util.RevokeSession(session.ID, now); err != nil {
+			util.ErrorLogger.Printf("%s", err)
+		}
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+
+	return sendTokens(c, identity, session, refreshToken)
+}
+
+// Logout revokes the session of the refresh token of the body or of the access token
+func Logout(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+
+	var data map[string]string
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&data); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid request body",
+			})
+		}
+	}
+
+	// the refresh token logs out without a valid access token, e.g. after the access token has expired
+	if refreshToken := data["refresh_token"]; refreshToken != "" {
+		err := util.RevokeRefreshSession(refreshToken, time.Now())
+		if errors.Is(err, util.ErrInvalidRefreshToken) {
+			return c.Status(401).JSON(fiber.Map{
+				"message": "Unauthorized",
+			})
+		}
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		return c.JSON(fiber.Map{
+			"message": "Logged out",
+		})
+	}
+
+	claims := getClaims(c)
+	if claims == nil {
 		return c.Status(401).JSON(fiber.Map{
 			"message": "Unauthorized",
 		})
 	}
 
-	// expire token in 1 day
-	exp := time.Now().Add(time.Hour * 24).Unix()
+	// tokens issued before the sessions expire on their own
+	if sessionID, ok := claims["sid"].(string); ok {
+		if err := util.RevokeSession(sessionID, time.Now()); err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Logged out",
+	})
+}
+
+// GetJWKS returns the public keys which verify the tokens of the tenant-api
+func GetJWKS(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+
+	c.Set("Cache-Control", "public, max-age=300")
+	return c.JSON(util.GetJWKS())
+}
+
+// hasTenants returns true if the identity has a tenant or is a platform admin
+func hasTenants(identity util.Identity) bool {
+	return len(util.GetTenantsWithRole(util.GetTenantRoles(identity), util.RoleViewer)) != 0 || util.IsPlatformAdmin(identity)
+}
+
+// sendTokens returns the access token of the identity in the session, which expires after ACCESS_TOKEN_TTL, and the refresh token
+func sendTokens(c *fiber.Ctx, identity util.Identity, session util.Session, refreshToken string) error {
+	tenantRoles := util.GetTenantRoles(identity)
 
 	claims := jwt.MapClaims{
-		"github_team_slugs": githubTeamSlugs,
-		"exp":               exp,
+		"provider": identity.Provider,
+		"sub":      identity.Subject,
+		"sid":      session.ID,
+		"tenants":  util.GetTenantsWithRole(tenantRoles, util.RoleViewer),
+		"roles":    tenantRoles,
+		"admin":    util.IsPlatformAdmin(identity),
+		"exp":      time.Now().Add(util.ACCESS_TOKEN_TTL).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, _ := token.SignedString([]byte(util.SECRET_KEY))
+	tokenString, err := util.SignToken(claims)
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
 
 	return c.JSON(fiber.Map{
-		"token": tokenString,
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    int(util.ACCESS_TOKEN_TTL.Seconds()),
 	})
 }
 
-// CheckAuth checks if the token is valid and returns the github team slugs
+// RequireRole restricts the routes to users with the role in the tenant of the route,
+// routes without tenant require the role in at least one tenant
+func RequireRole(role util.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("role", role)
+
+		tenants, ok := getAuthorizedTenants(c, role)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{
+				"message": "Unauthorized",
+			})
+		}
+
+		tenant := c.Params("tenant")
+		if (tenant != "" && !util.Contains(tenant, tenants)) || len(tenants) == 0 {
+			util.WarningLogger.Printf("IP %s has not the role %s", c.IP(), role)
+			return c.Status(403).JSON(fiber.Map{
+				"message": "Forbidden",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequirePlatformAdmin restricts the routes to the platform admins
+func RequirePlatformAdmin(c *fiber.Ctx) error {
+	claims := getClaims(c)
+	if claims == nil {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+
+	if admin, _ := claims["admin"].(bool); !admin {
+		util.WarningLogger.Printf("IP %s is not a platform admin", c.IP())
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	return c.Next()
+}
+
+// CheckAuth checks if the user token or API token is valid and returns the tenants in which it has the role required by the route, viewer by default
 func CheckAuth(c *fiber.Ctx) []string {
-	var token *jwt.Token
-	var tokenString string
+	role, ok := c.Locals("role").(util.Role)
+	if !ok {
+		role = util.RoleViewer
+	}
 
-	// get bearer token from header
-	bearerToken := c.Get("Authorization")
+	tenants, _ := getAuthorizedTenants(c, role)
+	if len(tenants) == 0 {
+		util.WarningLogger.Printf("IP %s is not authorized", c.IP())
+		return nil
+	}
+
+	return tenants
+}
 
+// getAuthorizedTenants returns the tenants in which the bearer token, a user token or an API token, has the role
+// and false if the token is not valid
+func getAuthorizedTenants(c *fiber.Ctx, role util.Role) ([]string, bool) {
+	if tokenString := getBearerToken(c); strings.HasPrefix(tokenString, util.APITokenPrefix) {
+		apiToken, err := util.VerifyAPIToken(tokenString, time.Now())
+		if err != nil {
+			if !errors.Is(err, util.ErrInvalidAPIToken) {
+				util.ErrorLogger.Printf("%s", err)
+			}
+			return nil, false
+		}
+
+		// the scopes of the API tokens only allow to read
+		if c.Method() != fiber.MethodGet || !apiToken.Grants(role) {
+			return []string{}, true
+		}
+		return []string{apiToken.Tenant}, true
+	}
+
+	claims := getClaims(c)
+	if claims == nil {
+		return nil, false
+	}
+	return util.GetTenantsWithRole(getTenantRoles(claims), role), true
+}
+
+// getBearerToken returns the token of the Authorization header or an empty string
+func getBearerToken(c *fiber.Ctx) string {
 	// split bearer token to get token
-	bearerTokenSplit := strings.Split(bearerToken, " ")
-	if len(bearerTokenSplit) == 2 {
-		tokenString = bearerTokenSplit[1]
-	} else {
-		return nil
+	bearerTokenSplit := strings.Split(c.Get("Authorization"), " ")
+	if len(bearerTokenSplit) != 2 {
+		return ""
 	}
+	return bearerTokenSplit[1]
+}
+
+// getClaims returns the claims of the bearer token or nil if the token is not a valid user token
+func getClaims(c *fiber.Ctx) jwt.MapClaims {
+	var token *jwt.Token
 
+	// get bearer token from header
+	tokenString := getBearerToken(c)
 	if tokenString == "" {
 		// return unauthorized
 		return nil
 	}
 
 	var err error
-	// parse token with secret key
-	token, err = jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(util.SECRET_KEY), nil
-	})
+	// parse token with the signing keys
+	token, err = util.ParseToken(tokenString)
 
 	if err != nil {
 		return nil
@@ -165,20 +541,54 @@ func CheckAuth(c *fiber.Ctx) []string {
 		}
 	}
 
-	if claims["github_team_slugs"] == nil {
-		util.WarningLogger.Printf("IP %s is not authorized", c.IP())
-		return nil
+	// validate the session is not revoked
+	if sessionID, ok := claims["sid"].(string); ok {
+		revoked, err := util.IsSessionRevoked(sessionID)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return nil
+		}
+		if revoked {
+			return nil
+		}
 	}
 
-	var githubTeamSlugs []string
-	for _, githubTeam := range claims["github_team_slugs"].([]interface{}) {
-		githubTeamSlugs = append(githubTeamSlugs, githubTeam.(string))
+	return claims
+}
+
+// getTenantRoles returns the roles of each tenant of the claims, tokens issued without roles have the DEFAULT_ROLES in their tenants
+// and platform admins are admin of every tenant of the clusters
+func getTenantRoles(claims jwt.MapClaims) map[string][]util.Role {
+	tenantRoles := make(map[string][]util.Role)
+
+	if admin, _ := claims["admin"].(bool); admin {
+		tenants, err := util.GetTenantsInCluster()
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+		}
+		for _, tenant := range tenants {
+			tenantRoles[tenant] = []util.Role{util.RoleAdmin}
+		}
 	}
 
-	if githubTeamSlugs == nil {
-		util.WarningLogger.Printf("IP %s is not authorized", c.IP())
-		return nil
+	roleClaims, ok := claims["roles"].(map[string]interface{})
+	if !ok {
+		tenantClaims, _ := claims["tenants"].([]interface{})
+		for _, tenant := range tenantClaims {
+			if tenant, ok := tenant.(string); ok {
+				tenantRoles[tenant] = append(tenantRoles[tenant], util.DEFAULT_ROLES...)
+			}
+		}
+		return tenantRoles
 	}
 
-	return githubTeamSlugs
+	for tenant, roles := range roleClaims {
+		roles, _ := roles.([]interface{})
+		for _, role := range roles {
+			if role, ok := role.(string); ok {
+				tenantRoles[tenant] = append(tenantRoles[tenant], util.Role(role))
+			}
+		}
+	}
+	return tenantRoles
 }
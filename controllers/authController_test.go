@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+	"github.com/natron-io/tenant-api/util"
+)
+
+// setupAuth opens an empty store in a temporary directory and signs the tokens with a secret key
+func setupAuth(t *testing.T) {
+	t.Helper()
+	util.InitLoggers()
+
+	previousDataPath, previousDB := util.DATA_PATH, util.DB
+	previousSecretKey, previousKeysDir, previousDefaultRoles := util.SECRET_KEY, util.SIGNING_KEYS_DIR, util.DEFAULT_ROLES
+	util.DATA_PATH = t.TempDir()
+	if err := util.InitStore(); err != nil {
+		t.Fatalf("cannot open the store: %s", err)
+	}
+	t.Cleanup(func() {
+		util.DB.Close()
+		util.DATA_PATH, util.DB = previousDataPath, previousDB
+		util.SECRET_KEY, util.SIGNING_KEYS_DIR, util.DEFAULT_ROLES = previousSecretKey, previousKeysDir, previousDefaultRoles
+	})
+
+	util.SECRET_KEY, util.SIGNING_KEYS_DIR = "secret", ""
+	util.DEFAULT_ROLES = []util.Role{util.RoleViewer}
+	if err := util.InitSigningKeys(); err != nil {
+		t.Fatalf("cannot init the signing keys: %s", err)
+	}
+}
+
+// signedToken returns a user token with the claims which expires in an hour
+func signedToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	token, err := util.SignToken(claims)
+	if err != nil {
+		t.Fatalf("cannot sign the token: %s", err)
+	}
+	return token
+}
+
+func TestRequireRole(t *testing.T) {
+	setupAuth(t)
+
+	app := fiber.New()
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(200) }
+	app.Get("/tenants/:tenant/costs", RequireRole(util.RoleBilling), ok)
+	app.Put("/tenants/:tenant/budget", RequireRole(util.RoleAdmin), ok)
+	app.Get("/costs", RequireRole(util.RoleBilling), ok)
+
+	billing := signedToken(t, jwt.MapClaims{"roles": map[string]interface{}{"acme": []string{"billing"}}})
+	admin := signedToken(t, jwt.MapClaims{"roles": map[string]interface{}{"acme": []string{"admin"}}})
+	viewer := signedToken(t, jwt.MapClaims{"roles": map[string]interface{}{"acme": []string{"viewer"}, "globex": []string{"billing"}}})
+	// tokens issued before the roles have the DEFAULT_ROLES in their tenants
+	legacy := signedToken(t, jwt.MapClaims{"tenants": []string{"acme"}})
+	expired := signedToken(t, jwt.MapClaims{"roles": map[string]interface{}{"acme": []string{"admin"}}, "exp": time.Now().Add(-time.Minute).Unix()})
+
+	readCosts := createAPIToken(t, util.ScopeReadCosts)
+	readResources := createAPIToken(t, util.ScopeReadResources)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "no token", method: "GET", path: "/tenants/acme/costs", status: 401},
+		{name: "invalid token", method: "GET", path: "/tenants/acme/costs", token: "invalid", status: 401},
+		{name: "expired token", method: "GET", path: "/tenants/acme/costs", token: expired, status: 401},
+		{name: "role in the tenant", method: "GET", path: "/tenants/acme/costs", token: billing, status: 200},
+		{name: "role in another tenant", method: "GET", path: "/tenants/globex/costs", token: billing, status: 403},
+		{name: "role below the required role", method: "PUT", path: "/tenants/acme/budget", token: billing, status: 403},
+		{name: "admin implies billing", method: "GET", path: "/tenants/acme/costs", token: admin, status: 200},
+		{name: "admin role", method: "PUT", path: "/tenants/acme/budget", token: admin, status: 200},
+		{name: "viewer does not imply billing", method: "GET", path: "/tenants/acme/costs", token: viewer, status: 403},
+		{name: "role in any tenant without a tenant", method: "GET", path: "/costs", token: viewer, status: 200},
+		{name: "no tenant with the role", method: "GET", path: "/costs", token: legacy, status: 403},
+		{name: "default roles of a legacy token", method: "GET", path: "/tenants/acme/costs", token: legacy, status: 403},
+		{name: "api token with the scope", method: "GET", path: "/tenants/acme/costs", token: readCosts, status: 200},
+		{name: "api token of another tenant", method: "GET", path: "/tenants/globex/costs", token: readCosts, status: 403},
+		{name: "api token without the scope", method: "GET", path: "/tenants/acme/costs", token: readResources, status: 403},
+		{name: "api token only reads", method: "PUT", path: "/tenants/acme/budget", token: readCosts, status: 403},
+		{name: "unknown api token", method: "GET", path: "/tenants/acme/costs", token: util.APITokenPrefix + "unknown", status: 401},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if resp.StatusCode != test.status {
+				t.Errorf("expected the status %d, got %d", test.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+// createAPIToken returns a token of the tenant acme with the scopes
+func createAPIToken(t *testing.T, scopes ...string) string {
+	t.Helper()
+	_, token, err := util.CreateAPIToken("acme", "ci", scopes, "jane", nil, time.Now())
+	if err != nil {
+		t.Fatalf("cannot create the api token: %s", err)
+	}
+	return token
+}
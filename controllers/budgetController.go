@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/natron-io/tenant-api/util"
+)
+
+// GetBudget returns the budget of a tenant with its spend and projected spend of the current month
+func GetBudget(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	status, err := util.GetBudgetStatus(tenant, time.Now())
+	if errors.Is(err, util.ErrBudgetNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Budget not found",
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(status)
+}
+
+// SetBudget sets the monthly budget of a tenant and its alert thresholds in percent
+func SetBudget(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	var body struct {
+		Amount       float64   `json:"amount"`
+		Currency     string    `json:"currency"`
+		Thresholds   []float64 `json:"thresholds"`
+		SlackChannel string    `json:"slack_channel"`
+		WebhookURL   string    `json:"webhook_url"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid body",
+		})
+	}
+
+	// only the platform admins choose the slack channel, so a tenant cannot post into the channels of other tenants
+	claims := getClaims(c)
+	if admin, _ := claims["admin"].(bool); !admin {
+		current, err := util.GetBudget(tenant)
+		if err != nil && !errors.Is(err, util.ErrBudgetNotFound) {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		if body.SlackChannel != "" && body.SlackChannel != current.SlackChannel {
+			return c.Status(403).JSON(fiber.Map{
+				"message": "slack_channel can only be set by platform admins",
+			})
+		}
+		body.SlackChannel = current.SlackChannel
+	}
+
+	updatedBy, _ := claims["sub"].(string)
+	budget, err := util.SetBudget(util.Budget{
+		Tenant:       tenant,
+		Amount:       body.Amount,
+		Currency:     body.Currency,
+		Thresholds:   body.Thresholds,
+		SlackChannel: body.SlackChannel,
+		WebhookURL:   body.WebhookURL,
+		UpdatedBy:    updatedBy,
+	}, time.Now())
+	if errors.Is(err, util.ErrInvalidBudget) || errors.Is(err, util.ErrNoExchangeRate) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	util.InfoLogger.Printf("budget of tenant %s set to %.2f %s by %s", tenant, budget.Amount, budget.Currency, updatedBy)
+	return c.JSON(budget)
+}
+
+// DeleteBudget deletes the budget of a tenant, which stops its alerts
+func DeleteBudget(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	err := util.DeleteBudget(tenant)
+	if errors.Is(err, util.ErrBudgetNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Budget not found",
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	util.InfoLogger.Printf("budget of tenant %s deleted", tenant)
+	return c.JSON(fiber.Map{
+		"message": "Budget deleted",
+	})
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"reflect"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/natron-io/tenant-api/util"
+)
+
+// GetClusters returns the names of all clusters
+func GetClusters(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+
+	return c.JSON(util.GetClusterNames())
+}
+
+// byCluster returns true if the results should be keyed by cluster
+func byCluster(c *fiber.Ctx) bool {
+	return c.Query("by_cluster") == "true"
+}
+
+// clusterResponse returns the results of each cluster and the aggregated total of the tenant or of all tenants if tenant is empty
+func clusterResponse(tenant string, clusterResults interface{}, total interface{}) fiber.Map {
+	clusters := make(map[string]interface{})
+	clusterResultsValue := reflect.ValueOf(clusterResults)
+	for _, clusterName := range clusterResultsValue.MapKeys() {
+		clusters[clusterName.String()] = tenantResponse(tenant, clusterResultsValue.MapIndex(clusterName).Interface())
+	}
+
+	return fiber.Map{
+		"clusters": clusters,
+		"total":    tenantResponse(tenant, total),
+	}
+}
+
+// tenantResponse returns the value of the tenant of a map keyed by tenant or the whole map if tenant is empty
+func tenantResponse(tenant string, tenantResults interface{}) interface{} {
+	if tenant == "" {
+		return tenantResults
+	}
+
+	tenantResultsValue := reflect.ValueOf(tenantResults)
+	tenantResult := tenantResultsValue.MapIndex(reflect.ValueOf(tenant))
+	if !tenantResult.IsValid() {
+		return reflect.Zero(tenantResultsValue.Type().Elem()).Interface()
+	}
+	return tenantResult.Interface()
+}
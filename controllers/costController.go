@@ -1,6 +1,10 @@
 package controllers
 
 import (
+	"errors"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/natron-io/tenant-api/util"
 )
@@ -22,20 +26,20 @@ func GetCPUCostSum(c *fiber.Ctx) error {
 		})
 	}
 
-	// create a map for each tenant with a added cpu requests
-	tenantCPURequests, err := util.GetCPURequestsSumByTenant(tenants)
+	// create a map for each cluster with the costs of each tenant only if cost is not 0
+	clusterTenantCPUCosts, err := util.GetCPUCostSumByClusterByTenant(tenants)
 	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
 		return c.Status(500).JSON(fiber.Map{
 			"message": "Internal Server Error",
 		})
 	}
 
-	// create a map for each tenant with a added cpu costs only if cost is not 0
-	tenantCPUCosts := make(map[string]float64)
-	for _, tenant := range tenants {
-		if tenantCPURequests[tenant] != 0 {
-			tenantCPUCosts[tenant] = util.GetCPUCost(float64(tenantCPURequests[tenant]))
-		}
+	// sum the costs of each tenant over all clusters
+	tenantCPUCosts := util.SumFloat64ByTenant(clusterTenantCPUCosts)
+
+	if byCluster(c) {
+		return c.JSON(clusterResponse(tenant, clusterTenantCPUCosts, tenantCPUCosts))
 	}
 
 	if tenant == "" {
@@ -62,20 +66,20 @@ func GetMemoryCostSum(c *fiber.Ctx) error {
 		})
 	}
 
-	// create a map for each tenant with a added memory requests
-	tenantMemoryRequests, err := util.GetMemoryRequestsSumByTenant(tenants)
+	// create a map for each cluster with the costs of each tenant only if cost is not 0
+	clusterTenantMemoryCosts, err := util.GetMemoryCostSumByClusterByTenant(tenants)
 	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
 		return c.Status(500).JSON(fiber.Map{
 			"message": "Internal Server Error",
 		})
 	}
 
-	// create a map for each tenant with a added memory costs only if cost is not 0
-	tenantMemoryCosts := make(map[string]float64)
-	for _, tenant := range tenants {
-		if tenantMemoryRequests[tenant] != 0 {
-			tenantMemoryCosts[tenant] = util.GetMemoryCost(float64(tenantMemoryRequests[tenant]))
-		}
+	// sum the costs of each tenant over all clusters
+	tenantMemoryCosts := util.SumFloat64ByTenant(clusterTenantMemoryCosts)
+
+	if byCluster(c) {
+		return c.JSON(clusterResponse(tenant, clusterTenantMemoryCosts, tenantMemoryCosts))
 	}
 
 	if tenant == "" {
@@ -102,36 +106,20 @@ func GetStorageCostSum(c *fiber.Ctx) error {
 		})
 	}
 
-	// create a map for each tenant with a map of storage classes with calculated pvcs in it
-	tenantPVCs, err := util.GetStorageRequestsSumByTenant(tenants)
+	// create a map for each cluster with the costs of each tenant only if cost is not 0
+	clusterTenantStorageCosts, err := util.GetStorageCostSumByClusterByTenant(tenants)
 	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
 		return c.Status(500).JSON(fiber.Map{
 			"message": "Internal Server Error",
 		})
 	}
 
-	// create a map for each tenant with each storage class with a cost if it is not 0 and add it to the tenant map
-	tenantStorageCosts := make(map[string]map[string]float64)
-	for _, tenant := range tenants {
-		tenantStorageCosts[tenant] = make(map[string]float64)
-		for storageClass, pvcs := range tenantPVCs[tenant] {
-			if pvcs != 0 {
-				tenantStorageCosts[tenant][storageClass], err = util.GetStorageCost(storageClass, float64(pvcs))
-				if err != nil {
-					util.ErrorLogger.Printf("%s", err)
-					return c.Status(500).JSON(fiber.Map{
-						"message": "Internal Server Error",
-					})
-				}
-			}
-		}
-	}
+	// sum the costs of each tenant over all clusters
+	tenantStorageCosts := util.SumFloat64ByTenantByKey(clusterTenantStorageCosts)
 
-	// remove tenants with no storage costs
-	for tenant, storageCosts := range tenantStorageCosts {
-		if len(storageCosts) == 0 {
-			delete(tenantStorageCosts, tenant)
-		}
+	if byCluster(c) {
+		return c.JSON(clusterResponse(tenant, clusterTenantStorageCosts, tenantStorageCosts))
 	}
 
 	if tenant == "" {
@@ -158,41 +146,214 @@ func GetIngressCostSum(c *fiber.Ctx) error {
 		})
 	}
 
-	// create a map for each tenant with a added ingress requests
-	tenantsIngressRequests, err := util.GetIngressRequestsSumByTenant(tenants)
+	// create a map for each cluster with the costs of each tenant only if cost is not 0
+	clusterTenantsIngressCosts, err := util.GetIngressCostSumByClusterByTenant(tenants)
 	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
 		return c.Status(500).JSON(fiber.Map{
 			"message": "Internal Server Error",
 		})
 	}
 
-	// create a map for each tenant with a added ingress costs only if cost is not 0
+	// sum the costs of each tenant over all clusters
+	tenantsIngressCosts := util.SumFloat64ByTenant(clusterTenantsIngressCosts)
 
-	if !util.INGRESS_COST_PER_DOMAIN {
-		tenantsIngressCosts := make(map[string]float64)
-		for _, tenant := range tenants {
-			if tenantsIngressRequests[tenant] != nil {
-				tenantsIngressCosts[tenant] = util.GetIngressCost(len(tenantsIngressRequests[tenant]))
-			}
-		}
+	if byCluster(c) {
+		return c.JSON(clusterResponse(tenant, clusterTenantsIngressCosts, tenantsIngressCosts))
+	}
+
+	if tenant == "" {
+		return c.JSON(tenantsIngressCosts)
+	} else {
+		return c.JSON(tenantsIngressCosts[tenant])
+	}
+}
+
+// GetCostHistory returns the cost of a tenant integrated over each step of a time range
+func GetCostHistory(c *fiber.Ctx) error {
 
-		if tenant == "" {
-			return c.JSON(tenantsIngressCosts)
-		} else {
-			return c.JSON(tenantsIngressCosts[tenant])
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	// step defaults to one point per day
+	step := 24 * time.Hour
+	if c.Query("step") != "" {
+		if step, err = time.ParseDuration(c.Query("step")); err != nil || step <= 0 {
+			return c.Status(400).JSON(fiber.Map{
+				"message": "Invalid step, must be a positive duration like 1h",
+			})
 		}
 	}
 
-	tenantsIngressCostsPerDomain := make(map[string]float64)
-	for _, tenant := range tenants {
-		if tenantsIngressRequests[tenant] != nil {
-			tenantsIngressCostsPerDomain[tenant] = util.GetIngressCostByDomain(tenantsIngressRequests[tenant])
+	if to.Sub(from)/step > 10000 {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Too many points, increase the step",
+		})
+	}
+
+	// currency defaults to the currency of the tenant
+	currency := c.Query("currency", util.GetTenantCurrency(tenant))
+	if !util.IsCurrency(currency) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid currency, must be an ISO 4217 code like CHF",
+		})
+	}
+
+	points, err := util.GetCostHistory(tenant, from, to, step, currency)
+	if errors.Is(err, util.ErrNoExchangeRate) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"from":     from,
+		"to":       to,
+		"step":     step.String(),
+		"currency": currency,
+		"points":   points,
+		"total":    util.SumCostHistory(points),
+	})
+}
+
+// parseTimeRange returns the from and to query params as RFC3339 times, defaults to the current month until now
+func parseTimeRange(c *fiber.Ctx) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := now
+
+	var err error
+	if c.Query("from") != "" {
+		if from, err = time.Parse(time.RFC3339, c.Query("from")); err != nil {
+			return from, to, fiber.NewError(400, "Invalid from, must be a RFC3339 time")
+		}
+	}
+	if c.Query("to") != "" {
+		if to, err = time.Parse(time.RFC3339, c.Query("to")); err != nil {
+			return from, to, fiber.NewError(400, "Invalid to, must be a RFC3339 time")
 		}
 	}
 
+	if !from.Before(to) {
+		return from, to, fiber.NewError(400, "Invalid time range, from must be before to")
+	}
+
+	return from, to, nil
+}
+
+// GetCostSummary returns the cost breakdown of every category with the subtotals, the discount and the total per tenant
+func GetCostSummary(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if tenant != "" && !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	// only calculate the requested tenant
+	if tenant != "" {
+		tenants = []string{tenant}
+	}
+
+	clusterTenantSummaries, err := util.GetCostSummaryByClusterByTenant(tenants)
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	// sum the summaries of each tenant over all clusters
+	tenantSummaries := util.SumCostSummaryByTenant(clusterTenantSummaries)
+
+	if byCluster(c) {
+		return c.JSON(clusterResponse(tenant, clusterTenantSummaries, tenantSummaries))
+	}
+
 	if tenant == "" {
-		return c.JSON(tenantsIngressCostsPerDomain)
+		return c.JSON(tenantSummaries)
 	} else {
-		return c.JSON(tenantsIngressCostsPerDomain[tenant])
+		return c.JSON(tenantSummaries[tenant])
 	}
 }
+
+// GetFleetCosts returns the cost summary of all tenants of the clusters in one currency with the tenants ranked by their cost
+func GetFleetCosts(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenants := CheckAuth(c)
+	if tenants == nil {
+		// a fleet without tenants is empty
+		tenants = []string{}
+	}
+
+	// currency defaults to the global currency of the prices
+	currency := c.Query("currency", util.GetCurrency())
+	if !util.IsCurrency(currency) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid currency, must be an ISO 4217 code like CHF",
+		})
+	}
+
+	category := c.Query("rank_by", "total")
+	if !util.Contains(category, util.CostCategories) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid rank_by, must be one of " + strings.Join(util.CostCategories, ", "),
+		})
+	}
+
+	clusterTenantSummaries, err := util.GetCostSummaryByClusterByTenant(tenants)
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	fleet, err := util.GetFleetCostSummary(util.SumCostSummaryByTenant(clusterTenantSummaries), currency, category)
+	if errors.Is(err, util.ErrNoExchangeRate) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(fleet)
+}
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/natron-io/tenant-api/util"
+)
+
+// GetHealth returns ok as long as the api is running
+func GetHealth(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"status": "ok",
+	})
+}
+
+// GetReadiness returns ok if the informer caches are synced,
+// the api only listens after the startup synced the caches and exits if they are not synced within CACHE_SYNC_TIMEOUT
+func GetReadiness(c *fiber.Ctx) error {
+	if !util.IsCacheSynced() {
+		return c.Status(503).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Informer caches not synced",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "ok",
+	})
+}
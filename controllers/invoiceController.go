@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/natron-io/tenant-api/util"
+)
+
+// GetInvoices returns all closed invoices of a tenant
+func GetInvoices(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	invoices, err := util.GetInvoices(tenant)
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(invoices)
+}
+
+// GetInvoice returns a closed invoice of a tenant as json, csv or pdf
+func GetInvoice(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	invoice, err := util.GetInvoice(tenant, c.Params("invoice"))
+	if errors.Is(err, util.ErrInvoiceNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Invoice not found",
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	filename := fmt.Sprintf("invoice-%s-%s", invoice.Tenant, invoice.ID)
+	switch c.Query("format", "json") {
+	case "json":
+		return c.JSON(invoice)
+	case "csv":
+		data, err := util.InvoiceCSV(invoice)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		c.Attachment(filename + ".csv")
+		c.Set(fiber.HeaderContentType, "text/csv")
+		return c.Send(data)
+	case "pdf":
+		c.Attachment(filename + ".pdf")
+		c.Set(fiber.HeaderContentType, "application/pdf")
+		return c.Send(util.InvoicePDF(invoice))
+	default:
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid format, must be json, csv or pdf",
+		})
+	}
+}
+
+// CloseInvoice closes the invoice of a past billing period of a tenant, only for platform admins
+func CloseInvoice(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	var body struct {
+		Period string `json:"period"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Period == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid body, period is required",
+		})
+	}
+
+	invoice, err := util.CloseInvoice(tenant, body.Period, time.Now())
+	if errors.Is(err, util.ErrInvoiceClosed) {
+		return c.Status(409).JSON(fiber.Map{
+			"message": "Invoice is already closed",
+		})
+	}
+	if errors.Is(err, util.ErrPeriodNotEnded) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Billing period has not ended yet",
+		})
+	}
+	if errors.Is(err, util.ErrPeriodUncovered) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Billing period is not fully covered by the recorded cost history",
+		})
+	}
+	if errors.Is(err, util.ErrInvalidPeriod) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid period, must be YYYY-MM",
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.Status(201).JSON(invoice)
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/natron-io/tenant-api/util"
+	"github.com/prometheus/common/expfmt"
+)
+
+// GetMetrics returns the metrics of the tenant-api in the Prometheus text format
+func GetMetrics(c *fiber.Ctx) error {
+	if util.METRICS_TOKEN != "" {
+		token := []byte("Bearer " + util.METRICS_TOKEN)
+		if subtle.ConstantTimeCompare([]byte(c.Get("Authorization")), token) != 1 {
+			return c.Status(401).JSON(fiber.Map{
+				"message": "Unauthorized",
+			})
+		}
+	}
+
+	var metrics bytes.Buffer
+	if err := util.WriteMetrics(&metrics); err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	c.Set("Content-Type", string(expfmt.FmtText))
+	return c.Send(metrics.Bytes())
+}
+
+// RecordMetrics counts the requests and observes their latency by the path of the matched route
+func RecordMetrics(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	code := c.Response().StatusCode()
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		code = fiberErr.Code
+	} else if err != nil {
+		code = 500
+	}
+
+	// requests without a matching route end in the middlewares mounted on /,
+	// their paths are not used as labels to keep the cardinality bounded
+	route := c.Route().Path
+	if route == "/" && c.Path() != "/" {
+		route = "unmatched"
+	}
+
+	util.ObserveHTTPRequest(c.Method(), route, code, time.Since(start))
+	return err
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/natron-io/tenant-api/util"
+)
+
+// GetTenantTemplates returns the templates of the tenant provisioning keyed by name
+func GetTenantTemplates(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+
+	return c.JSON(util.TenantTemplates)
+}
+
+// ProvisionTenant creates the namespace of the tenant of a team with the objects of a template, the dry run only previews the changes
+func ProvisionTenant(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+
+	var body struct {
+		Team     string `json:"team"`
+		Tenant   string `json:"tenant"`
+		Template string `json:"template"`
+		Cluster  string `json:"cluster"`
+		DryRun   bool   `json:"dry_run"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Team == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid body, team is required",
+		})
+	}
+
+	// the tenant defaults to the tenant the team logs in to
+	if body.Tenant == "" {
+		teamTenants := util.GetTenantsOfGroups([]string{body.Team})
+		if len(teamTenants) != 1 {
+			return c.Status(400).JSON(fiber.Map{
+				"message": "Invalid body, tenant is required for a team mapped to several tenants",
+			})
+		}
+		body.Tenant = teamTenants[0]
+	}
+	if body.Template == "" {
+		body.Template = util.DefaultTenantTemplate
+	}
+	if body.Cluster == "" {
+		body.Cluster = util.Clusters[0].Name
+	}
+
+	provision, err := util.ProvisionTenant(c.Context(), util.TenantProvision{
+		Tenant:   body.Tenant,
+		Team:     body.Team,
+		Cluster:  body.Cluster,
+		Template: body.Template,
+		DryRun:   body.DryRun,
+	})
+	if errors.Is(err, util.ErrInvalidProvision) || errors.Is(err, util.ErrTemplateNotFound) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	if !provision.DryRun {
+		actor, _ := getClaims(c)["sub"].(string)
+		if err := util.RecordProvisionAuditEvent(provision, "tenant.provisioned", actor, time.Now()); err != nil {
+			util.ErrorLogger.Printf("%s", err)
+		}
+		util.InfoLogger.Printf("tenant %s of team %s provisioned in cluster %s by %s", provision.Tenant, provision.Team, provision.Cluster, actor)
+	}
+
+	return c.JSON(provision)
+}
+
+// DeprovisionTenant deletes the namespace of a provisioned tenant with all its objects and stored data, the dry run only previews the deleted objects
+func DeprovisionTenant(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+
+	cluster := c.Query("cluster", util.Clusters[0].Name)
+	actor, _ := getClaims(c)["sub"].(string)
+	provision, err := util.DeprovisionTenant(c.Context(), util.TenantProvision{
+		Tenant:  c.Params("tenant"),
+		Cluster: cluster,
+		DryRun:  c.Query("dry_run") == "true",
+	}, actor, time.Now())
+	if errors.Is(err, util.ErrInvalidProvision) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if errors.Is(err, util.ErrTenantNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Tenant not found",
+		})
+	}
+	if errors.Is(err, util.ErrTenantNotProvisioned) {
+		return c.Status(409).JSON(fiber.Map{
+			"message": "Tenant was not provisioned by the tenant-api",
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	if !provision.DryRun {
+		if err := util.RecordProvisionAuditEvent(provision, "tenant.deprovisioned", actor, time.Now()); err != nil {
+			util.ErrorLogger.Printf("%s", err)
+		}
+		util.InfoLogger.Printf("tenant %s deprovisioned in cluster %s by %s", provision.Tenant, provision.Cluster, actor)
+	}
+
+	return c.JSON(provision)
+}
@@ -4,8 +4,60 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/natron-io/tenant-api/util"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
+// GetQuotas returns the hard limit, usage, remaining and percent used of every resource of the resource quotas of a tenant
+func GetQuotas(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	quota, err := util.GetRessourceQuota(tenant)
+	if apierrors.IsNotFound(err) {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Resource quota not found",
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	quotaUsage := util.GetQuotaUsage(quota)
+
+	if byCluster(c) {
+		clusterQuotas, err := util.GetRessourceQuotaByCluster(tenant)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+
+		clusterQuotaUsages := make(map[string]util.TenantQuotaUsage)
+		for clusterName, clusterQuota := range clusterQuotas {
+			clusterQuotaUsages[clusterName] = util.GetQuotaUsage(clusterQuota)
+		}
+		return c.JSON(clusterResponse("", clusterQuotaUsages, quotaUsage))
+	}
+
+	return c.JSON(quotaUsage)
+}
+
 // GetCPUQuota returns the CPU quota of a tenant by the label at the tenant config namespace
 func GetCPUQuota(c *fiber.Ctx) error {
 
@@ -33,6 +85,22 @@ func GetCPUQuota(c *fiber.Ctx) error {
 
 	cpuQuota := quota.Spec.Hard.Cpu().MilliValue()
 
+	if byCluster(c) {
+		clusterQuotas, err := util.GetRessourceQuotaByCluster(tenant)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+
+		clusterCPUQuotas := make(map[string]int64)
+		for clusterName, clusterQuota := range clusterQuotas {
+			clusterCPUQuotas[clusterName] = clusterQuota.Spec.Hard.Cpu().MilliValue()
+		}
+		return c.JSON(clusterResponse("", clusterCPUQuotas, cpuQuota))
+	}
+
 	return c.JSON(cpuQuota)
 }
 
@@ -63,6 +131,22 @@ func GetMemoryQuota(c *fiber.Ctx) error {
 
 	memoryQuota := quota.Spec.Hard.Memory().Value()
 
+	if byCluster(c) {
+		clusterQuotas, err := util.GetRessourceQuotaByCluster(tenant)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+
+		clusterMemoryQuotas := make(map[string]int64)
+		for clusterName, clusterQuota := range clusterQuotas {
+			clusterMemoryQuotas[clusterName] = clusterQuota.Spec.Hard.Memory().Value()
+		}
+		return c.JSON(clusterResponse("", clusterMemoryQuotas, memoryQuota))
+	}
+
 	return c.JSON(memoryQuota)
 }
 
@@ -100,6 +184,29 @@ func GetStorageQuota(c *fiber.Ctx) error {
 		})
 	}
 
+	storageQuotaParsed := parseStorageQuota(quota, storageClasses)
+
+	if byCluster(c) {
+		clusterQuotas, err := util.GetRessourceQuotaByCluster(tenant)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+
+		clusterStorageQuotas := make(map[string]map[string]int64)
+		for clusterName, clusterQuota := range clusterQuotas {
+			clusterStorageQuotas[clusterName] = parseStorageQuota(clusterQuota, storageClasses)
+		}
+		return c.JSON(clusterResponse("", clusterStorageQuotas, storageQuotaParsed))
+	}
+
+	return c.JSON(storageQuotaParsed)
+}
+
+// parseStorageQuota returns the storage quota of each storage class of the resource quota
+func parseStorageQuota(quota v1.ResourceQuota, storageClasses []string) map[string]int64 {
 	storageQuota := quota.Spec.Hard
 
 	// get first element of storageQuota
@@ -110,7 +217,6 @@ func GetStorageQuota(c *fiber.Ctx) error {
 
 	storageQuotaParsed := make(map[string]int64)
 	for _, storageClass := range storageClasses {
-		util.InfoLogger.Printf("%s", storageClass)
 		storageQuotaString := v1.ResourceName(storageClass + ".storageclass.storage.k8s.io/requests.storage")
 		if _, ok := storageQuotaMap[storageQuotaString]; ok {
 			storageQuotaParsed[storageClass] = storageQuotaMap[storageQuotaString]
@@ -119,6 +225,5 @@ func GetStorageQuota(c *fiber.Ctx) error {
 		}
 	}
 
-	// check if storageClass string is in storageQuota
-	return c.JSON(storageQuotaParsed)
+	return storageQuotaParsed
 }
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/natron-io/tenant-api/util"
+)
+
+// GetQuotaRequests returns the quota requests of a tenant, filtered by the status query
+func GetQuotaRequests(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	requests, err := util.GetQuotaRequests(tenant, c.Query("status"))
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(requests)
+}
+
+// GetAllQuotaRequests returns the quota requests of all tenants for the platform admins, filtered by the status query
+func GetAllQuotaRequests(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+
+	requests, err := util.GetQuotaRequests("", c.Query("status"))
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(requests)
+}
+
+// GetQuotaRequest returns a quota request of a tenant
+func GetQuotaRequest(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	request, err := util.GetQuotaRequest(tenant, c.Params("request"))
+	if errors.Is(err, util.ErrQuotaRequestNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Quota request not found",
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(request)
+}
+
+// CreateQuotaRequest submits a change of the hard limits of a resource quota of a tenant for approval
+func CreateQuotaRequest(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	var body struct {
+		Cluster string            `json:"cluster"`
+		Quota   string            `json:"quota"`
+		Hard    map[string]string `json:"hard"`
+		Reason  string            `json:"reason"`
+	}
+	if err := c.BodyParser(&body); err != nil || len(body.Hard) == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid body, hard is required",
+		})
+	}
+
+	requestedBy, _ := getClaims(c)["sub"].(string)
+	request, err := util.CreateQuotaRequest(util.QuotaRequest{
+		Tenant:      tenant,
+		Cluster:     body.Cluster,
+		Quota:       body.Quota,
+		Hard:        body.Hard,
+		Reason:      body.Reason,
+		RequestedBy: requestedBy,
+	}, time.Now())
+	if errors.Is(err, util.ErrInvalidQuotaRequest) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	util.InfoLogger.Printf("quota request %s of tenant %s created by %s", request.ID, tenant, requestedBy)
+	return c.Status(201).JSON(request)
+}
+
+// ApproveQuotaRequest approves a pending quota request of a tenant and applies it to the resource quota
+func ApproveQuotaRequest(c *fiber.Ctx) error {
+	return decideQuotaRequest(c, util.QuotaRequestApproved)
+}
+
+// RejectQuotaRequest rejects a pending quota request of a tenant
+func RejectQuotaRequest(c *fiber.Ctx) error {
+	return decideQuotaRequest(c, util.QuotaRequestRejected)
+}
+
+// decideQuotaRequest approves or rejects a quota request with the optional comment of the body
+func decideQuotaRequest(c *fiber.Ctx, status string) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+
+	var body struct {
+		Comment string `json:"comment"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"message": "Invalid body",
+			})
+		}
+	}
+
+	decidedBy, _ := getClaims(c)["sub"].(string)
+	var request util.QuotaRequest
+	var err error
+	if status == util.QuotaRequestApproved {
+		request, err = util.ApproveQuotaRequest(c.Context(), tenant, c.Params("request"), decidedBy, body.Comment, time.Now())
+	} else {
+		request, err = util.RejectQuotaRequest(tenant, c.Params("request"), decidedBy, body.Comment, time.Now())
+	}
+	if errors.Is(err, util.ErrQuotaRequestNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "Quota request not found",
+		})
+	}
+	if errors.Is(err, util.ErrQuotaRequestDecided) {
+		return c.Status(409).JSON(fiber.Map{
+			"message": "Quota request is already decided",
+		})
+	}
+	if errors.Is(err, util.ErrInvalidQuotaRequest) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	util.InfoLogger.Printf("quota request %s of tenant %s %s by %s", request.ID, tenant, status, decidedBy)
+	return c.JSON(request)
+}
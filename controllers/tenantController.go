@@ -37,6 +37,18 @@ func GetPods(c *fiber.Ctx) error {
 		})
 	}
 
+	// results of each cluster with the aggregated total
+	if byCluster(c) {
+		clusterTenantPods, err := util.GetPodsByClusterByTenant(tenants)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		return c.JSON(clusterResponse(tenant, clusterTenantPods, util.MergeStringsByTenant(clusterTenantPods)))
+	}
+
 	var tenantPods map[string][]string
 	var err error
 	if tenant == "" {
@@ -76,6 +88,18 @@ func GetPVCs(c *fiber.Ctx) error {
 		})
 	}
 
+	// results of each cluster with the aggregated total
+	if byCluster(c) {
+		clusterTenantPVCs, err := util.GetPVCsByClusterByTenantByStorageClass(tenants)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		return c.JSON(clusterResponse(tenant, clusterTenantPVCs, util.MergeStringsByTenantByKey(clusterTenantPVCs)))
+	}
+
 	// create a map for each tenant with a added memory requests
 	tenantPVCsByStorageClass, err := util.GetPVCsByTenantByStorageClass(tenants)
 	if err != nil {
@@ -108,6 +132,18 @@ func GetCPURequestsSum(c *fiber.Ctx) error {
 		})
 	}
 
+	// results of each cluster with the aggregated total
+	if byCluster(c) {
+		clusterTenantCPURequests, err := util.GetCPURequestsSumByClusterByTenant(tenants)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		return c.JSON(clusterResponse(tenant, clusterTenantCPURequests, util.SumInt64ByTenant(clusterTenantCPURequests)))
+	}
+
 	// create a map for each tenant with a added cpu requests
 	tenantCPURequests, err := util.GetCPURequestsSumByTenant(tenants)
 	if err != nil {
@@ -140,6 +176,18 @@ func GetMemoryRequestsSum(c *fiber.Ctx) error {
 		})
 	}
 
+	// results of each cluster with the aggregated total
+	if byCluster(c) {
+		clusterTenantMemoryRequests, err := util.GetMemoryRequestsSumByClusterByTenant(tenants)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		return c.JSON(clusterResponse(tenant, clusterTenantMemoryRequests, util.SumInt64ByTenant(clusterTenantMemoryRequests)))
+	}
+
 	// create a map for each tenant with a added memory requests
 	tenantMemoryRequests, err := util.GetMemoryRequestsSumByTenant(tenants)
 	if err != nil {
@@ -172,6 +220,18 @@ func GetStorageRequestsSum(c *fiber.Ctx) error {
 		})
 	}
 
+	// results of each cluster with the aggregated total
+	if byCluster(c) {
+		clusterStorageRequestsSum, err := util.GetStorageRequestsSumByClusterByTenant(tenants)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		return c.JSON(clusterResponse(tenant, clusterStorageRequestsSum, util.SumInt64ByTenantByKey(clusterStorageRequestsSum)))
+	}
+
 	// create a map for each tenant with a map of storage classes with calculated pvcs in it
 	storageRequestsSum, err := util.GetStorageRequestsSumByTenant(tenants)
 	if err != nil {
@@ -204,6 +264,18 @@ func GetIngresses(c *fiber.Ctx) error {
 		})
 	}
 
+	// results of each cluster with the aggregated total
+	if byCluster(c) {
+		clusterTenantIngressRequests, err := util.GetIngressRequestsSumByClusterByTenant(tenants)
+		if err != nil {
+			util.ErrorLogger.Printf("%s", err)
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+			})
+		}
+		return c.JSON(clusterResponse(tenant, clusterTenantIngressRequests, util.MergeStringsByTenant(clusterTenantIngressRequests)))
+	}
+
 	// create a map for each tenant with a map of storage classes with calculated pvcs in it
 	tenantIngressRequests, err := util.GetIngressRequestsSumByTenant(tenants)
 	if err != nil {
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/natron-io/tenant-api/util"
+)
+
+// GetAPITokens returns the API tokens of a tenant without the tokens themselves
+func GetAPITokens(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	apiTokens, err := util.GetAPITokens(tenant)
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(apiTokens)
+}
+
+// CreateAPIToken creates an API token of a tenant with the scopes, the token is only returned in this response
+func CreateAPIToken(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	var body struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn string   `json:"expires_in"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Name == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"message": "Invalid body, name is required",
+		})
+	}
+
+	// the tokens do not expire by default
+	now := time.Now()
+	var expiresAt *time.Time
+	if body.ExpiresIn != "" {
+		expiresIn, err := time.ParseDuration(body.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			return c.Status(400).JSON(fiber.Map{
+				"message": "Invalid expires_in, must be a positive duration like 720h",
+			})
+		}
+		expiry := now.Add(expiresIn).UTC()
+		expiresAt = &expiry
+	}
+
+	createdBy, _ := getClaims(c)["sub"].(string)
+	apiToken, token, err := util.CreateAPIToken(tenant, body.Name, body.Scopes, createdBy, expiresAt, now)
+	if errors.Is(err, util.ErrInvalidScope) {
+		return c.Status(400).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	util.InfoLogger.Printf("API token %s of tenant %s created by %s", apiToken.ID, tenant, createdBy)
+	return c.Status(201).JSON(fiber.Map{
+		"token":     token,
+		"api_token": apiToken,
+	})
+}
+
+// DeleteAPIToken revokes an API token of a tenant
+func DeleteAPIToken(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	err := util.DeleteAPIToken(tenant, c.Params("token"))
+	if errors.Is(err, util.ErrAPITokenNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"message": "API token not found",
+		})
+	}
+	if err != nil {
+		util.ErrorLogger.Printf("%s", err)
+		return c.Status(500).JSON(fiber.Map{
+			"message": "Internal Server Error",
+		})
+	}
+
+	util.InfoLogger.Printf("API token %s of tenant %s revoked", c.Params("token"), tenant)
+	return c.JSON(fiber.Map{
+		"message": "API token revoked",
+	})
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/natron-io/tenant-api/util"
+)
+
+// GetUsageSum returns the sum of the cpu and memory usage by authenticated users tenants
+func GetUsageSum(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if tenant != "" && !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	clusterTenantPodUsage, err := util.GetPodUsageByClusterByTenant(tenants)
+	if err != nil {
+		return usageError(c, err)
+	}
+
+	// results of each cluster with the aggregated total
+	if byCluster(c) {
+		clusterTenantUsage := make(map[string]map[string]util.PodUsage)
+		for cluster, tenantPodUsage := range clusterTenantPodUsage {
+			clusterTenantUsage[cluster] = util.GetUsageSumByTenant(tenantPodUsage)
+		}
+		return c.JSON(clusterResponse(tenant, clusterTenantUsage, util.GetUsageSumByTenant(util.SumPodUsageByTenant(clusterTenantPodUsage))))
+	}
+
+	return c.JSON(tenantResponse(tenant, util.GetUsageSumByTenant(util.SumPodUsageByTenant(clusterTenantPodUsage))))
+}
+
+// GetPodUsage returns the cpu and memory usage of each pod by authenticated users tenants
+func GetPodUsage(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if tenant != "" && !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	clusterTenantPodUsage, err := util.GetPodUsageByClusterByTenant(tenants)
+	if err != nil {
+		return usageError(c, err)
+	}
+
+	// results of each cluster with the aggregated total
+	if byCluster(c) {
+		return c.JSON(clusterResponse(tenant, clusterTenantPodUsage, util.SumPodUsageByTenant(clusterTenantPodUsage)))
+	}
+
+	return c.JSON(tenantResponse(tenant, util.SumPodUsageByTenant(clusterTenantPodUsage)))
+}
+
+// GetEfficiency returns the cpu and memory usage compared to the requests by authenticated users tenants
+func GetEfficiency(c *fiber.Ctx) error {
+
+	util.InfoLogger.Printf("%s %s %s", c.IP(), c.Method(), c.Path())
+	tenant := c.Params("tenant")
+	tenants := CheckAuth(c)
+	if len(tenants) == 0 {
+		return c.Status(401).JSON(fiber.Map{
+			"message": "Unauthorized",
+		})
+	}
+	if tenant != "" && !util.Contains(tenant, tenants) {
+		return c.Status(403).JSON(fiber.Map{
+			"message": "Forbidden",
+		})
+	}
+
+	clusterTenantEfficiency, err := util.GetEfficiencyByClusterByTenant(tenants)
+	if err != nil {
+		return usageError(c, err)
+	}
+
+	// results of each cluster with the aggregated total
+	if byCluster(c) {
+		return c.JSON(clusterResponse(tenant, clusterTenantEfficiency, util.SumEfficiencyByTenant(clusterTenantEfficiency)))
+	}
+
+	return c.JSON(tenantResponse(tenant, util.SumEfficiencyByTenant(clusterTenantEfficiency)))
+}
+
+// usageError returns 503 if the metrics API of a cluster is not available
+func usageError(c *fiber.Ctx, err error) error {
+	util.ErrorLogger.Printf("%s", err)
+	if errors.Is(err, util.ErrMetricsUnavailable) {
+		return c.Status(503).JSON(fiber.Map{
+			"message": "Metrics API is not available",
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{
+		"message": "Internal Server Error",
+	})
+}
@@ -9,10 +9,21 @@ import (
 
 // Routes - Define all routes
 func Setup(app *fiber.App, clientset *kubernetes.Clientset) {
+	// Health
+	app.Get("/healthz", controllers.GetHealth)
+	app.Get("/readyz", controllers.GetReadiness)
+
+	// Metrics
+	app.Get("/metrics", controllers.GetMetrics)
+
 	// Auth
-	app.Post("/login/github", controllers.FrontendGithubLogin)
-	app.Get("/login/github", controllers.GithubLogin)
-	app.Get("/login/github/callback", controllers.GithubCallback)
+	app.Post("/login/:provider/state", controllers.FrontendLoginState)
+	app.Post("/login/:provider", controllers.FrontendLogin)
+	app.Get("/login/:provider", controllers.Login)
+	app.Get("/login/:provider/callback", controllers.Callback)
+	app.Post("/refresh", controllers.Refresh)
+	app.Post("/logout", controllers.Logout)
+	app.Get("/.well-known/jwks.json", controllers.GetJWKS)
 
 	// API
 	api := app.Group("/api")
@@ -23,9 +34,17 @@ func Setup(app *fiber.App, clientset *kubernetes.Clientset) {
 		v1.Get("/notifications", controllers.GetNotifications)
 	}
 
+	// Clusters
+	v1.Get("/clusters", controllers.GetClusters)
+
 	// Tenants
 	v1.Get("/tenants", controllers.GetTenants)
 
+	// Provisioning of the tenants by platform admins
+	v1.Get("/templates", controllers.RequirePlatformAdmin, controllers.GetTenantTemplates)
+	v1.Post("/tenants", controllers.RequirePlatformAdmin, controllers.ProvisionTenant)
+	v1.Delete("/tenants/:tenant", controllers.RequirePlatformAdmin, controllers.DeprovisionTenant)
+
 	// Specific Tenant
 	v1.Get(":tenant/pods", controllers.GetPods)
 	v1.Get(":tenant/pvcs", controllers.GetPVCs)
@@ -37,16 +56,64 @@ func Setup(app *fiber.App, clientset *kubernetes.Clientset) {
 	requests.Get("/memory", controllers.GetMemoryRequestsSum)
 	requests.Get("/storage", controllers.GetStorageRequestsSum)
 
-	// Per tenant
-	costs := v1.Group(":tenant/costs")
+	// Actual usage from the metrics API
+	usage := v1.Group(":tenant/usage")
+	usage.Get("/", controllers.GetUsageSum)
+	usage.Get("/pods", controllers.GetPodUsage)
+	usage.Get("/efficiency", controllers.GetEfficiency)
+
+	// Costs of all tenants
+	v1.Get("/costs", controllers.RequireRole(util.RoleBilling), controllers.GetCostSummary)
+
+	// Costs of the fleet for platform admins
+	v1.Get("/costs/fleet", controllers.RequirePlatformAdmin, controllers.GetFleetCosts)
+
+	// Quota requests and audit trail of all tenants for platform admins
+	v1.Get("/quotas/requests", controllers.RequirePlatformAdmin, controllers.GetAllQuotaRequests)
+	v1.Get("/audit", controllers.RequirePlatformAdmin, controllers.GetAllAuditEvents)
+
+	// Per tenant, costs and invoices are restricted to billing users
+	costs := v1.Group(":tenant/costs", controllers.RequireRole(util.RoleBilling))
+	costs.Get("/", controllers.GetCostSummary)
 	costs.Get("/cpu", controllers.GetCPUCostSum)
 	costs.Get("/memory", controllers.GetMemoryCostSum)
 	costs.Get("/storage", controllers.GetStorageCostSum)
 	costs.Get("/ingress", controllers.GetIngressCostSum)
+	costs.Get("/history", controllers.GetCostHistory)
+
+	// Invoices
+	invoices := v1.Group(":tenant/invoices", controllers.RequireRole(util.RoleBilling))
+	invoices.Get("/", controllers.GetInvoices)
+	invoices.Post("/", controllers.RequirePlatformAdmin, controllers.CloseInvoice)
+	invoices.Get("/:invoice", controllers.GetInvoice)
+
+	// Monthly budget with alerts, set by the admins of the tenant
+	budget := v1.Group(":tenant/budget")
+	budget.Get("/", controllers.RequireRole(util.RoleBilling), controllers.GetBudget)
+	budget.Put("/", controllers.RequireRole(util.RoleAdmin), controllers.SetBudget)
+	budget.Delete("/", controllers.RequireRole(util.RoleAdmin), controllers.DeleteBudget)
+
+	// API tokens of the tenant, managed by its admins
+	tokens := v1.Group(":tenant/tokens", controllers.RequireRole(util.RoleAdmin))
+	tokens.Get("/", controllers.GetAPITokens)
+	tokens.Post("/", controllers.CreateAPIToken)
+	tokens.Delete("/:token", controllers.DeleteAPIToken)
 
 	// Quotas
 	quotas := v1.Group(":tenant/quotas")
+	quotas.Get("/", controllers.GetQuotas)
 	quotas.Get("/cpu", controllers.GetCPUQuota)
 	quotas.Get("/memory", controllers.GetMemoryQuota)
 	quotas.Get("/storage", controllers.GetStorageQuota)
+
+	// Quota requests submitted by developers and decided by platform admins
+	quotaRequests := v1.Group(":tenant/quotas/requests", controllers.RequireRole(util.RoleDeveloper))
+	quotaRequests.Get("/", controllers.GetQuotaRequests)
+	quotaRequests.Post("/", controllers.CreateQuotaRequest)
+	quotaRequests.Get("/:request", controllers.GetQuotaRequest)
+	quotaRequests.Post("/:request/approve", controllers.RequirePlatformAdmin, controllers.ApproveQuotaRequest)
+	quotaRequests.Post("/:request/reject", controllers.RequirePlatformAdmin, controllers.RejectQuotaRequest)
+
+	// Audit trail of the tenant
+	v1.Get(":tenant/audit", controllers.RequireRole(util.RoleAdmin), controllers.GetAuditEvents)
 }
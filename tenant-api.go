@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"flag"
 	"os"
 	"time"
 
@@ -24,38 +25,74 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/template/html"
+	"github.com/natron-io/tenant-api/controllers"
 	"github.com/natron-io/tenant-api/routes"
 	"github.com/natron-io/tenant-api/util"
 	"github.com/slack-go/slack"
-
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/util/flowcontrol"
 )
 
 func init() {
 	util.InitLoggers()
 	util.Status = "Running"
 
-	// creates the in-cluster config with ratelimiter to qps: 20 and burst: 50
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		util.ErrorLogger.Println("Error creating in-cluster config")
+	// run outside of the cluster with a kubeconfig and context
+	flag.StringVar(&util.KUBECONFIG, "kubeconfig", os.Getenv("KUBECONFIG"), "path to the kubeconfig file, uses the in-cluster config if not set")
+	flag.StringVar(&util.KUBE_CONTEXT, "context", os.Getenv("KUBE_CONTEXT"), "kubeconfig context to use")
+	flag.Parse()
+
+	// load util config envs
+	if err := util.LoadEnv(); err != nil {
+		util.ErrorLogger.Println("Error loading env variables")
 		os.Exit(1)
 	}
 
-	config.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(20, 50)
+	// register the metrics before the first requests to the clusters
+	util.InitMetrics()
 
-	// creates the clientset
-	util.Clientset, err = kubernetes.NewForConfig(config)
-	if err != nil {
-		util.ErrorLogger.Printf("Error creating clientset: %v", err)
+	// creates the identity providers for the login
+	if err := util.InitIdentityProviders(); err != nil {
+		util.ErrorLogger.Printf("Error creating identity providers: %v", err)
+		util.Status = "Error: " + err.Error()
 		os.Exit(1)
 	}
 
-	// load util config envs
-	if err := util.LoadEnv(); err != nil {
-		util.ErrorLogger.Println("Error loading env variables")
+	// open the embedded database for the cost history
+	if err := util.InitStore(); err != nil {
+		util.ErrorLogger.Printf("Error opening database: %v", err)
+		util.Status = "Error: cannot open database"
+		os.Exit(1)
+	}
+
+	// load the keys which sign the tokens
+	if err := util.InitSigningKeys(); err != nil {
+		util.ErrorLogger.Printf("Error loading signing keys: %v", err)
+		util.Status = "Error: " + err.Error()
+		os.Exit(1)
+	}
+
+	// creates the clientsets of the clusters
+	if err := util.InitClusters(); err != nil {
+		util.ErrorLogger.Printf("Error creating clusters: %v", err)
+		util.Status = "Error: " + err.Error()
+		os.Exit(1)
+	}
+
+	// start the shared informers and wait for the caches to be synced
+	if err := util.InitInformers(make(chan struct{})); err != nil {
+		util.ErrorLogger.Printf("Error syncing informer caches: %v", err)
+		util.Status = "Error: informer caches not synced"
+		os.Exit(1)
+	}
+
+	// load the templates of the tenant provisioning
+	if err := util.LoadTenantTemplates(); err != nil {
+		util.ErrorLogger.Printf("Error loading tenant templates: %v", err)
+		util.Status = "Error: " + err.Error()
+		os.Exit(1)
+	}
+
+	// load the pricing and check if every storage class in the cluster has a cost
+	if err := util.LoadPricing(); err != nil {
 		os.Exit(1)
 	}
 }
@@ -72,8 +109,11 @@ func main() {
 		Views: engine,
 	})
 
+	// count the requests and their latency by route
+	app.Use(controllers.RecordMetrics)
+
 	app.Use(cors.New(cors.Config{
-		AllowMethods:     "GET",
+		AllowMethods:     "GET,POST,PUT,DELETE",
 		AllowCredentials: true,
 		AllowOrigins:     util.CORS,
 	}))
@@ -100,6 +140,20 @@ func main() {
 
 	routes.Setup(app, util.Clientset)
 
+	// reload the pricing file on changes
+	go util.RunPricingWatcher(make(chan struct{}))
+
+	// record the cost history in the background, with Prometheus the history is queried instead
+	if util.DATASOURCE != util.DatasourcePrometheus {
+		go util.RunCostSampler(make(chan struct{}))
+	}
+	// close the invoices of the last month in the background
+	go util.RunInvoiceCloser(make(chan struct{}))
+	// delete the expired sessions in the background
+	go util.RunSessionPruner(make(chan struct{}))
+	// alert the tenants whose projected spend reaches a budget threshold
+	go util.RunBudgetEvaluator(make(chan struct{}))
+
 	util.InfoLogger.Println("Tenant API is running on port 8000")
 
 	if err := app.Listen(":8000"); err != nil {
@@ -0,0 +1,85 @@
+package util
+
+// SumInt64ByTenant sums the values of each tenant over all clusters
+func SumInt64ByTenant(clusterTenantValues map[string]map[string]int64) map[string]int64 {
+	tenantValues := make(map[string]int64)
+	for _, clusterValues := range clusterTenantValues {
+		for tenant, value := range clusterValues {
+			tenantValues[tenant] += value
+		}
+	}
+	return tenantValues
+}
+
+// SumFloat64ByTenant sums the values of each tenant over all clusters
+func SumFloat64ByTenant(clusterTenantValues map[string]map[string]float64) map[string]float64 {
+	tenantValues := make(map[string]float64)
+	for _, clusterValues := range clusterTenantValues {
+		for tenant, value := range clusterValues {
+			tenantValues[tenant] += value
+		}
+	}
+	return tenantValues
+}
+
+// SumInt64ByTenantByKey sums the values of each key of each tenant over all clusters
+func SumInt64ByTenantByKey(clusterTenantValues map[string]map[string]map[string]int64) map[string]map[string]int64 {
+	tenantValues := make(map[string]map[string]int64)
+	for _, clusterValues := range clusterTenantValues {
+		for tenant, values := range clusterValues {
+			if tenantValues[tenant] == nil {
+				tenantValues[tenant] = make(map[string]int64)
+			}
+			for key, value := range values {
+				tenantValues[tenant][key] += value
+			}
+		}
+	}
+	return tenantValues
+}
+
+// SumFloat64ByTenantByKey sums the values of each key of each tenant over all clusters
+func SumFloat64ByTenantByKey(clusterTenantValues map[string]map[string]map[string]float64) map[string]map[string]float64 {
+	tenantValues := make(map[string]map[string]float64)
+	for _, clusterValues := range clusterTenantValues {
+		for tenant, values := range clusterValues {
+			if tenantValues[tenant] == nil {
+				tenantValues[tenant] = make(map[string]float64)
+			}
+			for key, value := range values {
+				tenantValues[tenant][key] += value
+			}
+		}
+	}
+	return tenantValues
+}
+
+// MergeStringsByTenant appends the lists of each tenant of all clusters
+func MergeStringsByTenant(clusterTenantValues map[string]map[string][]string) map[string][]string {
+	tenantValues := make(map[string][]string)
+	for _, clusterName := range GetClusterNames() {
+		for tenant, values := range clusterTenantValues[clusterName] {
+			if tenantValues[tenant] == nil {
+				tenantValues[tenant] = make([]string, 0)
+			}
+			tenantValues[tenant] = append(tenantValues[tenant], values...)
+		}
+	}
+	return tenantValues
+}
+
+// MergeStringsByTenantByKey appends the lists of each key of each tenant of all clusters
+func MergeStringsByTenantByKey(clusterTenantValues map[string]map[string]map[string][]string) map[string]map[string][]string {
+	tenantValues := make(map[string]map[string][]string)
+	for _, clusterName := range GetClusterNames() {
+		for tenant, values := range clusterTenantValues[clusterName] {
+			if tenantValues[tenant] == nil {
+				tenantValues[tenant] = make(map[string][]string)
+			}
+			for key, keyValues := range values {
+				tenantValues[tenant][key] = append(tenantValues[tenant][key], keyValues...)
+			}
+		}
+	}
+	return tenantValues
+}
@@ -0,0 +1,202 @@
+package util
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	apiTokenBucket = "api_tokens"
+	// APITokenPrefix distinguishes the API tokens from the JWTs of the users
+	APITokenPrefix = "tat_"
+
+	// ScopeReadResources allows to read the resources, requests and quotas of the tenant
+	ScopeReadResources = "read:resources"
+	// ScopeReadCosts allows to read the costs and invoices of the tenant
+	ScopeReadCosts = "read:costs"
+)
+
+var (
+	// scopeRoles are the roles of the routes each scope grants
+	scopeRoles = map[string]Role{
+		ScopeReadResources: RoleViewer,
+		ScopeReadCosts:     RoleBilling,
+	}
+
+	ErrAPITokenNotFound = errors.New("api token not found")
+	ErrInvalidAPIToken  = errors.New("invalid or expired api token")
+	ErrInvalidScope     = errors.New("invalid scope")
+)
+
+// APIToken is a long-lived token of a tenant for automation
+type APIToken struct {
+	ID        string     `json:"id"`
+	Tenant    string     `json:"tenant"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// storedAPIToken is the API token as stored in the database, the token is only stored as hash
+type storedAPIToken struct {
+	APIToken
+	TokenHash string `json:"token_hash"`
+}
+
+// Grants returns true if a scope of the API token grants the role, the scopes do not imply other roles
+func (token APIToken) Grants(role Role) bool {
+	for _, scope := range token.Scopes {
+		if scopeRoles[scope] == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsScope returns true if the scope is known
+func IsScope(scope string) bool {
+	_, ok := scopeRoles[scope]
+	return ok
+}
+
+// CreateAPIToken stores a new API token of the tenant with the scopes and returns it with the token, which is shown only once
+func CreateAPIToken(tenant string, name string, scopes []string, createdBy string, expiresAt *time.Time, now time.Time) (APIToken, string, error) {
+	if len(scopes) == 0 {
+		return APIToken{}, "", fmt.Errorf("%w, at least one scope is required", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if !IsScope(scope) {
+			return APIToken{}, "", fmt.Errorf("%w %s", ErrInvalidScope, scope)
+		}
+	}
+
+	id, err := randomToken(12)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+
+	token := APIToken{
+		ID:        id,
+		Tenant:    tenant,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: now.UTC(),
+		ExpiresAt: expiresAt,
+	}
+
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket([]byte(apiTokenBucket)), id, storedAPIToken{APIToken: token, TokenHash: hashToken(secret)})
+	})
+	if err != nil {
+		return APIToken{}, "", err
+	}
+
+	// the token is the prefix, the id and the secret
+	return token, APITokenPrefix + id + "." + secret, nil
+}
+
+// GetAPITokens returns the API tokens of the tenant ordered by their creation
+func GetAPITokens(tenant string) ([]APIToken, error) {
+	tokens := make([]APIToken, 0)
+	err := DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(apiTokenBucket)).ForEach(func(_, value []byte) error {
+			var token storedAPIToken
+			if err := json.Unmarshal(value, &token); err != nil {
+				return err
+			}
+			if token.Tenant == tenant {
+				tokens = append(tokens, token.APIToken)
+			}
+			return nil
+		})
+	})
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens, err
+}
+
+// DeleteAPIToken revokes the API token of the tenant
+func DeleteAPIToken(tenant string, id string) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		tokens := tx.Bucket([]byte(apiTokenBucket))
+		token, err := getStoredAPIToken(tokens, id)
+		if err != nil {
+			return err
+		}
+		if token.Tenant != tenant {
+			return ErrAPITokenNotFound
+		}
+		return tokens.Delete([]byte(id))
+	})
+}
+
+// DeleteTenantAPITokens revokes all API tokens of the tenant and returns them, the dry run only returns them
+func DeleteTenantAPITokens(tenant string, dryRun bool) ([]APIToken, error) {
+	tokens, err := GetAPITokens(tenant)
+	if err != nil || dryRun {
+		return tokens, err
+	}
+
+	return tokens, DB.Update(func(tx *bolt.Tx) error {
+		for _, token := range tokens {
+			if err := tx.Bucket([]byte(apiTokenBucket)).Delete([]byte(token.ID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// VerifyAPIToken returns the API token of the token if it is valid and has not expired
+func VerifyAPIToken(tokenString string, now time.Time) (APIToken, error) {
+	parts := strings.SplitN(strings.TrimPrefix(tokenString, APITokenPrefix), ".", 2)
+	if !strings.HasPrefix(tokenString, APITokenPrefix) || len(parts) != 2 {
+		return APIToken{}, ErrInvalidAPIToken
+	}
+
+	var token storedAPIToken
+	err := DB.View(func(tx *bolt.Tx) error {
+		var err error
+		token, err = getStoredAPIToken(tx.Bucket([]byte(apiTokenBucket)), parts[0])
+		return err
+	})
+	if errors.Is(err, ErrAPITokenNotFound) {
+		return APIToken{}, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(parts[1])), []byte(token.TokenHash)) != 1 ||
+		(token.ExpiresAt != nil && !now.Before(*token.ExpiresAt)) {
+		return APIToken{}, ErrInvalidAPIToken
+	}
+
+	return token.APIToken, nil
+}
+
+// getStoredAPIToken returns the stored API token with the id
+func getStoredAPIToken(tokens *bolt.Bucket, id string) (storedAPIToken, error) {
+	var token storedAPIToken
+	data := tokens.Get([]byte(id))
+	if data == nil {
+		return token, ErrAPITokenNotFound
+	}
+	return token, json.Unmarshal(data, &token)
+}
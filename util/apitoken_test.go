@@ -0,0 +1,167 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCreateAPIToken(t *testing.T) {
+	setupStore(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, _, err := CreateAPIToken("acme", "ci", nil, "jane", nil, now); !errors.Is(err, ErrInvalidScope) {
+		t.Errorf("expected a token without scopes to be invalid, got %v", err)
+	}
+	if _, _, err := CreateAPIToken("acme", "ci", []string{ScopeReadCosts, "write:costs"}, "jane", nil, now); !errors.Is(err, ErrInvalidScope) {
+		t.Errorf("expected an unknown scope to be invalid, got %v", err)
+	}
+
+	apiToken, tokenString, err := CreateAPIToken("acme", "ci", []string{ScopeReadCosts}, "jane", nil, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(tokenString, APITokenPrefix+apiToken.ID+".") {
+		t.Errorf("expected the token to have the prefix and the id, got %s", tokenString)
+	}
+
+	// only the hash of the secret is stored
+	secret := strings.SplitN(tokenString, ".", 2)[1]
+	DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(apiTokenBucket)).Get([]byte(apiToken.ID))
+		if strings.Contains(string(data), secret) {
+			t.Errorf("expected the secret not to be stored")
+		}
+		var stored storedAPIToken
+		if err := json.Unmarshal(data, &stored); err != nil || stored.TokenHash != hashToken(secret) {
+			t.Errorf("expected the hash of the secret to be stored, got %v", err)
+		}
+		return nil
+	})
+	if data, _ := json.Marshal(apiToken); strings.Contains(string(data), "token_hash") {
+		t.Errorf("expected the hash not to be returned, got %s", data)
+	}
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		grants []Role
+		denies []Role
+	}{
+		{scopes: []string{ScopeReadResources}, grants: []Role{RoleViewer}, denies: []Role{RoleBilling, RoleDeveloper, RoleAdmin}},
+		{scopes: []string{ScopeReadCosts}, grants: []Role{RoleBilling}, denies: []Role{RoleViewer, RoleDeveloper, RoleAdmin}},
+		{scopes: []string{ScopeReadResources, ScopeReadCosts}, grants: []Role{RoleViewer, RoleBilling}, denies: []Role{RoleDeveloper, RoleAdmin}},
+	}
+
+	for _, test := range tests {
+		token := APIToken{Scopes: test.scopes}
+		for _, role := range test.grants {
+			if !token.Grants(role) {
+				t.Errorf("expected the scopes %v to grant the role %s", test.scopes, role)
+			}
+		}
+		for _, role := range test.denies {
+			if token.Grants(role) {
+				t.Errorf("expected the scopes %v not to grant the role %s", test.scopes, role)
+			}
+		}
+	}
+}
+
+func TestVerifyAPIToken(t *testing.T) {
+	setupStore(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+
+	apiToken, tokenString, err := CreateAPIToken("acme", "ci", []string{ScopeReadResources}, "jane", &expiresAt, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	verified, err := VerifyAPIToken(tokenString, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if verified.ID != apiToken.ID || verified.Tenant != "acme" || len(verified.Scopes) != 1 || verified.Scopes[0] != ScopeReadResources {
+		t.Errorf("unexpected verified token %+v", verified)
+	}
+
+	invalid := []string{
+		"",
+		strings.TrimPrefix(tokenString, APITokenPrefix),
+		APITokenPrefix + apiToken.ID,
+		APITokenPrefix + apiToken.ID + ".wrong-secret",
+		APITokenPrefix + "unknown." + strings.SplitN(tokenString, ".", 2)[1],
+	}
+	for _, tokenString := range invalid {
+		if _, err := VerifyAPIToken(tokenString, now); !errors.Is(err, ErrInvalidAPIToken) {
+			t.Errorf("expected %q to be invalid, got %v", tokenString, err)
+		}
+	}
+	if _, err := VerifyAPIToken(tokenString, expiresAt); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("expected the expired token to be invalid, got %v", err)
+	}
+}
+
+func TestDeleteAPIToken(t *testing.T) {
+	setupStore(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ci, ciToken, err := CreateAPIToken("acme", "ci", []string{ScopeReadResources}, "jane", nil, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, dashboardToken, err := CreateAPIToken("acme", "dashboard", []string{ScopeReadCosts}, "jane", nil, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, globexToken, err := CreateAPIToken("globex", "ci", []string{ScopeReadResources}, "john", nil, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tokens, err := GetAPITokens("acme")
+	if err != nil || len(tokens) != 2 || tokens[0].Name != "ci" || tokens[1].Name != "dashboard" {
+		t.Errorf("expected the tokens of acme ordered by their creation, got %v %v", tokens, err)
+	}
+
+	// a tenant cannot revoke the tokens of another tenant
+	if err := DeleteAPIToken("globex", ci.ID); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Errorf("expected the token of another tenant to be not found, got %v", err)
+	}
+	if err := DeleteAPIToken("acme", ci.ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := VerifyAPIToken(ciToken, now); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("expected the revoked token to be invalid, got %v", err)
+	}
+	if err := DeleteAPIToken("acme", ci.ID); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Errorf("expected the revoked token to be not found, got %v", err)
+	}
+	if _, err := VerifyAPIToken(dashboardToken, now); err != nil {
+		t.Errorf("expected the other token to stay valid, got %v", err)
+	}
+
+	// the dry run keeps the tokens of the tenant
+	if revoked, err := DeleteTenantAPITokens("acme", true); err != nil || len(revoked) != 1 {
+		t.Errorf("expected the dry run to return the remaining token, got %v %v", revoked, err)
+	}
+	if _, err := VerifyAPIToken(dashboardToken, now); err != nil {
+		t.Errorf("expected the token to stay valid after the dry run, got %v", err)
+	}
+
+	if revoked, err := DeleteTenantAPITokens("acme", false); err != nil || len(revoked) != 1 {
+		t.Errorf("expected the remaining token to be revoked, got %v %v", revoked, err)
+	}
+	if _, err := VerifyAPIToken(dashboardToken, now); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("expected the revoked token to be invalid, got %v", err)
+	}
+	if _, err := VerifyAPIToken(globexToken, now); err != nil {
+		t.Errorf("expected the token of another tenant to stay valid, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const auditBucket = "audit"
+
+// AuditEvent is an immutable record of an action of a user on a tenant
+type AuditEvent struct {
+	ID        string            `json:"id"`
+	Timestamp time.Time         `json:"timestamp"`
+	Actor     string            `json:"actor"`
+	Action    string            `json:"action"`
+	Tenant    string            `json:"tenant"`
+	Target    string            `json:"target"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// RecordAuditEvent appends the event to the audit trail
+func RecordAuditEvent(event AuditEvent) error {
+	id, err := randomToken(12)
+	if err != nil {
+		return err
+	}
+	event.ID = id
+	event.Timestamp = event.Timestamp.UTC()
+
+	return DB.Update(func(tx *bolt.Tx) error {
+		// the keys are sorted by time
+		return putJSON(tx.Bucket([]byte(auditBucket)), event.Timestamp.Format(historyKeyFormat)+"/"+id, event)
+	})
+}
+
+// GetAuditEvents returns the audit events of the tenant or of all tenants if tenant is empty ordered by time
+func GetAuditEvents(tenant string) ([]AuditEvent, error) {
+	events := make([]AuditEvent, 0)
+	err := DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(auditBucket)).ForEach(func(_, value []byte) error {
+			var event AuditEvent
+			if err := json.Unmarshal(value, &event); err != nil {
+				return err
+			}
+			if tenant == "" || event.Tenant == tenant {
+				events = append(events, event)
+			}
+			return nil
+		})
+	})
+	return events, err
+}
@@ -3,10 +3,13 @@ package util
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 var (
@@ -15,80 +18,189 @@ var (
 	letters       = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 	SECRET_KEY    string
 	CALLBACK_URL  string
+	GITHUB_ORG    string
+
+	// base urls of the GitHub login and of the GitHub API
+	githubURL    = "https://github.com"
+	githubAPIURL = "https://api.github.com"
 )
 
-// GetGithubAccessToken returns a github access token
-func GetGithubAccessToken(code string) string {
+// GetGithubAccessToken exchanges the code for a github access token, the code verifier and the redirect url are optional
+func GetGithubAccessToken(code string, redirectURL string, codeVerifier string) (string, error) {
 	requestBodyMap := map[string]string{"client_id": CLIENT_ID, "client_secret": CLIENT_SECRET, "code": code}
-	requestJSON, _ := json.Marshal(requestBodyMap)
+	if redirectURL != "" {
+		requestBodyMap["redirect_uri"] = redirectURL
+	}
+	if codeVerifier != "" {
+		requestBodyMap["code_verifier"] = codeVerifier
+	}
+	requestJSON, err := json.Marshal(requestBodyMap)
+	if err != nil {
+		return "", err
+	}
 
-	req, reqerr := http.NewRequest("POST", "https://github.com/login/oauth/access_token", bytes.NewBuffer(requestJSON))
-	if reqerr != nil {
-		ErrorLogger.Printf("Request creation failed: %s", reqerr)
+	req, err := http.NewRequest("POST", githubURL+"/login/oauth/access_token", bytes.NewBuffer(requestJSON))
+	if err != nil {
+		return "", err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	resp, respErr := http.DefaultClient.Do(req)
-	if respErr != nil {
-		ErrorLogger.Printf("Request failed: %s", respErr)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("github access token request failed: %w", err)
 	}
+	defer resp.Body.Close()
 
-	respbody, _ := ioutil.ReadAll(resp.Body)
-
-	type GithubAccessTokenResponse struct {
-		AccessToken string `json:"access_token"`
-		TokenType   string `json:"token_type"`
-		Scope       string `json:"scope"`
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("github access token request failed with status %d", resp.StatusCode)
 	}
 
-	var githubAccessTokenResponse GithubAccessTokenResponse
-	if err := json.Unmarshal(respbody, &githubAccessTokenResponse); err != nil {
-		ErrorLogger.Printf("Unmarshal failed: %s", err)
+	// github returns the errors with status 200
+	var githubAccessTokenResponse struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		Scope            string `json:"scope"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&githubAccessTokenResponse); err != nil {
+		return "", fmt.Errorf("invalid github access token response: %w", err)
+	}
+	if githubAccessTokenResponse.Error != "" {
+		return "", fmt.Errorf("github access token request failed: %s: %s", githubAccessTokenResponse.Error, githubAccessTokenResponse.ErrorDescription)
+	}
+	if githubAccessTokenResponse.AccessToken == "" {
+		return "", errors.New("github access token response has no access token")
 	}
 
-	return githubAccessTokenResponse.AccessToken
+	return githubAccessTokenResponse.AccessToken, nil
 }
 
-// GetGithubData returns the github data
-func GetGithubData(accessToken string) string {
-	req, reqerr := http.NewRequest("GET", "https://api.github.com/user", nil)
-	if reqerr != nil {
-		ErrorLogger.Printf("Request creation failed: %s", reqerr)
+// GetGithubData returns the github user of the access token as json
+func GetGithubData(accessToken string) (string, error) {
+	req, err := http.NewRequest("GET", githubAPIURL+"/user", nil)
+	if err != nil {
+		return "", err
 	}
 
 	authorizationHeaderValue := fmt.Sprintf("token %s", accessToken)
 	req.Header.Set("Authorization", authorizationHeaderValue)
 
-	resp, respErr := http.DefaultClient.Do(req)
-	if respErr != nil {
-		ErrorLogger.Printf("Request failed: %s", respErr)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("github user request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("github user request failed with status %d", resp.StatusCode)
+	}
+
+	respbody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
 	}
 
-	respbody, _ := ioutil.ReadAll(resp.Body)
+	return string(respbody), nil
+}
+
+// GetGithubTeams returns the slugs of the teams of the user in the GitHub organization, following the pagination of /user/teams
+func GetGithubTeams(accessToken string, organization string) ([]string, error) {
+	githubTeamSlugs := make([]string, 0)
+
+	nextURL := githubAPIURL + "/user/teams?per_page=100"
+	for nextURL != "" {
+		req, err := http.NewRequest("GET", nextURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		authorizationHeaderValue := fmt.Sprintf("token %s", accessToken)
+		req.Header.Set("Authorization", authorizationHeaderValue)
+		req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var githubTeams []struct {
+			Slug         string `json:"slug"`
+			Organization struct {
+				Login string `json:"login"`
+			} `json:"organization"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&githubTeams)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("github teams request failed with status %d", resp.StatusCode)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid github teams: %w", err)
+		}
+
+		// only the teams of the organization are tenants
+		for _, githubTeam := range githubTeams {
+			if strings.EqualFold(githubTeam.Organization.Login, organization) {
+				githubTeamSlugs = append(githubTeamSlugs, githubTeam.Slug)
+			}
+		}
+
+		nextURL = getNextPageURL(resp.Header.Get("Link"))
+	}
 
-	return string(respbody)
+	return githubTeamSlugs, nil
 }
 
-// GetGithubTeams returns the github teams
-func GetGithubTeams(accessToken string) string {
-	req, reqerr := http.NewRequest("GET", "https://api.github.com/orgs/natron-io/teams", nil)
-	if reqerr != nil {
-		ErrorLogger.Printf("Request creation failed: %s", reqerr)
+// GetGithubTeamRole returns the role of the user in the team of the GitHub organization, member or maintainer
+func GetGithubTeamRole(accessToken string, organization string, teamSlug string, username string) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/orgs/%s/teams/%s/memberships/%s", githubAPIURL,
+		url.PathEscape(organization), url.PathEscape(teamSlug), url.PathEscape(username)), nil)
+	if err != nil {
+		return "", err
 	}
 
 	authorizationHeaderValue := fmt.Sprintf("token %s", accessToken)
 	req.Header.Set("Authorization", authorizationHeaderValue)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
 
-	resp, respErr := http.DefaultClient.Do(req)
-	if respErr != nil {
-		ErrorLogger.Printf("Request failed: %s", respErr)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
 	}
+	defer resp.Body.Close()
 
-	respbody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("github team membership request failed with status %d", resp.StatusCode)
+	}
 
-	return string(respbody)
+	var githubTeamMembership struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&githubTeamMembership); err != nil {
+		return "", fmt.Errorf("invalid github team membership: %w", err)
+	}
+
+	return githubTeamMembership.Role, nil
+}
+
+// getNextPageURL returns the url of the next page of a Link header or an empty string on the last page
+func getNextPageURL(linkHeader string) string {
+	// e.g. <https://api.github.com/user/teams?page=2>; rel="next", <https://api.github.com/user/teams?page=5>; rel="last"
+	for _, link := range strings.Split(linkHeader, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
 }
 
 // RandomStringBytes returns a random string of length n
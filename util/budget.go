@@ -0,0 +1,426 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/slack-go/slack"
+	bolt "go.etcd.io/bbolt"
+)
+
+const budgetBucket = "budgets"
+
+var (
+	// DefaultBudgetThresholds are the thresholds in percent of the budget if a budget has none
+	DefaultBudgetThresholds = []float64{50, 80, 100}
+
+	ErrBudgetNotFound = errors.New("budget not found")
+	ErrInvalidBudget  = errors.New("invalid budget")
+
+	// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which some clusters use for their pods and services
+	sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+	// webhookClient only connects to public addresses, also after redirects and when the DNS of the host changes
+	webhookClient = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				Control: denyPrivateAddress,
+			}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
+)
+
+// Budget is the monthly budget of a tenant with the thresholds in percent which fire an alert once per month
+// when the projected spend of the month reaches them
+type Budget struct {
+	Tenant       string    `json:"tenant"`
+	Amount       float64   `json:"amount"`
+	Currency     string    `json:"currency"`
+	Thresholds   []float64 `json:"thresholds"`
+	SlackChannel string    `json:"slack_channel,omitempty"`
+	WebhookURL   string    `json:"webhook_url,omitempty"`
+	UpdatedBy    string    `json:"updated_by"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// thresholds which already fired an alert in the billing period
+	AlertPeriod       string    `json:"alert_period,omitempty"`
+	AlertedThresholds []float64 `json:"alerted_thresholds,omitempty"`
+}
+
+// BudgetStatus is the spend of the tenant in the current billing period and its projection to the end of the period
+type BudgetStatus struct {
+	Budget           Budget    `json:"budget"`
+	Period           string    `json:"period"`
+	PeriodStart      time.Time `json:"period_start"`
+	PeriodEnd        time.Time `json:"period_end"`
+	Spent            float64   `json:"spent"`
+	HourlyCost       float64   `json:"hourly_cost"`
+	Projected        float64   `json:"projected"`
+	SpentPercent     float64   `json:"spent_percent"`
+	ProjectedPercent float64   `json:"projected_percent"`
+	// thresholds reached by the projected spend
+	ReachedThresholds []float64 `json:"reached_thresholds"`
+}
+
+// BudgetAlert is the alert sent to Slack and as JSON to the webhook of the budget
+type BudgetAlert struct {
+	Tenant           string  `json:"tenant"`
+	Period           string  `json:"period"`
+	Threshold        float64 `json:"threshold"`
+	Amount           float64 `json:"amount"`
+	Currency         string  `json:"currency"`
+	Spent            float64 `json:"spent"`
+	Projected        float64 `json:"projected"`
+	ProjectedPercent float64 `json:"projected_percent"`
+	Message          string  `json:"message"`
+}
+
+// RunBudgetEvaluator evaluates the budgets of all tenants each hour until stopCh is closed
+func RunBudgetEvaluator(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if err := EvaluateBudgets(time.Now()); err != nil {
+			ErrorLogger.Printf("Error evaluating budgets: %v", err)
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EvaluateBudgets alerts every tenant whose projected spend reached a threshold of its budget which has not alerted in the period
+func EvaluateBudgets(now time.Time) error {
+	budgets, err := GetBudgets()
+	if err != nil {
+		return err
+	}
+
+	for _, budget := range budgets {
+		status, err := GetBudgetStatus(budget.Tenant, now)
+		if err != nil {
+			ErrorLogger.Printf("Error evaluating budget of tenant %s: %v", budget.Tenant, err)
+			continue
+		}
+
+		alerted := status.Budget.AlertedThresholds
+		if status.Budget.AlertPeriod != status.Period {
+			alerted = nil
+		}
+		reached := make([]float64, 0)
+		for _, threshold := range status.ReachedThresholds {
+			if !containsFloat64(threshold, alerted) {
+				reached = append(reached, threshold)
+			}
+		}
+		if len(reached) == 0 {
+			continue
+		}
+
+		// only the highest reached threshold is alerted, the lower ones are skipped
+		alert := status.alert(reached[len(reached)-1])
+		if err := sendBudgetAlert(status.Budget, alert); err != nil {
+			ErrorLogger.Printf("Error sending budget alert of tenant %s: %v", budget.Tenant, err)
+		}
+		InfoLogger.Printf("budget alert of tenant %s: %s", budget.Tenant, alert.Message)
+
+		if err := setBudgetAlerted(status.Budget, status.Period, append(alerted, reached...)); err != nil {
+			ErrorLogger.Printf("Error storing budget alert of tenant %s: %v", budget.Tenant, err)
+		}
+	}
+	return nil
+}
+
+// SetBudget validates and stores the budget of the tenant, the alerts of the period are reset
+func SetBudget(budget Budget, now time.Time) (Budget, error) {
+	if budget.Amount <= 0 {
+		return Budget{}, fmt.Errorf("%w, amount must be positive", ErrInvalidBudget)
+	}
+
+	if budget.Currency == "" {
+		budget.Currency = GetTenantCurrency(budget.Tenant)
+	}
+	if !IsCurrency(budget.Currency) {
+		return Budget{}, fmt.Errorf("%w, currency must be an ISO 4217 code like CHF", ErrInvalidBudget)
+	}
+	if _, err := ConvertCurrency(1, GetTenantCurrency(budget.Tenant), budget.Currency); err != nil {
+		return Budget{}, err
+	}
+
+	if len(budget.Thresholds) == 0 {
+		budget.Thresholds = DefaultBudgetThresholds
+	}
+	thresholds := make([]float64, 0, len(budget.Thresholds))
+	for _, threshold := range budget.Thresholds {
+		if threshold <= 0 {
+			return Budget{}, fmt.Errorf("%w, thresholds must be positive percentages", ErrInvalidBudget)
+		}
+		if !containsFloat64(threshold, thresholds) {
+			thresholds = append(thresholds, threshold)
+		}
+	}
+	sort.Float64s(thresholds)
+	budget.Thresholds = thresholds
+
+	if budget.WebhookURL != "" {
+		if err := validateWebhookURL(budget.WebhookURL); err != nil {
+			return Budget{}, err
+		}
+	}
+
+	budget.UpdatedAt = now.UTC()
+	budget.AlertPeriod = ""
+	budget.AlertedThresholds = nil
+
+	err := DB.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket([]byte(budgetBucket)), budget.Tenant, budget)
+	})
+	if err != nil {
+		return Budget{}, err
+	}
+	return budget, nil
+}
+
+// GetBudget returns the budget of the tenant
+func GetBudget(tenant string) (Budget, error) {
+	var budget Budget
+	err := DB.View(func(tx *bolt.Tx) error {
+		var err error
+		budget, err = getBudget(tx.Bucket([]byte(budgetBucket)), tenant)
+		return err
+	})
+	return budget, err
+}
+
+// GetBudgets returns the budgets of all tenants ordered by tenant
+func GetBudgets() ([]Budget, error) {
+	budgets := make([]Budget, 0)
+	err := DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(budgetBucket)).ForEach(func(_, value []byte) error {
+			var budget Budget
+			if err := json.Unmarshal(value, &budget); err != nil {
+				return err
+			}
+			budgets = append(budgets, budget)
+			return nil
+		})
+	})
+	return budgets, err
+}
+
+// DeleteBudget deletes the budget of the tenant
+func DeleteBudget(tenant string) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		budgets := tx.Bucket([]byte(budgetBucket))
+		if budgets.Get([]byte(tenant)) == nil {
+			return ErrBudgetNotFound
+		}
+		return budgets.Delete([]byte(tenant))
+	})
+}
+
+// GetBudgetStatus returns the spend of the tenant in the billing period of now and projects it to the end of the period
+// with the current hourly cost
+func GetBudgetStatus(tenant string, now time.Time) (BudgetStatus, error) {
+	budget, err := GetBudget(tenant)
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+
+	now = now.UTC()
+	periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	periodEnd := periodStart.AddDate(0, 1, 0)
+
+	history, err := GetCostHistory(tenant, periodStart, now, periodEnd.Sub(periodStart), budget.Currency)
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+
+	clusterTenantSummaries, err := GetCostSummaryByClusterByTenant([]string{tenant})
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+	var hourlyCost float64
+	if summary, ok := SumCostSummaryByTenant(clusterTenantSummaries)[tenant]; ok {
+		if summary, err = summary.convert(budget.Currency); err != nil {
+			return BudgetStatus{}, err
+		}
+		hourlyCost = summary.Total
+	}
+
+	status := BudgetStatus{
+		Budget:            budget,
+		Period:            periodStart.Format(invoicePeriodFormat),
+		PeriodStart:       periodStart,
+		PeriodEnd:         periodEnd,
+		Spent:             SumCostHistory(history).Total,
+		HourlyCost:        hourlyCost,
+		ReachedThresholds: make([]float64, 0),
+	}
+	status.Projected = status.Spent + hourlyCost*periodEnd.Sub(now).Hours()
+	status.SpentPercent = status.Spent / budget.Amount * 100
+	status.ProjectedPercent = status.Projected / budget.Amount * 100
+	for _, threshold := range budget.Thresholds {
+		if status.ProjectedPercent >= threshold {
+			status.ReachedThresholds = append(status.ReachedThresholds, threshold)
+		}
+	}
+
+	return status, nil
+}
+
+// alert returns the alert of the reached threshold
+func (status BudgetStatus) alert(threshold float64) BudgetAlert {
+	budget := status.Budget
+	return BudgetAlert{
+		Tenant:           budget.Tenant,
+		Period:           status.Period,
+		Threshold:        threshold,
+		Amount:           budget.Amount,
+		Currency:         budget.Currency,
+		Spent:            status.Spent,
+		Projected:        status.Projected,
+		ProjectedPercent: status.ProjectedPercent,
+		Message: fmt.Sprintf("Tenant %s is projected to spend %.2f %s of its budget of %.2f %s in %s (%.0f%%), the threshold of %g%% is reached",
+			budget.Tenant, status.Projected, budget.Currency, budget.Amount, budget.Currency, status.Period, status.ProjectedPercent, threshold),
+	}
+}
+
+// sendBudgetAlert posts the alert to the Slack channel of the budget or the broadcast channel and to the webhook of the budget
+func sendBudgetAlert(budget Budget, alert BudgetAlert) error {
+	var errs []error
+
+	if SlackClient != nil {
+		channel := budget.SlackChannel
+		if channel == "" {
+			channel = BroadCastChannelID
+		}
+		if _, _, err := SlackClient.PostMessage(channel, slack.MsgOptionText(alert.Message, false)); err != nil {
+			errs = append(errs, fmt.Errorf("slack: %w", err))
+		}
+	}
+
+	if budget.WebhookURL != "" {
+		if err := postWebhook(budget.WebhookURL, alert); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// postWebhook posts the value as JSON to the URL
+func postWebhook(webhookURL string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(webhookURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// validateWebhookURL returns an error if the URL is no http or https URL or its host is not public
+func validateWebhookURL(rawURL string) error {
+	webhookURL, err := url.Parse(rawURL)
+	if err != nil || (webhookURL.Scheme != "https" && webhookURL.Scheme != "http") || webhookURL.Hostname() == "" {
+		return fmt.Errorf("%w, webhook_url must be a http or https URL", ErrInvalidBudget)
+	}
+
+	ips, err := net.LookupIP(webhookURL.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w, the host of webhook_url cannot be resolved", ErrInvalidBudget)
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("%w, the host of webhook_url must not be a private, loopback or link-local address", ErrInvalidBudget)
+		}
+	}
+	return nil
+}
+
+// denyPrivateAddress rejects the connections of the webhooks to addresses which are not public
+func denyPrivateAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+// isPublicIP returns false for the private, loopback, link-local, multicast and unspecified addresses,
+// which include the cluster internal services and the metadata services of the clouds
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// setBudgetAlerted stores the alerted thresholds of the period if the budget has not been changed since it was evaluated
+func setBudgetAlerted(evaluated Budget, period string, thresholds []float64) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		budgets := tx.Bucket([]byte(budgetBucket))
+		budget, err := getBudget(budgets, evaluated.Tenant)
+		// the budget was deleted in the meantime
+		if errors.Is(err, ErrBudgetNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// the budget was replaced in the meantime and its alerts were reset
+		if !budget.UpdatedAt.Equal(evaluated.UpdatedAt) {
+			return nil
+		}
+		budget.AlertPeriod = period
+		budget.AlertedThresholds = thresholds
+		return putJSON(budgets, budget.Tenant, budget)
+	})
+}
+
+// getBudget returns the stored budget of the tenant
+func getBudget(budgets *bolt.Bucket, tenant string) (Budget, error) {
+	var budget Budget
+	data := budgets.Get([]byte(tenant))
+	if data == nil {
+		return budget, ErrBudgetNotFound
+	}
+	return budget, json.Unmarshal(data, &budget)
+}
+
+// containsFloat64 returns true if the value is in the values
+func containsFloat64(value float64, values []float64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
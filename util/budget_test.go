@@ -0,0 +1,98 @@
+package util
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	private := []string{"10.0.0.1", "172.16.0.1", "192.168.1.1", "127.0.0.1", "::1", "169.254.169.254", "fe80::1",
+		"fd00::1", "100.64.0.1", "0.0.0.0", "::", "224.0.0.1", "ff02::1"}
+	for _, address := range private {
+		if isPublicIP(net.ParseIP(address)) {
+			t.Errorf("expected %s not to be public", address)
+		}
+	}
+
+	public := []string{"8.8.8.8", "100.128.0.1", "2001:4860:4860::8888"}
+	for _, address := range public {
+		if !isPublicIP(net.ParseIP(address)) {
+			t.Errorf("expected %s to be public", address)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	invalid := []string{
+		"",
+		"not a url",
+		"ftp://8.8.8.8/hook",
+		"https://",
+		"http://localhost/hook",
+		"http://127.0.0.1:8080/hook",
+		"http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.96.0.1/api",
+		"https://host.invalid/hook",
+	}
+	for _, webhookURL := range invalid {
+		if err := validateWebhookURL(webhookURL); !errors.Is(err, ErrInvalidBudget) {
+			t.Errorf("expected %q to be invalid, got %v", webhookURL, err)
+		}
+	}
+
+	if err := validateWebhookURL("https://8.8.8.8/hook"); err != nil {
+		t.Errorf("expected a public address to be valid, got %v", err)
+	}
+}
+
+func TestWebhookClientDeniesPrivateAddresses(t *testing.T) {
+	if err := denyPrivateAddress("tcp", "127.0.0.1:443", nil); err == nil {
+		t.Errorf("expected a loopback address to be denied")
+	}
+	if err := denyPrivateAddress("tcp6", "[fd00::1]:443", nil); err == nil {
+		t.Errorf("expected a private address to be denied")
+	}
+	if err := denyPrivateAddress("tcp", "8.8.8.8:443", nil); err != nil {
+		t.Errorf("expected a public address to be allowed, got %v", err)
+	}
+
+	// the client does not post to a private address, e.g. after a DNS change of the validated host
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	t.Cleanup(server.Close)
+	if err := postWebhook(server.URL, BudgetAlert{Tenant: "acme"}); err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("expected the private address to be denied, got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("expected no request to the private address, got %d", requests)
+	}
+}
+
+func TestSetBudgetValidatesWebhookURL(t *testing.T) {
+	setupStore(t)
+	setupCluster(t, "cpu: 1\n", tenantNamespace("acme"))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := SetBudget(Budget{Tenant: "acme", Amount: 100, WebhookURL: "http://169.254.169.254/latest/meta-data"}, now); !errors.Is(err, ErrInvalidBudget) {
+		t.Errorf("expected the metadata service to be rejected, got %v", err)
+	}
+	if _, err := GetBudget("acme"); !errors.Is(err, ErrBudgetNotFound) {
+		t.Errorf("expected the invalid budget not to be stored, got %v", err)
+	}
+
+	budget, err := SetBudget(Budget{Tenant: "acme", Amount: 100, WebhookURL: "https://8.8.8.8/hook"}, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if budget.WebhookURL != "https://8.8.8.8/hook" || budget.Currency != "CHF" {
+		t.Errorf("unexpected budget %+v", budget)
+	}
+}
@@ -0,0 +1,156 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Cluster is a host cluster with its clientset and informer caches
+type Cluster struct {
+	Name                string
+	Context             string
+	Clientset           *kubernetes.Clientset
+	InformerFactory     informers.SharedInformerFactory
+	NamespaceLister     corelisters.NamespaceLister
+	PodLister           corelisters.PodLister
+	PVCLister           corelisters.PersistentVolumeClaimLister
+	ResourceQuotaLister corelisters.ResourceQuotaLister
+	IngressLister       networkinglisters.IngressLister
+	StorageClassLister  storagelisters.StorageClassLister
+	cacheSyncedFuncs    []cache.InformerSynced
+	// usage of the pods of a snapshot of the cluster read from Prometheus
+	prometheusUsage map[string]map[string]PodUsage
+	// usage of the pods of the tenants billed with BILL_ON_USAGE read once for a request or a cost sample
+	billedUsage map[string]map[string]PodUsage
+	// time of the prices of the costs of a copy of the cluster, zero for the current prices
+	pricingTime time.Time
+}
+
+var (
+	Clusters      []*Cluster
+	CLUSTERS      string
+	CLUSTER_NAME  string
+	CLUSTER_COSTS map[string]Prices
+)
+
+// InitClusters creates a cluster with a clientset for each cluster of CLUSTERS or a single cluster with the default config
+func InitClusters() error {
+	Clusters = make([]*Cluster, 0)
+
+	if CLUSTERS == "" {
+		cluster, err := newCluster(CLUSTER_NAME, KUBE_CONTEXT, true)
+		if err != nil {
+			return err
+		}
+		Clusters = append(Clusters, cluster)
+	} else {
+		// CLUSTERS is a comma separated list of <name>=<context> or <context>
+		for _, clusterString := range strings.Split(CLUSTERS, ",") {
+			clusterString = strings.TrimSpace(clusterString)
+			if clusterString == "" {
+				continue
+			}
+
+			name, kubeContext := clusterString, clusterString
+			if nameContext := strings.SplitN(clusterString, "=", 2); len(nameContext) == 2 {
+				name, kubeContext = nameContext[0], nameContext[1]
+			}
+
+			if GetCluster(name) != nil {
+				return fmt.Errorf("cluster %s is defined more than once", name)
+			}
+
+			// an empty context uses the in-cluster config
+			cluster, err := newCluster(name, kubeContext, kubeContext == "")
+			if err != nil {
+				return err
+			}
+			Clusters = append(Clusters, cluster)
+		}
+	}
+
+	if len(Clusters) == 0 {
+		return fmt.Errorf("no cluster configured")
+	}
+
+	// the first cluster is the default cluster
+	Clientset = Clusters[0].Clientset
+
+	return nil
+}
+
+// GetCluster returns the cluster with the provided name or nil if it does not exist
+func GetCluster(name string) *Cluster {
+	for _, cluster := range Clusters {
+		if cluster.Name == name {
+			return cluster
+		}
+	}
+	return nil
+}
+
+// GetClusterNames returns the names of all clusters
+func GetClusterNames() []string {
+	clusterNames := make([]string, 0, len(Clusters))
+	for _, cluster := range Clusters {
+		clusterNames = append(clusterNames, cluster.Name)
+	}
+	return clusterNames
+}
+
+// newCluster creates a cluster with a clientset of the kubeconfig context or the default config
+func newCluster(name string, kubeContext string, defaultConfig bool) (*Cluster, error) {
+	var config *rest.Config
+	var err error
+	cluster := &Cluster{
+		Name:    name,
+		Context: kubeContext,
+	}
+
+	// creates the config with ratelimiter to qps: 20 and burst: 50
+	if defaultConfig {
+		config, err = GetRestConfig()
+	} else {
+		config, err = GetRestConfigForContext(kubeContext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create config of cluster %s: %w", name, err)
+	}
+
+	config.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(20, 50)
+	registerKubernetesHost(config.Host, name)
+
+	// creates the clientset
+	if cluster.Clientset, err = kubernetes.NewForConfig(config); err != nil {
+		return nil, fmt.Errorf("cannot create clientset of cluster %s: %w", name, err)
+	}
+
+	InfoLogger.Printf("cluster %s added", name)
+
+	return cluster, nil
+}
+
+// pricedAt returns the time of the prices the costs of the cluster are calculated with
+func (cluster *Cluster) pricedAt() time.Time {
+	if cluster.pricingTime.IsZero() {
+		return time.Now()
+	}
+	return cluster.pricingTime
+}
+
+// withPricingTime returns a copy of the cluster whose costs are calculated with the prices effective at the time
+func (cluster *Cluster) withPricingTime(at time.Time) *Cluster {
+	priced := *cluster
+	priced.pricingTime = at
+	return &priced
+}
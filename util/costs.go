@@ -3,6 +3,9 @@ package util
 import (
 	"fmt"
 	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 var (
@@ -12,68 +15,280 @@ var (
 	INGRESS_COST             float64
 	INGRESS_COST_PER_DOMAIN  bool
 	EXCLUDE_INGRESS_VCLUSTER bool
-	CPU_DISCOUNT_PERCENT     float64
-	MEMORY_DISCOUNT_PERCENT  float64
-	STORAGE_DISCOUNT_PERCENT float64
-	INGRESS_DISCOUNT_PERCENT float64
 )
 
-// GetCPUCost returns the cost of the provided MiliCPU
-func GetCPUCost(millicores float64) float64 {
+// GetCPUCost returns the cost of the provided MiliCPU of the tenant in the cluster with the prices effective at the time and the discount applied
+func GetCPUCost(at time.Time, cluster string, tenant string, millicores float64, discount float64) float64 {
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
 	// return per core
-	return (CPU_COST * float64(millicores) / 1000) * (1 - CPU_DISCOUNT_PERCENT)
+	return (pricingAt(at).getCPUCost(cluster, tenant) * float64(millicores) / 1000) * (1 - discount)
 }
 
-// GetMemoryCost returns the cost of the provided Memory
-func GetMemoryCost(memory float64) float64 {
+// GetMemoryCost returns the cost of the provided Memory of the tenant in the cluster with the prices effective at the time and the discount applied
+func GetMemoryCost(at time.Time, cluster string, tenant string, memory float64, discount float64) float64 {
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
 	// return per GB
-	return (MEMORY_COST * float64(memory) / (1024 * 1024 * 1024)) * (1 - MEMORY_DISCOUNT_PERCENT)
+	return (pricingAt(at).getMemoryCost(cluster, tenant) * float64(memory) / (1024 * 1024 * 1024)) * (1 - discount)
 }
 
-// GetStorageCost returns the cost of the provided Storage of the StorageClass
-func GetStorageCost(storageClass string, size float64) (float64, error) {
-	// return per GB
-	if STORAGE_COST[storageClass] == nil {
-		return 0, fmt.Errorf("storage class %s not found", storageClass)
+// GetStorageCost returns the cost of the provided Storage of the StorageClass of the tenant in the cluster with the prices effective at the time and the discount applied
+func GetStorageCost(at time.Time, cluster string, tenant string, storageClass string, size float64, discount float64) (float64, error) {
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
+	storageCost, err := pricingAt(at).getStorageClassCost(cluster, tenant, storageClass)
+	if err != nil {
+		return 0, err
 	}
-	// with STORAGE_DISCOUNT_PERCENT
-	return (STORAGE_COST[storageClass]["cost"] * float64(size) / (1024 * 1024 * 1024)) * (1 - STORAGE_DISCOUNT_PERCENT), nil
+	// return per GB
+	return (storageCost * float64(size) / (1024 * 1024 * 1024)) * (1 - discount), nil
 }
 
-// GetIngressCost returns the cost of the provided Ingress
-func GetIngressCostByDomain(hostnameStrings []string) float64 {
+// GetIngressCostByDomain returns the cost of the provided Ingress hostnames with their discount by domain of the tenant in the cluster
+// with the prices effective at the time, a domain used by several ingresses gets the highest discount of them
+func GetIngressCostByDomain(at time.Time, cluster string, tenant string, hostnameDiscounts map[string]float64) float64 {
 
 	var tenantIngressCostsPerDomainSum float64
 
-	// define a set of domains
-	domains := make(map[string]bool)
+	// define a set of domains with their discount
+	domains := make(map[string]float64)
 
-	for _, host := range hostnameStrings {
-		// split string with .
-		hostnameParts := strings.Split(host, ".")
-		// get the 2 last parts of the hostname
-		var domain string
-		if len(hostnameParts) > 1 {
-			domain = hostnameParts[len(hostnameParts)-2] + "." + hostnameParts[len(hostnameParts)-1]
-		} else {
-			domain = ""
-		}
+	for host, discount := range hostnameDiscounts {
 		// add the domain to the tenantIngressCosts map
-		if domain != "" {
-			domains[domain] = true
+		if domain := GetDomain(host); domain != "" {
+			if domainDiscount, ok := domains[domain]; !ok || discount > domainDiscount {
+				domains[domain] = discount
+			}
 		} else {
 			ErrorLogger.Printf("domain is not valid for hostname %s", host)
 		}
 	}
 
-	// calculate the cost * count of domains
-	for range domains {
-		tenantIngressCostsPerDomainSum += INGRESS_COST * (1 - INGRESS_DISCOUNT_PERCENT)
+	// calculate the cost of each domain
+	for _, discount := range domains {
+		tenantIngressCostsPerDomainSum += getIngressCost(at, cluster, tenant) * (1 - discount)
 	}
 
 	return tenantIngressCostsPerDomainSum
 }
 
-func GetIngressCost(ingressCount int) float64 {
-	return INGRESS_COST * float64(ingressCount) * (1 - INGRESS_DISCOUNT_PERCENT)
+// GetDomain returns the domain.tld of the hostname or an empty string if the hostname has no domain
+func GetDomain(host string) string {
+	// split string with .
+	hostnameParts := strings.Split(host, ".")
+	// get the 2 last parts of the hostname
+	if len(hostnameParts) > 1 {
+		return hostnameParts[len(hostnameParts)-2] + "." + hostnameParts[len(hostnameParts)-1]
+	}
+	return ""
+}
+
+// GetIngressCost returns the cost of the provided count of Ingress of the tenant in the cluster with the prices effective at the time and the discount applied
+func GetIngressCost(at time.Time, cluster string, tenant string, ingressCount int, discount float64) float64 {
+	return getIngressCost(at, cluster, tenant) * float64(ingressCount) * (1 - discount)
+}
+
+// GetCPUCostSumByClusterByTenant returns the cpu cost sum for each tenant keyed by cluster, tenants without costs are omitted
+func GetCPUCostSumByClusterByTenant(tenants []string) (map[string]map[string]float64, error) {
+	clusterTenantCPUCosts := make(map[string]map[string]float64)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot, err = snapshot.withBilledUsage(tenants); err != nil {
+			return nil, err
+		}
+		tenantCPUCosts, err := snapshot.GetCPUCostSumByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantCPUCosts[cluster.Name] = tenantCPUCosts
+	}
+	return clusterTenantCPUCosts, nil
+}
+
+// GetCPUCostSumByTenant returns the cpu cost sum of the billed cpu for each tenant with the discount of each pod applied
+func (cluster *Cluster) GetCPUCostSumByTenant(tenants []string) (map[string]float64, error) {
+	tenantCPUCosts := make(map[string]float64)
+	for _, tenant := range tenants {
+		pods, err := cluster.listBilledPods(tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pod := range pods {
+			discount, err := GetDiscount(pod.Labels)
+			if err != nil {
+				return nil, fmt.Errorf("pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+
+			if cpu := pod.Resources.Cpu().MilliValue(); cpu != 0 {
+				tenantCPUCosts[tenant] += GetCPUCost(cluster.pricedAt(), cluster.Name, tenant, float64(cpu), discount)
+			}
+		}
+	}
+	return tenantCPUCosts, nil
+}
+
+// GetMemoryCostSumByClusterByTenant returns the memory cost sum for each tenant keyed by cluster, tenants without costs are omitted
+func GetMemoryCostSumByClusterByTenant(tenants []string) (map[string]map[string]float64, error) {
+	clusterTenantMemoryCosts := make(map[string]map[string]float64)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot, err = snapshot.withBilledUsage(tenants); err != nil {
+			return nil, err
+		}
+		tenantMemoryCosts, err := snapshot.GetMemoryCostSumByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantMemoryCosts[cluster.Name] = tenantMemoryCosts
+	}
+	return clusterTenantMemoryCosts, nil
+}
+
+// GetMemoryCostSumByTenant returns the memory cost sum of the billed memory for each tenant with the discount of each pod applied
+func (cluster *Cluster) GetMemoryCostSumByTenant(tenants []string) (map[string]float64, error) {
+	tenantMemoryCosts := make(map[string]float64)
+	for _, tenant := range tenants {
+		pods, err := cluster.listBilledPods(tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pod := range pods {
+			discount, err := GetDiscount(pod.Labels)
+			if err != nil {
+				return nil, fmt.Errorf("pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+
+			if memory := pod.Resources.Memory().Value(); memory != 0 {
+				tenantMemoryCosts[tenant] += GetMemoryCost(cluster.pricedAt(), cluster.Name, tenant, float64(memory), discount)
+			}
+		}
+	}
+	return tenantMemoryCosts, nil
+}
+
+// GetStorageCostSumByClusterByTenant returns the storage cost sum by storage class for each tenant keyed by cluster, tenants without costs are omitted
+func GetStorageCostSumByClusterByTenant(tenants []string) (map[string]map[string]map[string]float64, error) {
+	clusterTenantStorageCosts := make(map[string]map[string]map[string]float64)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		tenantStorageCosts, err := snapshot.GetStorageCostSumByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantStorageCosts[cluster.Name] = tenantStorageCosts
+	}
+	return clusterTenantStorageCosts, nil
+}
+
+// GetStorageCostSumByTenant returns the storage cost sum by storage class for each tenant with the discount of each pvc applied
+func (cluster *Cluster) GetStorageCostSumByTenant(tenants []string) (map[string]map[string]float64, error) {
+	tenantStorageCosts := make(map[string]map[string]float64)
+	for _, tenant := range tenants {
+		pvcs, err := cluster.PVCLister.PersistentVolumeClaims(tenant).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+
+		storageCosts := make(map[string]float64)
+		for _, pvc := range pvcs {
+			if pvc.Spec.StorageClassName == nil {
+				continue
+			}
+
+			discount, err := GetDiscount(pvc.Labels)
+			if err != nil {
+				return nil, fmt.Errorf("pvc %s/%s: %w", pvc.Namespace, pvc.Name, err)
+			}
+
+			storageClass := *pvc.Spec.StorageClassName
+			if size := pvc.Spec.Resources.Requests.Storage().Value(); size != 0 {
+				storageCost, err := GetStorageCost(cluster.pricedAt(), cluster.Name, tenant, storageClass, float64(size), discount)
+				if err != nil {
+					return nil, err
+				}
+				storageCosts[storageClass] += storageCost
+			}
+		}
+
+		// omit tenants with no storage costs
+		if len(storageCosts) != 0 {
+			tenantStorageCosts[tenant] = storageCosts
+		}
+	}
+	return tenantStorageCosts, nil
+}
+
+// GetIngressCostSumByClusterByTenant returns the ingress cost sum for each tenant keyed by cluster, tenants without ingresses are omitted
+func GetIngressCostSumByClusterByTenant(tenants []string) (map[string]map[string]float64, error) {
+	clusterTenantIngressCosts := make(map[string]map[string]float64)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		tenantIngressCosts, err := snapshot.GetIngressCostSumByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantIngressCosts[cluster.Name] = tenantIngressCosts
+	}
+	return clusterTenantIngressCosts, nil
+}
+
+// GetIngressCostSumByTenant returns the ingress cost sum for each tenant with the discount of each ingress applied
+func (cluster *Cluster) GetIngressCostSumByTenant(tenants []string) (map[string]float64, error) {
+	tenantIngressCosts := make(map[string]float64)
+	for _, tenant := range tenants {
+		ingresses, err := cluster.listBilledIngresses(tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ingresses) == 0 {
+			continue
+		}
+
+		hostnameDiscounts := make(map[string]float64)
+		for _, ingress := range ingresses {
+			discount, err := GetDiscount(ingress.Labels)
+			if err != nil {
+				return nil, fmt.Errorf("ingress %s/%s: %w", ingress.Namespace, ingress.Name, err)
+			}
+
+			if INGRESS_COST_PER_DOMAIN {
+				for _, rule := range ingress.Spec.Rules {
+					if hostnameDiscount, ok := hostnameDiscounts[rule.Host]; !ok || discount > hostnameDiscount {
+						hostnameDiscounts[rule.Host] = discount
+					}
+				}
+			} else {
+				// every hostname of the ingress is billed
+				tenantIngressCosts[tenant] += GetIngressCost(cluster.pricedAt(), cluster.Name, tenant, len(ingress.Spec.Rules), discount)
+			}
+		}
+
+		if INGRESS_COST_PER_DOMAIN {
+			tenantIngressCosts[tenant] = GetIngressCostByDomain(cluster.pricedAt(), cluster.Name, tenant, hostnameDiscounts)
+		}
+	}
+	return tenantIngressCosts, nil
+}
+
+// getIngressCost returns the cost of a single ingress of the tenant in the cluster with the prices effective at the time
+func getIngressCost(at time.Time, cluster string, tenant string) float64 {
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
+	return pricingAt(at).getIngressCost(cluster, tenant)
 }
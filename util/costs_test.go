@@ -0,0 +1,133 @@
+package util
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// discounted sets the discount label on the object
+func discounted(object metav1.Object, discount string) {
+	object.SetLabels(map[string]string{"natron.io/discount": discount})
+}
+
+// ssdClaim returns a pvc of the tenant with the size of the ssd storage class
+func ssdClaim(tenant string, name string, size string) *v1.PersistentVolumeClaim {
+	storageClass := "ssd"
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: tenant, Name: name},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClass,
+			Resources:        v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(size)}},
+		},
+	}
+}
+
+// hostIngress returns an ingress of the tenant with a rule for each host
+func hostIngress(tenant string, name string, hosts ...string) *networkingv1.Ingress {
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: tenant, Name: name}}
+	for _, host := range hosts {
+		ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{Host: host})
+	}
+	return ingress
+}
+
+func TestGetDiscount(t *testing.T) {
+	previousLabel := DISCOUNT_LABEL
+	t.Cleanup(func() { DISCOUNT_LABEL = previousLabel })
+	DISCOUNT_LABEL = "natron.io/discount"
+
+	for value, expected := range map[string]float64{"": 0, "0": 0, "0.25": 0.25, "1": 1} {
+		discount, err := GetDiscount(map[string]string{DISCOUNT_LABEL: value})
+		if err != nil || discount != expected {
+			t.Errorf("expected a discount of %v for %q, got %v %v", expected, value, discount, err)
+		}
+	}
+	if discount, err := GetDiscount(nil); err != nil || discount != 0 {
+		t.Errorf("expected no discount without labels, got %v %v", discount, err)
+	}
+	for _, value := range []string{"-0.1", "1.5", "half"} {
+		if _, err := GetDiscount(map[string]string{DISCOUNT_LABEL: value}); err == nil {
+			t.Errorf("expected %q to be an invalid discount", value)
+		}
+	}
+}
+
+func TestCostSumsWithDiscountPerObject(t *testing.T) {
+	web := runningPod("acme", "web", "2", "2Gi")
+	discounted(web, "0.5")
+	batch := runningPod("acme", "batch", "1", "1Gi")
+	cache := ssdClaim("acme", "cache", "10Gi")
+	discounted(cache, "0.1")
+	data := ssdClaim("acme", "data", "10Gi")
+	cluster := setupCluster(t, "cpu: 1\nmemory: 1\ningress: 1\nstorage:\n  ssd: 1\n", tenantNamespace("acme"), tenantNamespace("globex"),
+		web, batch, cache, data, runningPod("globex", "web", "2", "2Gi"))
+	tenants := []string{"acme", "globex"}
+
+	// each pod has its own discount, the pods of other tenants are not discounted
+	cpuCosts, err := cluster.GetCPUCostSumByTenant(tenants)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cpuCosts["acme"] != 2 || cpuCosts["globex"] != 2 {
+		t.Errorf("expected 2 cores at half price and one at full price for acme, got %v", cpuCosts)
+	}
+	memoryCosts, err := cluster.GetMemoryCostSumByTenant(tenants)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if memoryCosts["acme"] != 2 || memoryCosts["globex"] != 2 {
+		t.Errorf("expected 2 GB at half price and one at full price for acme, got %v", memoryCosts)
+	}
+
+	storageCosts, err := cluster.GetStorageCostSumByTenant(tenants)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cost := storageCosts["acme"]["ssd"]; math.Abs(cost-19) > 1e-9 {
+		t.Errorf("expected 10 GB with a discount of 10%% and 10 GB at full price, got %v", cost)
+	}
+	if _, ok := storageCosts["globex"]; ok {
+		t.Errorf("expected no storage costs of globex, got %v", storageCosts["globex"])
+	}
+
+	// an invalid discount fails with the object
+	discounted(batch, "2")
+	if _, err := cluster.GetCPUCostSumByTenant(tenants); err == nil || !strings.Contains(err.Error(), "pod acme/batch") {
+		t.Errorf("expected an invalid discount of the pod, got %v", err)
+	}
+}
+
+func TestIngressCostSumWithDiscountPerObject(t *testing.T) {
+	shop := hostIngress("acme", "shop", "shop.example.com", "www.example.com")
+	discounted(shop, "0.5")
+	api := hostIngress("acme", "api", "api.example.com", "api.example.org")
+	cluster := setupCluster(t, "ingress: 1\n", tenantNamespace("acme"), shop, api)
+
+	previousPerDomain := INGRESS_COST_PER_DOMAIN
+	t.Cleanup(func() { INGRESS_COST_PER_DOMAIN = previousPerDomain })
+
+	// every hostname is billed with the discount of its ingress
+	INGRESS_COST_PER_DOMAIN = false
+	ingressCosts, err := cluster.GetIngressCostSumByTenant([]string{"acme"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ingressCosts["acme"] != 3 {
+		t.Errorf("expected 2 hostnames at half price and 2 at full price, got %v", ingressCosts["acme"])
+	}
+
+	// a domain used by several ingresses gets the highest discount
+	INGRESS_COST_PER_DOMAIN = true
+	if ingressCosts, err = cluster.GetIngressCostSumByTenant([]string{"acme"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ingressCosts["acme"] != 1.5 {
+		t.Errorf("expected example.com at half price and example.org at full price, got %v", ingressCosts["acme"])
+	}
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+// GithubProvider authenticates users with a GitHub oauth app, the groups are the slugs of the teams of the user in the organization
+// and the maintainers of a team are admins of its tenants
+type GithubProvider struct {
+	ClientID     string
+	ClientSecret string
+	Organization string
+}
+
+// Name returns the name of the provider
+func (provider *GithubProvider) Name() string {
+	return "github"
+}
+
+// AuthCodeURL returns the GitHub authorize url with the state and, if provided, the S256 PKCE code challenge
+func (provider *GithubProvider) AuthCodeURL(redirectURL string, state string, codeChallenge string) string {
+	params := url.Values{
+		"scope":        {"read:org"},
+		"client_id":    {provider.ClientID},
+		"redirect_uri": {redirectURL},
+		"state":        {state},
+	}
+	if codeChallenge != "" {
+		params.Set("code_challenge", codeChallenge)
+		params.Set("code_challenge_method", "S256")
+	}
+	return githubURL + "/login/oauth/authorize?" + params.Encode()
+}
+
+// Authenticate exchanges the code for a GitHub access token and returns the user with its team slugs
+func (provider *GithubProvider) Authenticate(ctx context.Context, code string, redirectURL string, codeVerifier string) (Identity, error) {
+	accessToken, err := GetGithubAccessToken(code, redirectURL, codeVerifier)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return provider.identity(accessToken)
+}
+
+// Refresh returns the user with its current team slugs, the GitHub access token of an oauth app does not expire
+func (provider *GithubProvider) Refresh(ctx context.Context, token *oauth2.Token) (Identity, error) {
+	if token == nil || token.AccessToken == "" {
+		return Identity{}, errors.New("github access token is missing")
+	}
+	return provider.identity(token.AccessToken)
+}
+
+// identity returns the user of the access token with its team slugs and the roles of the maintained teams
+func (provider *GithubProvider) identity(accessToken string) (Identity, error) {
+	var githubUser struct {
+		Login string `json:"login"`
+	}
+	githubData, err := GetGithubData(accessToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if err := json.Unmarshal([]byte(githubData), &githubUser); err != nil {
+		return Identity{}, fmt.Errorf("invalid github user: %w", err)
+	}
+	if githubUser.Login == "" {
+		return Identity{}, errors.New("github user has no login")
+	}
+
+	githubTeamSlugs, err := GetGithubTeams(accessToken, provider.Organization)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	groupRoles := make(map[string][]Role)
+	for _, githubTeamSlug := range githubTeamSlugs {
+		githubTeamRole, err := GetGithubTeamRole(accessToken, provider.Organization, githubTeamSlug, githubUser.Login)
+		if err != nil {
+			return Identity{}, err
+		}
+		if githubTeamRole == "maintainer" {
+			groupRoles[githubTeamSlug] = []Role{RoleAdmin}
+		}
+	}
+
+	return Identity{
+		Provider:   provider.Name(),
+		Subject:    githubUser.Login,
+		Groups:     githubTeamSlugs,
+		GroupRoles: groupRoles,
+		Token:      &oauth2.Token{AccessToken: accessToken},
+	}, nil
+}
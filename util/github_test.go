@@ -0,0 +1,142 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// mockGithub serves the GitHub login and API endpoints used by the GitHub provider for a single user
+type mockGithub struct {
+	server       *httptest.Server
+	code         string
+	codeVerifier string
+	accessToken  string
+}
+
+// newMockGithub starts a mock GitHub and points the GitHub urls to it
+func newMockGithub(t *testing.T) *mockGithub {
+	t.Helper()
+	InitLoggers()
+
+	github := &mockGithub{code: "valid-code", codeVerifier: "verifier", accessToken: "gho_token"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", github.token)
+	mux.HandleFunc("/user", github.authorized(github.user))
+	mux.HandleFunc("/user/teams", github.authorized(github.teams))
+	mux.HandleFunc("/orgs/natron-io/teams/", github.authorized(github.membership))
+	github.server = httptest.NewServer(mux)
+	t.Cleanup(github.server.Close)
+
+	previousURL, previousAPIURL := githubURL, githubAPIURL
+	t.Cleanup(func() { githubURL, githubAPIURL = previousURL, previousAPIURL })
+	githubURL, githubAPIURL = github.server.URL, github.server.URL
+	return github
+}
+
+func (github *mockGithub) token(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+
+	// github returns the errors with status 200
+	if body["code"] != github.code || body["code_verifier"] != github.codeVerifier {
+		json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code", "error_description": "The code passed is incorrect or expired."})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": github.accessToken, "token_type": "bearer", "scope": "read:org"})
+}
+
+// authorized rejects the requests without the access token
+func (github *mockGithub) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token "+github.accessToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (github *mockGithub) user(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{"login": "octocat"})
+}
+
+// teams returns the teams on two pages, the teams of other organizations are no tenants
+func (github *mockGithub) teams(w http.ResponseWriter, r *http.Request) {
+	team := func(slug string, organization string) map[string]interface{} {
+		return map[string]interface{}{"slug": slug, "organization": map[string]string{"login": organization}}
+	}
+	if r.URL.Query().Get("page") == "2" {
+		json.NewEncoder(w).Encode([]interface{}{team("globex", "natron-io")})
+		return
+	}
+	w.Header().Set("Link", fmt.Sprintf(`<%s/user/teams?per_page=100&page=2>; rel="next", <%s/user/teams?per_page=100&page=2>; rel="last"`, github.server.URL, github.server.URL))
+	json.NewEncoder(w).Encode([]interface{}{team("acme", "Natron-IO"), team("other", "other-org")})
+}
+
+// membership returns maintainer for the acme team and member for all other teams
+func (github *mockGithub) membership(w http.ResponseWriter, r *http.Request) {
+	role := "member"
+	if strings.HasPrefix(r.URL.Path, "/orgs/natron-io/teams/acme/") {
+		role = "maintainer"
+	}
+	json.NewEncoder(w).Encode(map[string]string{"role": role})
+}
+
+func TestGithubAuthenticate(t *testing.T) {
+	newMockGithub(t)
+	provider := &GithubProvider{ClientID: "client", ClientSecret: "secret", Organization: "natron-io"}
+
+	if authCodeURL := provider.AuthCodeURL("http://localhost/callback", "state", "challenge"); !strings.HasPrefix(authCodeURL, githubURL+"/login/oauth/authorize?") ||
+		!strings.Contains(authCodeURL, "code_challenge=challenge") || !strings.Contains(authCodeURL, "state=state") {
+		t.Errorf("unexpected auth code url %s", authCodeURL)
+	}
+
+	identity, err := provider.Authenticate(context.Background(), "valid-code", "http://localhost/callback", "verifier")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if identity.Provider != "github" || identity.Subject != "octocat" || identity.Token.AccessToken != "gho_token" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if !reflect.DeepEqual(identity.Groups, []string{"acme", "globex"}) {
+		t.Errorf("expected the teams of the organization on all pages, got %v", identity.Groups)
+	}
+	if !reflect.DeepEqual(identity.GroupRoles, map[string][]Role{"acme": {RoleAdmin}}) {
+		t.Errorf("expected the maintainer of acme to be admin, got %v", identity.GroupRoles)
+	}
+
+	if _, err := provider.Authenticate(context.Background(), "expired-code", "http://localhost/callback", "verifier"); err == nil || !strings.Contains(err.Error(), "bad_verification_code") {
+		t.Errorf("expected a failed code exchange, got %v", err)
+	}
+}
+
+func TestGithubRefresh(t *testing.T) {
+	github := newMockGithub(t)
+	provider := &GithubProvider{Organization: "natron-io"}
+
+	identity, err := provider.Refresh(context.Background(), &oauth2.Token{AccessToken: "gho_token"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if identity.Subject != "octocat" || len(identity.Groups) != 2 {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	if _, err := provider.Refresh(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected a missing access token, got %v", err)
+	}
+
+	// a revoked access token fails
+	github.accessToken = "gho_rotated"
+	if _, err := provider.Refresh(context.Background(), &oauth2.Token{AccessToken: "gho_token"}); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("expected a failed refresh, got %v", err)
+	}
+}
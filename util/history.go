@@ -0,0 +1,424 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	historyBucket = "history"
+	// fixed width timestamp format to keep the keys sorted by time
+	historyKeyFormat = "2006-01-02T15:04:05.000000000Z"
+)
+
+var (
+	HISTORY_INTERVAL  time.Duration
+	HISTORY_RETENTION time.Duration
+)
+
+// CostSample is the usage and the hourly cost rate of a tenant in a cluster at a point in time with the prices effective then,
+// Ingresses is the count of billed hostnames or domains if INGRESS_COST_PER_DOMAIN is set.
+// Interval is the billed time of a recorded sample, the time since the previous sample of the tenant in the cluster
+// capped at HISTORY_INTERVAL, the first sample bills no time
+type CostSample struct {
+	Timestamp   time.Time          `json:"timestamp"`
+	Cluster     string             `json:"cluster"`
+	Tenant      string             `json:"tenant"`
+	Currency    string             `json:"currency"`
+	Interval    time.Duration      `json:"interval"`
+	CPU         int64              `json:"cpu"`
+	Memory      int64              `json:"memory"`
+	Storage     map[string]int64   `json:"storage"`
+	Ingresses   int                `json:"ingresses"`
+	CPUCost     float64            `json:"cpu_cost"`
+	MemoryCost  float64            `json:"memory_cost"`
+	StorageCost map[string]float64 `json:"storage_cost"`
+	IngressCost float64            `json:"ingress_cost"`
+	// costs without discounts
+	CPUListCost     float64            `json:"cpu_list_cost"`
+	MemoryListCost  float64            `json:"memory_list_cost"`
+	StorageListCost map[string]float64 `json:"storage_list_cost"`
+	IngressListCost float64            `json:"ingress_list_cost"`
+}
+
+// CostHistoryPoint is the integrated cost of a tenant over a time range
+type CostHistoryPoint struct {
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	CPU     float64            `json:"cpu"`
+	Memory  float64            `json:"memory"`
+	Storage map[string]float64 `json:"storage"`
+	Ingress float64            `json:"ingress"`
+	Total   float64            `json:"total"`
+}
+
+// RunCostSampler records the usage and costs of every tenant each HISTORY_INTERVAL until stopCh is closed
+func RunCostSampler(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(HISTORY_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if err := RecordCostSamples(time.Now()); err != nil {
+			ErrorLogger.Printf("Error recording cost samples: %v", err)
+		}
+
+		if err := PruneCostSamples(time.Now().Add(-HISTORY_RETENTION)); err != nil {
+			ErrorLogger.Printf("Error pruning cost samples: %v", err)
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RecordCostSamples stores a cost sample of every tenant in every cluster at the provided time,
+// a restart records a sample which only bills the time since the previous sample
+func RecordCostSamples(timestamp time.Time) error {
+	tenants, err := GetTenantsInCluster()
+	if err != nil {
+		return err
+	}
+
+	samples := make([]CostSample, 0)
+	for _, cluster := range Clusters {
+		clusterSamples, err := cluster.getCostSamples(tenants, timestamp)
+		if err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+		samples = append(samples, clusterSamples...)
+	}
+
+	return DB.Update(func(tx *bolt.Tx) error {
+		history := tx.Bucket([]byte(historyBucket))
+		for _, sample := range samples {
+			tenantHistory, err := history.CreateBucketIfNotExists([]byte(sample.Tenant))
+			if err != nil {
+				return err
+			}
+			if previous, ok := previousSampleTime(tenantHistory, sample.Cluster, sample.Timestamp); ok {
+				sample.Interval = sample.Timestamp.Sub(previous)
+				if sample.Interval > HISTORY_INTERVAL {
+					sample.Interval = HISTORY_INTERVAL
+				}
+			}
+			if err := putJSON(tenantHistory, historyKey(sample.Timestamp, sample.Cluster), sample); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PruneCostSamples deletes all cost samples recorded before the provided time
+func PruneCostSamples(before time.Time) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		history := tx.Bucket([]byte(historyBucket))
+		return history.ForEach(func(tenant, _ []byte) error {
+			tenantHistory := history.Bucket(tenant)
+			end := before.UTC().Format(historyKeyFormat)
+
+			// collect the keys first, deleting with the cursor skips keys
+			keys := make([][]byte, 0)
+			cursor := tenantHistory.Cursor()
+			for key, _ := cursor.First(); key != nil && string(key) < end; key, _ = cursor.Next() {
+				keys = append(keys, append([]byte{}, key...))
+			}
+			for _, key := range keys {
+				if err := tenantHistory.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// GetCostSamples returns the cost samples of the tenant recorded in [from, to) or calculated from Prometheus with DATASOURCE prometheus
+func GetCostSamples(tenant string, from time.Time, to time.Time) ([]CostSample, error) {
+	if DATASOURCE == DatasourcePrometheus {
+		return getPrometheusCostSamples(tenant, from, to)
+	}
+
+	samples := make([]CostSample, 0)
+	err := DB.View(func(tx *bolt.Tx) error {
+		tenantHistory := tx.Bucket([]byte(historyBucket)).Bucket([]byte(tenant))
+		if tenantHistory == nil {
+			return nil
+		}
+
+		cursor := tenantHistory.Cursor()
+		end := to.UTC().Format(historyKeyFormat)
+		for key, value := cursor.Seek([]byte(from.UTC().Format(historyKeyFormat))); key != nil && string(key) < end; key, value = cursor.Next() {
+			var sample CostSample
+			if err := json.Unmarshal(value, &sample); err != nil {
+				return err
+			}
+			samples = append(samples, sample)
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// GetCostSampleTimestamps returns the sorted timestamps in [from, to) at which cost samples of any tenant are recorded
+func GetCostSampleTimestamps(from time.Time, to time.Time) ([]time.Time, error) {
+	keys := make(map[string]bool)
+	err := DB.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket([]byte(historyBucket))
+		start, end := from.UTC().Format(historyKeyFormat), to.UTC().Format(historyKeyFormat)
+		return history.ForEach(func(tenant, _ []byte) error {
+			cursor := history.Bucket(tenant).Cursor()
+			for key, _ := cursor.Seek([]byte(start)); key != nil && string(key) < end; key, _ = cursor.Next() {
+				keys[string(key[:len(historyKeyFormat)])] = true
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	timestamps := make([]time.Time, 0, len(keys))
+	for key := range keys {
+		timestamp, err := time.Parse(historyKeyFormat, key)
+		if err != nil {
+			return nil, err
+		}
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+	return timestamps, nil
+}
+
+// GetCostHistoryTenants returns the sorted tenants with cost samples recorded in [from, to), including deprovisioned tenants
+func GetCostHistoryTenants(from time.Time, to time.Time) ([]string, error) {
+	tenants := make([]string, 0)
+	err := DB.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket([]byte(historyBucket))
+		start, end := from.UTC().Format(historyKeyFormat), to.UTC().Format(historyKeyFormat)
+		return history.ForEach(func(tenant, _ []byte) error {
+			if key, _ := history.Bucket(tenant).Cursor().Seek([]byte(start)); key != nil && string(key) < end {
+				tenants = append(tenants, string(tenant))
+			}
+			return nil
+		})
+	})
+	return tenants, err
+}
+
+// ConvertCostSamples returns the cost samples with their costs converted to the currency,
+// samples recorded without a currency are in the currency of the tenant
+func ConvertCostSamples(samples []CostSample, currency string) ([]CostSample, error) {
+	converted := make([]CostSample, 0, len(samples))
+	for _, sample := range samples {
+		sampleCurrency := sample.Currency
+		if sampleCurrency == "" {
+			sampleCurrency = GetTenantCurrency(sample.Tenant)
+		}
+
+		// conversion factor of one unit of the sample currency
+		rate, err := ConvertCurrency(1, sampleCurrency, currency)
+		if err != nil {
+			return nil, err
+		}
+
+		sample.Currency = currency
+		sample.CPUCost *= rate
+		sample.MemoryCost *= rate
+		sample.IngressCost *= rate
+		sample.CPUListCost *= rate
+		sample.MemoryListCost *= rate
+		sample.IngressListCost *= rate
+		sample.StorageCost = scaleCosts(sample.StorageCost, rate)
+		sample.StorageListCost = scaleCosts(sample.StorageListCost, rate)
+		converted = append(converted, sample)
+	}
+	return converted, nil
+}
+
+// GetCostHistory returns the cost of the tenant integrated over each step in [from, to) in the currency, the costs are hourly rates
+func GetCostHistory(tenant string, from time.Time, to time.Time, step time.Duration, currency string) ([]CostHistoryPoint, error) {
+	samples, err := GetCostSamples(tenant, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if samples, err = ConvertCostSamples(samples, currency); err != nil {
+		return nil, err
+	}
+
+	points := make([]CostHistoryPoint, 0)
+	for pointFrom := from; pointFrom.Before(to); pointFrom = pointFrom.Add(step) {
+		pointTo := pointFrom.Add(step)
+		if pointTo.After(to) {
+			pointTo = to
+		}
+		points = append(points, CostHistoryPoint{
+			From:    pointFrom,
+			To:      pointTo,
+			Storage: make(map[string]float64),
+		})
+	}
+
+	for _, sample := range samples {
+		point := &points[int(sample.Timestamp.Sub(from)/step)]
+		hours := sample.Interval.Hours()
+
+		point.CPU += sample.CPUCost * hours
+		point.Memory += sample.MemoryCost * hours
+		point.Ingress += sample.IngressCost * hours
+		point.Total += (sample.CPUCost + sample.MemoryCost + sample.IngressCost) * hours
+		for storageClass, storageCost := range sample.StorageCost {
+			point.Storage[storageClass] += storageCost * hours
+			point.Total += storageCost * hours
+		}
+	}
+
+	return points, nil
+}
+
+// SumCostHistory returns the sum of all cost history points
+func SumCostHistory(points []CostHistoryPoint) CostHistoryPoint {
+	sum := CostHistoryPoint{
+		Storage: make(map[string]float64),
+	}
+	for i, point := range points {
+		if i == 0 {
+			sum.From = point.From
+		}
+		sum.To = point.To
+		sum.CPU += point.CPU
+		sum.Memory += point.Memory
+		sum.Ingress += point.Ingress
+		sum.Total += point.Total
+		for storageClass, storageCost := range point.Storage {
+			sum.Storage[storageClass] += storageCost
+		}
+	}
+	return sum
+}
+
+// getCostSamples returns a cost sample of each tenant in the cluster at the provided time with the prices effective then
+func (cluster *Cluster) getCostSamples(tenants []string, timestamp time.Time) ([]CostSample, error) {
+	cluster, err := cluster.withPricingTime(timestamp).withBilledUsage(tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantBilledResources, err := cluster.getBilledResourcesSumByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	tenantStorageRequests, err := cluster.GetStorageRequestsSumByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	tenantIngresses, err := cluster.GetIngressRequestsSumByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	tenantCPUCosts, err := cluster.GetCPUCostSumByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	tenantMemoryCosts, err := cluster.GetMemoryCostSumByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	tenantStorageCosts, err := cluster.GetStorageCostSumByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	tenantIngressCosts, err := cluster.GetIngressCostSumByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]CostSample, 0, len(tenants))
+	for _, tenant := range tenants {
+		// count the billed hostnames or domains
+		ingresses := len(tenantIngresses[tenant])
+		if INGRESS_COST_PER_DOMAIN {
+			domains := make(map[string]bool)
+			for _, host := range tenantIngresses[tenant] {
+				if domain := GetDomain(host); domain != "" {
+					domains[domain] = true
+				}
+			}
+			ingresses = len(domains)
+		}
+
+		storageListCosts := make(map[string]float64)
+		for storageClass, size := range tenantStorageRequests[tenant] {
+			if storageListCosts[storageClass], err = GetStorageCost(timestamp, cluster.Name, tenant, storageClass, float64(size), 0); err != nil {
+				return nil, err
+			}
+		}
+
+		samples = append(samples, CostSample{
+			Timestamp:       timestamp,
+			Cluster:         cluster.Name,
+			Tenant:          tenant,
+			Currency:        GetTenantCurrencyAt(tenant, timestamp),
+			CPU:             tenantBilledResources[tenant].CPU,
+			Memory:          tenantBilledResources[tenant].Memory,
+			Storage:         tenantStorageRequests[tenant],
+			Ingresses:       ingresses,
+			CPUCost:         tenantCPUCosts[tenant],
+			MemoryCost:      tenantMemoryCosts[tenant],
+			StorageCost:     tenantStorageCosts[tenant],
+			IngressCost:     tenantIngressCosts[tenant],
+			CPUListCost:     GetCPUCost(timestamp, cluster.Name, tenant, float64(tenantBilledResources[tenant].CPU), 0),
+			MemoryListCost:  GetMemoryCost(timestamp, cluster.Name, tenant, float64(tenantBilledResources[tenant].Memory), 0),
+			StorageListCost: storageListCosts,
+			IngressListCost: GetIngressCost(timestamp, cluster.Name, tenant, ingresses, 0),
+		})
+	}
+	return samples, nil
+}
+
+// scaleCosts returns a copy of the costs multiplied with the factor
+func scaleCosts(costs map[string]float64, factor float64) map[string]float64 {
+	scaled := make(map[string]float64, len(costs))
+	for key, cost := range costs {
+		scaled[key] = cost * factor
+	}
+	return scaled
+}
+
+// previousSampleTime returns the time of the last sample of the cluster recorded before the timestamp in the history of a tenant
+func previousSampleTime(tenantHistory *bolt.Bucket, cluster string, timestamp time.Time) (time.Time, bool) {
+	cursor := tenantHistory.Cursor()
+	key, _ := cursor.Seek([]byte(timestamp.UTC().Format(historyKeyFormat)))
+	if key == nil {
+		key, _ = cursor.Last()
+	} else {
+		key, _ = cursor.Prev()
+	}
+
+	for ; key != nil; key, _ = cursor.Prev() {
+		if !strings.HasSuffix(string(key), "/"+cluster) || len(key) != len(historyKeyFormat)+1+len(cluster) {
+			continue
+		}
+		previous, err := time.Parse(historyKeyFormat, string(key[:len(historyKeyFormat)]))
+		if err != nil {
+			return time.Time{}, false
+		}
+		return previous, true
+	}
+	return time.Time{}, false
+}
+
+// historyKey returns the sortable key of a sample of the cluster at the provided time
+func historyKey(timestamp time.Time, cluster string) string {
+	return timestamp.UTC().Format(historyKeyFormat) + "/" + cluster
+}
@@ -0,0 +1,189 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// setupStore opens an empty store in a temporary directory
+func setupStore(t *testing.T) {
+	t.Helper()
+	InitLoggers()
+
+	previousDataPath, previousDB := DATA_PATH, DB
+	DATA_PATH = t.TempDir()
+	if err := InitStore(); err != nil {
+		t.Fatalf("cannot open the store: %s", err)
+	}
+	t.Cleanup(func() {
+		DB.Close()
+		DATA_PATH, DB = previousDataPath, previousDB
+	})
+}
+
+// setupCluster uses a single cluster which lists the objects as datasource with the pricing
+func setupCluster(t *testing.T, pricing string, objects ...interface{}) *Cluster {
+	t.Helper()
+	InitLoggers()
+
+	previousDatasource, previousClusters, previousTenantLabel := DATASOURCE, Clusters, TENANT_LABEL
+	previousPricingFile, previousRatesFile, previousInterval := PRICING_FILE, RATES_FILE, HISTORY_INTERVAL
+	t.Cleanup(func() {
+		DATASOURCE, Clusters, TENANT_LABEL = previousDatasource, previousClusters, previousTenantLabel
+		PRICING_FILE, RATES_FILE, HISTORY_INTERVAL = previousPricingFile, previousRatesFile, previousInterval
+	})
+
+	DATASOURCE = ""
+	TENANT_LABEL = "natron.io/tenant"
+	HISTORY_INTERVAL = time.Hour
+	DISCOUNT_LABEL = "natron.io/discount"
+	CURRENCY = "CHF"
+	BILL_ON_USAGE = false
+	EXCLUDE_TERMINATED_PODS = true
+
+	PRICING_FILE, RATES_FILE = "pricing.yaml", "rates.yaml"
+	if err := loadPricing([]byte(pricing), []byte("base: CHF\nrates:\n  EUR: 0.5\n")); err != nil {
+		t.Fatalf("cannot load the pricing: %s", err)
+	}
+
+	indexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+	namespaces, pods, pvcs, quotas, ingresses, storageClasses := indexer(), indexer(), indexer(), indexer(), indexer(), indexer()
+	for _, object := range objects {
+		switch object.(type) {
+		case *v1.Namespace:
+			namespaces.Add(object)
+		case *v1.Pod:
+			pods.Add(object)
+		case *v1.PersistentVolumeClaim:
+			pvcs.Add(object)
+		case *v1.ResourceQuota:
+			quotas.Add(object)
+		case *networkingv1.Ingress:
+			ingresses.Add(object)
+		case *storagev1.StorageClass:
+			storageClasses.Add(object)
+		default:
+			t.Fatalf("unsupported object %T", object)
+		}
+	}
+
+	cluster := &Cluster{
+		Name:                "prod",
+		NamespaceLister:     corelisters.NewNamespaceLister(namespaces),
+		PodLister:           corelisters.NewPodLister(pods),
+		PVCLister:           corelisters.NewPersistentVolumeClaimLister(pvcs),
+		ResourceQuotaLister: corelisters.NewResourceQuotaLister(quotas),
+		IngressLister:       networkinglisters.NewIngressLister(ingresses),
+		StorageClassLister:  storagelisters.NewStorageClassLister(storageClasses),
+	}
+	Clusters = []*Cluster{cluster}
+	return cluster
+}
+
+// tenantNamespace returns the namespace of the tenant
+func tenantNamespace(tenant string) *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: tenant, Labels: map[string]string{"natron.io/tenant": tenant}}}
+}
+
+// runningPod returns a running pod of the tenant with a single container with the requests
+func runningPod(tenant string, name string, cpu string, memory string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: tenant, Name: name},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name: name,
+			Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(memory),
+			}},
+		}}},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+func TestRecordCostSamplesBillsTimeSincePreviousSample(t *testing.T) {
+	setupStore(t)
+	setupCluster(t, "cpu: 1\nmemory: 0\n", tenantNamespace("acme"), runningPod("acme", "web", "2", "1Gi"))
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	timestamps := []time.Time{
+		// the first sample bills no time
+		start,
+		start.Add(time.Hour),
+		// the sampler restarts 10 minutes after the last sample
+		start.Add(70 * time.Minute),
+		start.Add(130 * time.Minute),
+		// the sampler was down for 3 hours, the gap bills at most one interval
+		start.Add(310 * time.Minute),
+	}
+	for _, timestamp := range timestamps {
+		if err := RecordCostSamples(timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	samples, err := GetCostSamples("acme", start, start.Add(6*time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []time.Duration{0, time.Hour, 10 * time.Minute, time.Hour, time.Hour}
+	if len(samples) != len(expected) {
+		t.Fatalf("expected %d samples, got %d", len(expected), len(samples))
+	}
+	for i, sample := range samples {
+		if sample.Interval != expected[i] {
+			t.Errorf("expected sample %d to bill %s, got %s", i, expected[i], sample.Interval)
+		}
+	}
+
+	// 2 cores at 1 CHF per core and hour for 3h10m
+	history, err := GetCostHistory("acme", start, start.Add(6*time.Hour), 6*time.Hour, "CHF")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cpu := history[0].CPU; cpu < 6.33 || cpu > 6.34 {
+		t.Errorf("expected 6.33 CHF of cpu for 3h10m, got %f", cpu)
+	}
+}
+
+func TestRunCostSamplerRestart(t *testing.T) {
+	setupStore(t)
+	setupCluster(t, "cpu: 1\nmemory: 0\n", tenantNamespace("acme"), runningPod("acme", "web", "2", "1Gi"))
+	previousRetention := HISTORY_RETENTION
+	t.Cleanup(func() { HISTORY_RETENTION = previousRetention })
+	HISTORY_RETENTION = 24 * time.Hour
+
+	// each run records a sample immediately and returns on the closed stop channel
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		stopCh := make(chan struct{})
+		close(stopCh)
+		RunCostSampler(stopCh)
+	}
+
+	samples, err := GetCostSamples("acme", start.Add(-time.Minute), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("expected a sample of each run, got %d", len(samples))
+	}
+	var billed time.Duration
+	for _, sample := range samples {
+		billed += sample.Interval
+	}
+	if elapsed := time.Since(start); billed > elapsed {
+		t.Errorf("expected the restarts to bill at most the elapsed %s, got %s", elapsed, billed)
+	}
+}
@@ -0,0 +1,108 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	// IdentityProviders are the configured identity providers keyed by their name
+	IdentityProviders map[string]IdentityProvider
+	// ADMIN_GROUP is the team or group of the platform admins, which are admin of every tenant
+	ADMIN_GROUP string
+	// TENANT_MAPPING maps a team or group to its tenants, unmapped groups are the tenant with the same name
+	TENANT_MAPPING map[string][]string
+
+	ErrUnknownIdentityProvider = errors.New("unknown identity provider")
+)
+
+// Identity is an authenticated user with the groups it is member of
+type Identity struct {
+	Provider string
+	Subject  string
+	Groups   []string
+	// GroupRoles are the roles granted by the identity provider in a group, e.g. to the maintainers of a GitHub team
+	GroupRoles map[string][]Role
+	// Token is the token of the identity provider used to check the groups again on a refresh
+	Token *oauth2.Token
+}
+
+// IdentityProvider authenticates users with the oauth2 authorization code flow
+type IdentityProvider interface {
+	// Name returns the name of the provider used in the login routes
+	Name() string
+	// AuthCodeURL returns the url of the provider to redirect the user to for the login with the state and the PKCE code challenge
+	AuthCodeURL(redirectURL string, state string, codeChallenge string) string
+	// Authenticate exchanges the authorization code with the PKCE code verifier and returns the identity of the user
+	Authenticate(ctx context.Context, code string, redirectURL string, codeVerifier string) (Identity, error)
+	// Refresh returns the current identity of the user with the token of a previous authentication
+	Refresh(ctx context.Context, token *oauth2.Token) (Identity, error)
+}
+
+// InitIdentityProviders creates the GitHub provider if CLIENT_ID is set and the OIDC provider if OIDC_ISSUER_URL is set
+func InitIdentityProviders() error {
+	IdentityProviders = make(map[string]IdentityProvider)
+
+	if CLIENT_ID != "" {
+		registerIdentityProvider(&GithubProvider{
+			ClientID:     CLIENT_ID,
+			ClientSecret: CLIENT_SECRET,
+			Organization: GITHUB_ORG,
+		})
+	}
+
+	if OIDC_ISSUER_URL != "" {
+		registerIdentityProvider(NewOIDCProvider(OIDC_NAME, OIDC_ISSUER_URL, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_SCOPES, OIDC_GROUPS_CLAIM))
+	}
+
+	if len(IdentityProviders) == 0 {
+		return errors.New("no identity provider configured, set CLIENT_ID or OIDC_ISSUER_URL")
+	}
+
+	return nil
+}
+
+// GetIdentityProvider returns the identity provider with the name
+func GetIdentityProvider(name string) (IdentityProvider, error) {
+	provider, ok := IdentityProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownIdentityProvider, name)
+	}
+	return provider, nil
+}
+
+// GetTenantsOfGroups returns the tenants of the groups with the TENANT_MAPPING applied
+func GetTenantsOfGroups(groups []string) []string {
+	tenants := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupTenants, ok := TENANT_MAPPING[group]
+		if !ok {
+			groupTenants = []string{group}
+		}
+		for _, tenant := range groupTenants {
+			if !Contains(tenant, tenants) {
+				tenants = append(tenants, tenant)
+			}
+		}
+	}
+	return tenants
+}
+
+// IsPlatformAdmin returns true if the identity is member of the ADMIN_GROUP
+func IsPlatformAdmin(identity Identity) bool {
+	return ADMIN_GROUP != "" && Contains(ADMIN_GROUP, identity.Groups)
+}
+
+// GetCallbackURL returns the callback url of the identity provider
+func GetCallbackURL(provider IdentityProvider) string {
+	return CALLBACK_URL + "/login/" + provider.Name() + "/callback"
+}
+
+// registerIdentityProvider adds the identity provider to the IdentityProviders
+func registerIdentityProvider(provider IdentityProvider) {
+	IdentityProviders[provider.Name()] = provider
+	InfoLogger.Printf("identity provider %s enabled", provider.Name())
+}
@@ -0,0 +1,124 @@
+package util
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	CACHE_RESYNC_PERIOD time.Duration
+	CACHE_SYNC_TIMEOUT  time.Duration
+)
+
+// InitInformers starts the shared informers of every cluster and blocks until their caches are synced
+func InitInformers(stopCh <-chan struct{}) error {
+	for _, cluster := range Clusters {
+		if err := cluster.initInformers(stopCh); err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// IsCacheSynced returns true if the informer caches of all clusters have been synced
+func IsCacheSynced() bool {
+	if len(Clusters) == 0 {
+		return false
+	}
+	for _, cluster := range Clusters {
+		if !cluster.IsCacheSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// IsCacheSynced returns true if all informer caches of the cluster have been synced
+func (cluster *Cluster) IsCacheSynced() bool {
+	if len(cluster.cacheSyncedFuncs) == 0 {
+		return false
+	}
+	for _, synced := range cluster.cacheSyncedFuncs {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// initInformers starts the shared informers of the cluster and blocks until their caches are synced
+func (cluster *Cluster) initInformers(stopCh <-chan struct{}) error {
+	cluster.InformerFactory = informers.NewSharedInformerFactory(cluster.Clientset, CACHE_RESYNC_PERIOD)
+
+	namespaceInformer := cluster.InformerFactory.Core().V1().Namespaces()
+	resourceQuotaInformer := cluster.InformerFactory.Core().V1().ResourceQuotas()
+	storageClassInformer := cluster.InformerFactory.Storage().V1().StorageClasses()
+
+	// the listers count the reads of the caches for the metrics
+	cluster.NamespaceLister = cachedNamespaceLister{NamespaceLister: namespaceInformer.Lister(), cluster: cluster}
+	cluster.ResourceQuotaLister = cachedResourceQuotaLister{ResourceQuotaLister: resourceQuotaInformer.Lister(), cluster: cluster}
+	cluster.StorageClassLister = cachedStorageClassLister{StorageClassLister: storageClassInformer.Lister(), cluster: cluster}
+
+	cluster.cacheSyncedFuncs = []cache.InformerSynced{
+		namespaceInformer.Informer().HasSynced,
+		resourceQuotaInformer.Informer().HasSynced,
+		storageClassInformer.Informer().HasSynced,
+	}
+
+	if DATASOURCE == DatasourcePrometheus {
+		// the pods, pvcs and ingresses are read from Prometheus on each request
+		cluster.PodLister = prometheusPodLister{cluster: cluster}
+		cluster.PVCLister = prometheusPVCLister{cluster: cluster}
+		cluster.IngressLister = prometheusIngressLister{cluster: cluster}
+	} else {
+		podInformer := cluster.InformerFactory.Core().V1().Pods()
+		pvcInformer := cluster.InformerFactory.Core().V1().PersistentVolumeClaims()
+		ingressInformer := cluster.InformerFactory.Networking().V1().Ingresses()
+
+		cluster.PodLister = cachedPodLister{PodLister: podInformer.Lister(), cluster: cluster}
+		cluster.PVCLister = cachedPVCLister{PersistentVolumeClaimLister: pvcInformer.Lister(), cluster: cluster}
+		cluster.IngressLister = cachedIngressLister{IngressLister: ingressInformer.Lister(), cluster: cluster}
+
+		cluster.cacheSyncedFuncs = append(cluster.cacheSyncedFuncs,
+			podInformer.Informer().HasSynced,
+			pvcInformer.Informer().HasSynced,
+			ingressInformer.Informer().HasSynced,
+		)
+	}
+
+	cluster.InformerFactory.Start(stopCh)
+
+	// stop waiting for the caches after CACHE_SYNC_TIMEOUT, the merge of the channels ends with the wait
+	timeoutCh := make(chan struct{})
+	timer := time.AfterFunc(CACHE_SYNC_TIMEOUT, func() { close(timeoutCh) })
+	defer timer.Stop()
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	InfoLogger.Printf("Waiting for informer caches of cluster %s to sync", cluster.Name)
+	if !cache.WaitForCacheSync(mergeStopChannels(stopCh, timeoutCh, doneCh), cluster.cacheSyncedFuncs...) {
+		return fmt.Errorf("informer caches not synced within %s", CACHE_SYNC_TIMEOUT)
+	}
+	InfoLogger.Printf("Informer caches of cluster %s synced", cluster.Name)
+
+	return nil
+}
+
+// mergeStopChannels returns a channel which is closed as soon as one of the provided channels is closed,
+// closing done ends the merge
+func mergeStopChannels(a, b <-chan struct{}, done <-chan struct{}) <-chan struct{} {
+	merged := make(chan struct{})
+	go func() {
+		select {
+		case <-a:
+		case <-b:
+		case <-done:
+		}
+		close(merged)
+	}()
+	return merged
+}
@@ -0,0 +1,377 @@
+package util
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	invoiceBucket = "invoices"
+	// an invoice is identified by its billing period
+	invoicePeriodFormat = "2006-01"
+)
+
+var (
+	ErrInvoiceClosed   = errors.New("invoice is already closed")
+	ErrInvoiceNotFound = errors.New("invoice not found")
+	ErrPeriodNotEnded  = errors.New("billing period has not ended yet")
+	ErrInvalidPeriod   = errors.New("invalid billing period, must be YYYY-MM")
+	ErrPeriodUncovered = errors.New("billing period is not fully covered by the recorded cost history")
+)
+
+// InvoiceLineItem is a billed category of an invoice, amount is without and total with the discount applied
+type InvoiceLineItem struct {
+	Category    string  `json:"category"`
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	Amount      float64 `json:"amount"`
+	Discount    float64 `json:"discount"`
+	Total       float64 `json:"total"`
+}
+
+// Invoice is the immutable bill of a tenant for a billing period
+type Invoice struct {
+	ID          string            `json:"id"`
+	Tenant      string            `json:"tenant"`
+	Currency    string            `json:"currency"`
+	PeriodStart time.Time         `json:"period_start"`
+	PeriodEnd   time.Time         `json:"period_end"`
+	ClosedAt    time.Time         `json:"closed_at"`
+	LineItems   []InvoiceLineItem `json:"line_items"`
+	Subtotal    float64           `json:"subtotal"`
+	Discount    float64           `json:"discount"`
+	Total       float64           `json:"total"`
+}
+
+// RunInvoiceCloser closes the invoices of the previous month of every tenant each hour until stopCh is closed
+func RunInvoiceCloser(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if err := CloseLastMonthInvoices(time.Now()); err != nil {
+			ErrorLogger.Printf("Error closing invoices: %v", err)
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CloseLastMonthInvoices closes the invoice of the month before now of every tenant if it is not closed yet,
+// deprovisioned tenants get a final invoice of the months they were billed in
+func CloseLastMonthInvoices(now time.Time) error {
+	lastMonth := now.UTC().AddDate(0, -1, 0).Format(invoicePeriodFormat)
+	periodStart, _ := time.Parse(invoicePeriodFormat, lastMonth)
+	tenants, err := getInvoiceTenants(periodStart, periodStart.AddDate(0, 1, 0))
+	if err != nil {
+		return err
+	}
+
+	for _, tenant := range tenants {
+		invoice, err := CloseInvoice(tenant, lastMonth, now)
+		if errors.Is(err, ErrInvoiceClosed) {
+			continue
+		}
+		// the history started after the beginning of the last month, e.g. in the month of the installation
+		if errors.Is(err, ErrPeriodUncovered) {
+			InfoLogger.Printf("invoices of %s are not closed: %s", lastMonth, err)
+			return nil
+		}
+		// the other tenants are closed anyway, the failed ones are retried on the next run
+		if err != nil {
+			ErrorLogger.Printf("Error closing invoice %s of tenant %s: %v", lastMonth, tenant, err)
+			continue
+		}
+		InfoLogger.Printf("invoice %s of tenant %s closed with total %.2f", invoice.ID, tenant, invoice.Total)
+	}
+	return nil
+}
+
+// getInvoiceTenants returns the sorted tenants in the clusters and the tenants with cost samples recorded in [from, to)
+// or deprovisioned since from, with DATASOURCE prometheus no samples are recorded and the audit trail has the deprovisioned tenants
+func getInvoiceTenants(from time.Time, to time.Time) ([]string, error) {
+	tenants, err := GetTenantsInCluster()
+	if err != nil {
+		return nil, err
+	}
+
+	historyTenants, err := GetCostHistoryTenants(from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, tenant := range historyTenants {
+		if !Contains(tenant, tenants) {
+			tenants = append(tenants, tenant)
+		}
+	}
+
+	events, err := GetAuditEvents("")
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if event.Action == "tenant.deprovisioned" && !event.Timestamp.Before(from) && !Contains(event.Tenant, tenants) {
+			tenants = append(tenants, event.Tenant)
+		}
+	}
+
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// CloseInvoice calculates and stores the invoice of the tenant for the billing period (YYYY-MM) in the currency of the tenant,
+// a closed invoice is never changed
+func CloseInvoice(tenant string, period string, now time.Time) (Invoice, error) {
+	periodStart, err := time.Parse(invoicePeriodFormat, period)
+	if err != nil {
+		return Invoice{}, ErrInvalidPeriod
+	}
+	periodEnd := periodStart.AddDate(0, 1, 0)
+
+	if now.Before(periodEnd) {
+		return Invoice{}, ErrPeriodNotEnded
+	}
+	if err := checkHistoryCoverage(periodStart, periodEnd); err != nil {
+		return Invoice{}, err
+	}
+
+	samples, err := GetCostSamples(tenant, periodStart, periodEnd)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	currency := GetTenantCurrency(tenant)
+	if samples, err = ConvertCostSamples(samples, currency); err != nil {
+		return Invoice{}, err
+	}
+
+	invoice := newInvoice(tenant, periodStart, periodEnd, samples)
+	invoice.Currency = currency
+	invoice.ClosedAt = now.UTC()
+
+	err = DB.Update(func(tx *bolt.Tx) error {
+		tenantInvoices, err := tx.Bucket([]byte(invoiceBucket)).CreateBucketIfNotExists([]byte(tenant))
+		if err != nil {
+			return err
+		}
+		// invoices are immutable once closed
+		if tenantInvoices.Get([]byte(invoice.ID)) != nil {
+			return ErrInvoiceClosed
+		}
+		return putJSON(tenantInvoices, invoice.ID, invoice)
+	})
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	return invoice, nil
+}
+
+// checkHistoryCoverage returns ErrPeriodUncovered if the recorded cost samples do not span [from, to) without a gap,
+// the samples may be up to a HISTORY_INTERVAL apart and after the start and before the end, with a tenth of the interval
+// for the delay of the sampler. With DATASOURCE prometheus the samples are calculated and the period must be within
+// the retention of Prometheus
+func checkHistoryCoverage(from time.Time, to time.Time) error {
+	if DATASOURCE == DatasourcePrometheus {
+		return checkPrometheusRetention(from)
+	}
+
+	timestamps, err := GetCostSampleTimestamps(from, to)
+	if err != nil {
+		return err
+	}
+
+	maxGap := HISTORY_INTERVAL + HISTORY_INTERVAL/10
+	previous := from
+	for _, timestamp := range append(timestamps, to) {
+		if timestamp.Sub(previous) > maxGap {
+			return ErrPeriodUncovered
+		}
+		previous = timestamp
+	}
+	return nil
+}
+
+// GetInvoices returns all closed invoices of the tenant sorted by billing period
+func GetInvoices(tenant string) ([]Invoice, error) {
+	invoices := make([]Invoice, 0)
+	err := DB.View(func(tx *bolt.Tx) error {
+		tenantInvoices := tx.Bucket([]byte(invoiceBucket)).Bucket([]byte(tenant))
+		if tenantInvoices == nil {
+			return nil
+		}
+		return tenantInvoices.ForEach(func(_, value []byte) error {
+			var invoice Invoice
+			if err := json.Unmarshal(value, &invoice); err != nil {
+				return err
+			}
+			invoices = append(invoices, invoice)
+			return nil
+		})
+	})
+	return invoices, err
+}
+
+// GetInvoice returns the closed invoice of the tenant with the id
+func GetInvoice(tenant string, id string) (Invoice, error) {
+	var invoice Invoice
+	err := DB.View(func(tx *bolt.Tx) error {
+		tenantInvoices := tx.Bucket([]byte(invoiceBucket)).Bucket([]byte(tenant))
+		if tenantInvoices == nil {
+			return ErrInvoiceNotFound
+		}
+		value := tenantInvoices.Get([]byte(id))
+		if value == nil {
+			return ErrInvoiceNotFound
+		}
+		return json.Unmarshal(value, &invoice)
+	})
+	return invoice, err
+}
+
+// InvoiceCSV returns the invoice as csv with a row for each line item and the totals
+func InvoiceCSV(invoice Invoice) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	rows := [][]string{{"invoice", "tenant", "currency", "period_start", "period_end", "category", "description", "quantity", "unit", "amount", "discount", "total"}}
+	for _, lineItem := range invoice.LineItems {
+		rows = append(rows, []string{
+			invoice.ID, invoice.Tenant, invoice.Currency, invoice.PeriodStart.Format(time.RFC3339), invoice.PeriodEnd.Format(time.RFC3339),
+			lineItem.Category, lineItem.Description, formatFloat(lineItem.Quantity), lineItem.Unit,
+			formatFloat(lineItem.Amount), formatFloat(lineItem.Discount), formatFloat(lineItem.Total),
+		})
+	}
+	rows = append(rows, []string{
+		invoice.ID, invoice.Tenant, invoice.Currency, invoice.PeriodStart.Format(time.RFC3339), invoice.PeriodEnd.Format(time.RFC3339),
+		"total", "", "", "", formatFloat(invoice.Subtotal), formatFloat(invoice.Discount), formatFloat(invoice.Total),
+	})
+
+	if err := writer.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// InvoicePDF returns the invoice as pdf document
+func InvoicePDF(invoice Invoice) []byte {
+	lines := []string{
+		fmt.Sprintf("Invoice %s", invoice.ID),
+		"",
+		fmt.Sprintf("Tenant:       %s", invoice.Tenant),
+		fmt.Sprintf("Period:       %s - %s", invoice.PeriodStart.Format("2006-01-02"), invoice.PeriodEnd.Add(-time.Second).Format("2006-01-02")),
+		fmt.Sprintf("Closed at:    %s", invoice.ClosedAt.Format(time.RFC3339)),
+		fmt.Sprintf("Currency:     %s", invoice.Currency),
+		"",
+		fmt.Sprintf("%-28s %14s %-14s %10s %10s %10s", "Description", "Quantity", "Unit", "Amount", "Discount", "Total"),
+	}
+	for _, lineItem := range invoice.LineItems {
+		lines = append(lines, fmt.Sprintf("%-28s %14.2f %-14s %10.2f %10.2f %10.2f",
+			lineItem.Description, lineItem.Quantity, lineItem.Unit, lineItem.Amount, lineItem.Discount, lineItem.Total))
+	}
+	lines = append(lines,
+		"",
+		fmt.Sprintf("%-58s %10.2f %10.2f %10.2f", "Total", invoice.Subtotal, invoice.Discount, invoice.Total),
+	)
+
+	return RenderTextPDF(lines)
+}
+
+// newInvoice returns the invoice of the tenant with the line items integrated over the cost samples of the billing period
+func newInvoice(tenant string, periodStart time.Time, periodEnd time.Time, samples []CostSample) Invoice {
+	cpu := InvoiceLineItem{Category: "cpu", Description: "CPU", Unit: "core-hours"}
+	memory := InvoiceLineItem{Category: "memory", Description: "Memory", Unit: "GB-hours"}
+	ingress := InvoiceLineItem{Category: "ingress", Description: "Ingress", Unit: "ingress-hours"}
+	if INGRESS_COST_PER_DOMAIN {
+		ingress.Unit = "domain-hours"
+	}
+	storage := make(map[string]*InvoiceLineItem)
+
+	for _, sample := range samples {
+		hours := sample.Interval.Hours()
+
+		addLineItemSample(&cpu, float64(sample.CPU)/1000*hours, sample.CPUListCost*hours, sample.CPUCost*hours)
+		addLineItemSample(&memory, float64(sample.Memory)/(1024*1024*1024)*hours, sample.MemoryListCost*hours, sample.MemoryCost*hours)
+		addLineItemSample(&ingress, float64(sample.Ingresses)*hours, sample.IngressListCost*hours, sample.IngressCost*hours)
+
+		for storageClass, size := range sample.Storage {
+			if storage[storageClass] == nil {
+				storage[storageClass] = &InvoiceLineItem{Category: "storage", Description: "Storage " + storageClass, Unit: "GB-hours"}
+			}
+			addLineItemSample(storage[storageClass], float64(size)/(1024*1024*1024)*hours, sample.StorageListCost[storageClass]*hours, sample.StorageCost[storageClass]*hours)
+		}
+	}
+
+	lineItems := []InvoiceLineItem{cpu, memory}
+	storageClasses := make([]string, 0, len(storage))
+	for storageClass := range storage {
+		storageClasses = append(storageClasses, storageClass)
+	}
+	sort.Strings(storageClasses)
+	for _, storageClass := range storageClasses {
+		lineItems = append(lineItems, *storage[storageClass])
+	}
+	lineItems = append(lineItems, ingress)
+
+	invoice := Invoice{
+		ID:          periodStart.Format(invoicePeriodFormat),
+		Tenant:      tenant,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		LineItems:   make([]InvoiceLineItem, 0, len(lineItems)),
+	}
+	for _, lineItem := range lineItems {
+		// omit unused categories
+		if lineItem.Quantity == 0 && lineItem.Total == 0 {
+			continue
+		}
+		lineItem.Quantity = roundCents(lineItem.Quantity)
+		lineItem.Amount = roundCents(lineItem.Amount)
+		lineItem.Total = roundCents(lineItem.Total)
+		lineItem.Discount = roundCents(lineItem.Amount - lineItem.Total)
+
+		invoice.LineItems = append(invoice.LineItems, lineItem)
+		invoice.Subtotal += lineItem.Amount
+		invoice.Discount += lineItem.Discount
+		invoice.Total += lineItem.Total
+	}
+	invoice.Subtotal = roundCents(invoice.Subtotal)
+	invoice.Discount = roundCents(invoice.Discount)
+	invoice.Total = roundCents(invoice.Total)
+
+	return invoice
+}
+
+// addLineItemSample adds the quantity and costs of a sample to the line item, samples without list cost have no discount
+func addLineItemSample(lineItem *InvoiceLineItem, quantity float64, listCost float64, cost float64) {
+	if listCost == 0 {
+		listCost = cost
+	}
+	lineItem.Quantity += quantity
+	lineItem.Amount += listCost
+	lineItem.Total += cost
+}
+
+// roundCents rounds the value to two decimals
+func roundCents(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// formatFloat formats the value with two decimals
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
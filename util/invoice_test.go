@@ -0,0 +1,66 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckHistoryCoverage(t *testing.T) {
+	setupStore(t)
+	setupCluster(t, "cpu: 1\n", tenantNamespace("acme"))
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(6 * time.Hour)
+	record := func(timestamps ...time.Time) {
+		for _, timestamp := range timestamps {
+			if err := RecordCostSamples(timestamp); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+	}
+
+	if err := checkHistoryCoverage(start, end); !errors.Is(err, ErrPeriodUncovered) {
+		t.Errorf("expected an uncovered period without samples, got %v", err)
+	}
+
+	// samples each hour with a delay of the sampler, the last hour is missing
+	record(start.Add(time.Second), start.Add(time.Hour+time.Minute), start.Add(2*time.Hour), start.Add(4*time.Hour), start.Add(5*time.Hour))
+	if err := checkHistoryCoverage(start, start.Add(2*time.Hour)); err != nil {
+		t.Errorf("expected a covered period, got %v", err)
+	}
+	if err := checkHistoryCoverage(start, start.Add(3*time.Hour)); err != nil {
+		t.Errorf("expected the end to be covered within an interval, got %v", err)
+	}
+	if err := checkHistoryCoverage(start, end); !errors.Is(err, ErrPeriodUncovered) {
+		t.Errorf("expected the gap of 2 hours to be uncovered, got %v", err)
+	}
+	if err := checkHistoryCoverage(start.Add(4*time.Hour), end.Add(time.Hour)); !errors.Is(err, ErrPeriodUncovered) {
+		t.Errorf("expected the missing end to be uncovered, got %v", err)
+	}
+
+	// the gap is filled
+	record(start.Add(3 * time.Hour))
+	if err := checkHistoryCoverage(start, end); err != nil {
+		t.Errorf("expected a covered period, got %v", err)
+	}
+}
+
+func TestCheckHistoryCoverageOfPrometheus(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	prometheus, _ := setupPrometheus(t, tenantSeries("acme"), "cpu: 1\n")
+
+	if err := checkHistoryCoverage(start, start.AddDate(0, 1, 0)); err != nil {
+		t.Errorf("expected a covered period, got %v", err)
+	}
+
+	// the start of the period is no longer within the retention
+	prometheus.oldest = start.Add(2 * time.Hour).Unix()
+	if err := checkHistoryCoverage(start, start.AddDate(0, 1, 0)); !errors.Is(err, ErrPeriodUncovered) {
+		t.Errorf("expected an uncovered period, got %v", err)
+	}
+	prometheus.oldest = start.Add(time.Hour).Unix()
+	if err := checkHistoryCoverage(start, start.AddDate(0, 1, 0)); err != nil {
+		t.Errorf("expected the start to be covered within an interval, got %v", err)
+	}
+}
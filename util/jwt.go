@@ -0,0 +1,311 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// SIGNING_KEYS_DIR is the directory with the PEM private keys, e.g. a mounted Kubernetes secret, the file name without extension is the key id
+	SIGNING_KEYS_DIR string
+	// SIGNING_KEY_ID is the id of the key which signs the tokens, the other keys only verify tokens
+	SIGNING_KEY_ID string
+
+	// signingKeys are the keys which verify tokens keyed by their id
+	signingKeys map[string]*SigningKey
+	// activeSigningKey signs the tokens, nil if the tokens are signed with the SECRET_KEY
+	activeSigningKey *SigningKey
+
+	ErrUnknownSigningKey = errors.New("unknown signing key")
+)
+
+// SigningKey is a private key with its id and the signing method of its type, RS256 for RSA and ES256 for P-256 keys
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+}
+
+// JWK is the public key of a signing key as JSON Web Key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA public key
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC public key
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKS is the JSON Web Key Set of all signing keys
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// InitSigningKeys loads the keys of the SIGNING_KEYS_DIR, without keys the tokens are signed with the SECRET_KEY
+// or, if it is not set either, with a key generated once in the DATA_PATH
+func InitSigningKeys() error {
+	signingKeys = make(map[string]*SigningKey)
+	activeSigningKey = nil
+
+	switch {
+	case SIGNING_KEYS_DIR != "":
+		if err := loadSigningKeys(SIGNING_KEYS_DIR); err != nil {
+			return err
+		}
+	case SECRET_KEY != "":
+		WarningLogger.Println("tokens are signed with the SECRET_KEY, the JWKS is empty")
+		return nil
+	default:
+		key, err := loadOrGenerateSigningKey(filepath.Join(DATA_PATH, "signing-key.pem"))
+		if err != nil {
+			return err
+		}
+		signingKeys[key.ID] = key
+		SIGNING_KEY_ID = key.ID
+	}
+
+	key, ok := signingKeys[SIGNING_KEY_ID]
+	if !ok {
+		return fmt.Errorf("%w %s, SIGNING_KEY_ID must be one of the keys of %s", ErrUnknownSigningKey, SIGNING_KEY_ID, SIGNING_KEYS_DIR)
+	}
+	activeSigningKey = key
+	InfoLogger.Printf("tokens are signed with the key %s (%s)", key.ID, key.Method.Alg())
+	return nil
+}
+
+// SignToken returns the token of the claims signed with the active key
+func SignToken(claims jwt.MapClaims) (string, error) {
+	if activeSigningKey == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	}
+
+	token := jwt.NewWithClaims(activeSigningKey.Method, claims)
+	token.Header["kid"] = activeSigningKey.ID
+	return token.SignedString(activeSigningKey.PrivateKey)
+}
+
+// ParseToken parses the token and verifies its signature with the key of its key id
+func ParseToken(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if activeSigningKey == nil {
+			if token.Method != jwt.SigningMethodHS256 {
+				return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+			}
+			return []byte(SECRET_KEY), nil
+		}
+
+		keyID, _ := token.Header["kid"].(string)
+		key, ok := signingKeys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("%w %s", ErrUnknownSigningKey, keyID)
+		}
+		// the algorithm of the token must be the one of the key
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return key.PrivateKey.Public(), nil
+	})
+}
+
+// GetJWKS returns the public keys of all signing keys sorted by their id
+func GetJWKS() JWKS {
+	jwks := JWKS{
+		Keys: make([]JWK, 0, len(signingKeys)),
+	}
+	for _, key := range signingKeys {
+		jwks.Keys = append(jwks.Keys, key.jwk())
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+	return jwks
+}
+
+// activeSigningKeyID returns the id of the key which signs the tokens, empty if the tokens are signed with the SECRET_KEY
+func activeSigningKeyID() string {
+	if activeSigningKey == nil {
+		return ""
+	}
+	return activeSigningKey.ID
+}
+
+// tokenEncryptionKey returns the AES-256 key derived from the private key of the signing key with the id
+// or, for an empty id, from the SECRET_KEY
+func tokenEncryptionKey(keyID string) ([]byte, error) {
+	var secret []byte
+	if keyID == "" {
+		if SECRET_KEY == "" {
+			return nil, fmt.Errorf("%w, the SECRET_KEY is not set", ErrUnknownSigningKey)
+		}
+		secret = []byte(SECRET_KEY)
+	} else {
+		key, ok := signingKeys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("%w %s", ErrUnknownSigningKey, keyID)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		secret = der
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("tenant-api session token"))
+	return mac.Sum(nil), nil
+}
+
+// loadSigningKeys loads every key of the directory, the active key defaults to the last key id
+func loadSigningKeys(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	keyIDs := make([]string, 0, len(files))
+	for _, file := range files {
+		// skip the hidden files like the ..data of a mounted secret
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+
+		keyID := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		key, err := parseSigningKey(keyID, data)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", file.Name(), err)
+		}
+		signingKeys[keyID] = key
+		keyIDs = append(keyIDs, keyID)
+	}
+
+	if len(keyIDs) == 0 {
+		return fmt.Errorf("no signing keys in %s", dir)
+	}
+
+	if SIGNING_KEY_ID == "" {
+		sort.Strings(keyIDs)
+		SIGNING_KEY_ID = keyIDs[len(keyIDs)-1]
+		InfoLogger.Printf("SIGNING_KEY_ID set using default: %s", SIGNING_KEY_ID)
+	}
+	return nil
+}
+
+// loadOrGenerateSigningKey loads the key of the file or generates a P-256 key and stores it in the file
+func loadOrGenerateSigningKey(path string) (*SigningKey, error) {
+	keyID := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		return parseSigningKey(keyID, data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	InfoLogger.Printf("generated the signing key %s", path)
+
+	return &SigningKey{ID: keyID, Method: jwt.SigningMethodES256, PrivateKey: privateKey}, nil
+}
+
+// parseSigningKey parses a PKCS#1, PKCS#8 or SEC 1 PEM private key
+func parseSigningKey(keyID string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key")
+	}
+
+	var privateKey interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if privateKey.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		return &SigningKey{ID: keyID, Method: jwt.SigningMethodRS256, PrivateKey: privateKey}, nil
+	case *ecdsa.PrivateKey:
+		if privateKey.Curve != elliptic.P256() {
+			return nil, errors.New("EC keys must use the P-256 curve")
+		}
+		return &SigningKey{ID: keyID, Method: jwt.SigningMethodES256, PrivateKey: privateKey}, nil
+	default:
+		return nil, errors.New("only RSA and EC private keys are supported")
+	}
+}
+
+// jwk returns the public key as JSON Web Key
+func (key *SigningKey) jwk() JWK {
+	jwk := JWK{
+		KeyID:     key.ID,
+		Use:       "sig",
+		Algorithm: key.Method.Alg(),
+	}
+
+	switch publicKey := key.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// the coordinates have the size of the curve
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = publicKey.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size)))
+	}
+	return jwk
+}
@@ -0,0 +1,229 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// setupSigningKeys uses a temporary SIGNING_KEYS_DIR with the PEM private keys keyed by their file name
+func setupSigningKeys(t *testing.T, keys map[string]interface{}) string {
+	t.Helper()
+	InitLoggers()
+
+	previousKeysDir, previousKeyID, previousSecretKey, previousDataPath := SIGNING_KEYS_DIR, SIGNING_KEY_ID, SECRET_KEY, DATA_PATH
+	previousKeys, previousActiveKey := signingKeys, activeSigningKey
+	t.Cleanup(func() {
+		SIGNING_KEYS_DIR, SIGNING_KEY_ID, SECRET_KEY, DATA_PATH = previousKeysDir, previousKeyID, previousSecretKey, previousDataPath
+		signingKeys, activeSigningKey = previousKeys, previousActiveKey
+	})
+
+	SIGNING_KEYS_DIR, SIGNING_KEY_ID, SECRET_KEY = t.TempDir(), "", ""
+	for name, key := range keys {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("cannot marshal the key %s: %s", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(SIGNING_KEYS_DIR, name), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			t.Fatalf("cannot write the key %s: %s", name, err)
+		}
+	}
+	return SIGNING_KEYS_DIR
+}
+
+// setupSecretKey signs the tokens and encrypts the session tokens with a SECRET_KEY
+func setupSecretKey(t *testing.T) {
+	t.Helper()
+	setupSigningKeys(t, nil)
+	SIGNING_KEYS_DIR, SECRET_KEY = "", "secret"
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// isUnknownSigningKey returns true if the token was rejected for its unknown key id
+func isUnknownSigningKey(err error) bool {
+	var validationErr *jwt.ValidationError
+	return errors.As(err, &validationErr) && errors.Is(validationErr.Inner, ErrUnknownSigningKey)
+}
+
+func TestGetJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	dir := setupSigningKeys(t, map[string]interface{}{"2026-01.pem": rsaKey, "2026-02.pem": ecKey})
+	// the files of a mounted secret are links into a hidden directory
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0700); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".hidden"), []byte("not a key"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if SIGNING_KEY_ID != "2026-02" {
+		t.Errorf("expected the last key id to sign, got %s", SIGNING_KEY_ID)
+	}
+
+	jwks := GetJWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != "2026-01" || jwks.Keys[1].KeyID != "2026-02" {
+		t.Fatalf("expected the public keys sorted by their id, got %+v", jwks.Keys)
+	}
+
+	rsaJWK := jwks.Keys[0]
+	if rsaJWK.KeyType != "RSA" || rsaJWK.Algorithm != "RS256" || rsaJWK.Use != "sig" || rsaJWK.E != "AQAB" {
+		t.Errorf("unexpected RSA key %+v", rsaJWK)
+	}
+	if n, _ := base64.RawURLEncoding.DecodeString(rsaJWK.N); new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 {
+		t.Errorf("expected the modulus of the RSA key")
+	}
+
+	ecJWK := jwks.Keys[1]
+	if ecJWK.KeyType != "EC" || ecJWK.Algorithm != "ES256" || ecJWK.Curve != "P-256" {
+		t.Errorf("unexpected EC key %+v", ecJWK)
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(ecJWK.X)
+	y, _ := base64.RawURLEncoding.DecodeString(ecJWK.Y)
+	if len(x) != 32 || len(y) != 32 || new(big.Int).SetBytes(x).Cmp(ecKey.X) != 0 || new(big.Int).SetBytes(y).Cmp(ecKey.Y) != 0 {
+		t.Errorf("expected the padded coordinates of the EC key")
+	}
+
+	// the JWKS never contains the private keys
+	if ecJWK.N != "" || rsaJWK.X != "" {
+		t.Errorf("unexpected key material %+v", jwks.Keys)
+	}
+}
+
+func TestSigningKeySelection(t *testing.T) {
+	previousKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	currentKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	setupSigningKeys(t, map[string]interface{}{"previous.pem": previousKey, "current.pem": currentKey})
+	claims := jwt.MapClaims{"sub": "jane", "exp": time.Now().Add(time.Hour).Unix()}
+
+	// tokens signed with the previous key
+	SIGNING_KEY_ID = "previous"
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	previousToken, err := SignToken(claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	SIGNING_KEY_ID = "current"
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	currentToken, err := SignToken(claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the token has the key id and the algorithm of the active key
+	token, err := ParseToken(currentToken)
+	if err != nil || !token.Valid {
+		t.Fatalf("expected a valid token, got %v", err)
+	}
+	if token.Header["kid"] != "current" || token.Method.Alg() != "ES256" {
+		t.Errorf("expected the token to be signed with the current key, got %v", token.Header)
+	}
+
+	// the tokens of the previous key stay valid until it is removed
+	if token, err := ParseToken(previousToken); err != nil || !token.Valid || token.Header["kid"] != "previous" {
+		t.Errorf("expected the token of the previous key to be valid, got %v", err)
+	}
+
+	// a token is verified with the key of its key id and its algorithm
+	forged := func(method jwt.SigningMethod, keyID string, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		if keyID != "" {
+			token.Header["kid"] = keyID
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return signed
+	}
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := ParseToken(forged(jwt.SigningMethodES256, "", currentKey)); !isUnknownSigningKey(err) {
+		t.Errorf("expected a token without key id to be rejected, got %v", err)
+	}
+	if _, err := ParseToken(forged(jwt.SigningMethodES256, "unknown", currentKey)); !isUnknownSigningKey(err) {
+		t.Errorf("expected a token with an unknown key id to be rejected, got %v", err)
+	}
+	if _, err := ParseToken(forged(jwt.SigningMethodES256, "current", otherKey)); err == nil {
+		t.Errorf("expected a token signed with another key to be rejected")
+	}
+	if _, err := ParseToken(forged(jwt.SigningMethodRS256, "current", previousKey)); err == nil {
+		t.Errorf("expected a token with the algorithm of another key to be rejected")
+	}
+	if _, err := ParseToken(forged(jwt.SigningMethodHS256, "current", []byte("secret"))); err == nil {
+		t.Errorf("expected a token signed with a secret to be rejected")
+	}
+
+	// the removed key does not verify its tokens anymore
+	os.Remove(filepath.Join(SIGNING_KEYS_DIR, "previous.pem"))
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := ParseToken(previousToken); !isUnknownSigningKey(err) {
+		t.Errorf("expected the token of the removed key to be rejected, got %v", err)
+	}
+
+	SIGNING_KEY_ID = "previous"
+	if err := InitSigningKeys(); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Errorf("expected the removed key not to sign, got %v", err)
+	}
+}
+
+func TestInitSigningKeysRejectsWeakKeys(t *testing.T) {
+	weakRSAKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	for name, key := range map[string]interface{}{"rsa-1024.pem": weakRSAKey, "p-384.pem": p384Key} {
+		setupSigningKeys(t, map[string]interface{}{name: key})
+		if err := InitSigningKeys(); err == nil {
+			t.Errorf("expected the key %s to be rejected", name)
+		}
+	}
+
+	setupSigningKeys(t, nil)
+	if err := InitSigningKeys(); err == nil {
+		t.Errorf("expected an empty directory to be rejected")
+	}
+}
+
+func TestInitSigningKeysGeneratesKey(t *testing.T) {
+	setupSigningKeys(t, nil)
+	SIGNING_KEYS_DIR, DATA_PATH = "", t.TempDir()
+
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	generated := GetJWKS()
+	if len(generated.Keys) != 1 || generated.Keys[0].KeyID != "signing-key" || generated.Keys[0].Algorithm != "ES256" {
+		t.Fatalf("expected a generated P-256 key, got %+v", generated.Keys)
+	}
+
+	// the generated key is kept across restarts
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if loaded := GetJWKS(); loaded.Keys[0] != generated.Keys[0] {
+		t.Errorf("expected the generated key to be loaded, got %+v", loaded.Keys[0])
+	}
+}
@@ -1,33 +1,102 @@
 package util
 
 import (
-	"context"
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 )
 
 var (
-	Clientset       *kubernetes.Clientset
-	DISCOUNT_LABEL  string
-	EXCLUDE_STRINGS []string
+	Clientset               *kubernetes.Clientset
+	DISCOUNT_LABEL          string
+	EXCLUDE_STRINGS         []string
+	EXCLUDE_TERMINATED_PODS bool
+	TENANT_LABEL            string
 )
 
-// GetPodsByTenant returns a map of pods for each tenant
+// GetTenantsInCluster returns the tenants of all clusters, a tenant is a namespace with the TENANT_LABEL
+func GetTenantsInCluster() ([]string, error) {
+	tenants := make([]string, 0)
+	for _, cluster := range Clusters {
+		clusterTenants, err := cluster.GetTenantsInCluster()
+		if err != nil {
+			return nil, err
+		}
+		for _, tenant := range clusterTenants {
+			if !Contains(tenant, tenants) {
+				tenants = append(tenants, tenant)
+			}
+		}
+	}
+
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// GetTenantsInCluster returns the names of the namespaces with the TENANT_LABEL
+func (cluster *Cluster) GetTenantsInCluster() ([]string, error) {
+	selector, err := labels.Parse(TENANT_LABEL)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := cluster.NamespaceLister.List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		tenants = append(tenants, namespace.Name)
+	}
+	return tenants, nil
+}
+
+// GetPodsByTenant returns a map of pods for each tenant of all clusters
 func GetPodsByTenant(tenants []string) (map[string][]string, error) {
+	clusterTenantPods, err := GetPodsByClusterByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	return MergeStringsByTenant(clusterTenantPods), nil
+}
+
+// GetPodsByClusterByTenant returns a map of pods for each tenant keyed by cluster
+func GetPodsByClusterByTenant(tenants []string) (map[string]map[string][]string, error) {
+	clusterTenantPods := make(map[string]map[string][]string)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		tenantPods, err := snapshot.GetPodsByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantPods[cluster.Name] = tenantPods
+	}
+	return clusterTenantPods, nil
+}
+
+// GetPodsByTenant returns a map of pods for each tenant
+func (cluster *Cluster) GetPodsByTenant(tenants []string) (map[string][]string, error) {
 	tenantPods := make(map[string][]string)
 	// get namespace with same name as tenant and get pods
 	for _, tenant := range tenants {
-		pods, err := Clientset.CoreV1().Pods(tenant).List(context.TODO(), metav1.ListOptions{})
-		if err != nil && !strings.Contains(err.Error(), "not found") {
+		pods, err := cluster.PodLister.Pods(tenant).List(labels.Everything())
+		if err != nil {
 			return nil, err
 		}
 
 		// for each pod add it to the list of pods for the namespace
 		tenantPods[tenant] = make([]string, 0)
-		for _, pod := range pods.Items {
+		for _, pod := range pods {
 			tenantPods[tenant] = append(tenantPods[tenant], strings.Split(pod.Name, "-x-")[0])
 		}
 	}
@@ -35,24 +104,51 @@ func GetPodsByTenant(tenants []string) (map[string][]string, error) {
 	return tenantPods, nil
 }
 
+// GetPVCsByTenantByStorageClass returns a map of pvc names by storage class for each tenant of all clusters
 func GetPVCsByTenantByStorageClass(tenants []string) (map[string]map[string][]string, error) {
+	clusterTenantPVCs, err := GetPVCsByClusterByTenantByStorageClass(tenants)
+	if err != nil {
+		return nil, err
+	}
+	return MergeStringsByTenantByKey(clusterTenantPVCs), nil
+}
+
+// GetPVCsByClusterByTenantByStorageClass returns a map of pvc names by storage class for each tenant keyed by cluster
+func GetPVCsByClusterByTenantByStorageClass(tenants []string) (map[string]map[string]map[string][]string, error) {
+	clusterTenantPVCs := make(map[string]map[string]map[string][]string)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		tenantPVCs, err := snapshot.GetPVCsByTenantByStorageClass(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantPVCs[cluster.Name] = tenantPVCs
+	}
+	return clusterTenantPVCs, nil
+}
+
+// GetPVCsByTenantByStorageClass returns a map of pvc names by storage class for each tenant
+func (cluster *Cluster) GetPVCsByTenantByStorageClass(tenants []string) (map[string]map[string][]string, error) {
 	tenantPVCs := make(map[string]map[string][]string)
-	storageClasses, err := GetStorageClassesInCluster()
+	storageClasses, err := cluster.GetStorageClassesInCluster()
 	if err != nil {
 		return nil, err
 	}
 
 	for _, tenant := range tenants {
 		tenantPVCs[tenant] = make(map[string][]string)
+		pvcs, err := cluster.PVCLister.PersistentVolumeClaims(tenant).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+
 		for _, storageClass := range storageClasses {
 			// get pvc by storageclass pvc spec
-			pvcs, err := Clientset.CoreV1().PersistentVolumeClaims(tenant).List(context.TODO(), metav1.ListOptions{})
-			if err != nil && !strings.Contains(err.Error(), "not found") {
-				return nil, err
-			}
-
-			for _, pvc := range pvcs.Items {
-				if *pvc.Spec.StorageClassName == storageClass {
+			for _, pvc := range pvcs {
+				if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName == storageClass {
 					tenantPVCs[tenant][storageClass] = append(tenantPVCs[tenant][storageClass], pvc.Name)
 				}
 			}
@@ -62,92 +158,133 @@ func GetPVCsByTenantByStorageClass(tenants []string) (map[string]map[string][]st
 	return tenantPVCs, nil
 }
 
-// GetCPURequestsSumByTenant returns the sum of CPU requests for each tenant
+// GetCPURequestsSumByTenant returns the sum of CPU requests for each tenant of all clusters
 func GetCPURequestsSumByTenant(tenants []string) (map[string]int64, error) {
-	tenantCPURequests := make(map[string]int64)
-	for _, tenant := range tenants {
-		pods, err := Clientset.CoreV1().Pods(tenant).List(context.TODO(), metav1.ListOptions{})
+	clusterTenantCPURequests, err := GetCPURequestsSumByClusterByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	return SumInt64ByTenant(clusterTenantCPURequests), nil
+}
 
-		if err != nil && !strings.Contains(err.Error(), "not found") {
+// GetCPURequestsSumByClusterByTenant returns the sum of CPU requests for each tenant keyed by cluster
+func GetCPURequestsSumByClusterByTenant(tenants []string) (map[string]map[string]int64, error) {
+	clusterTenantCPURequests := make(map[string]map[string]int64)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
 			return nil, err
 		}
+		tenantCPURequests, err := snapshot.GetCPURequestsSumByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantCPURequests[cluster.Name] = tenantCPURequests
+	}
+	return clusterTenantCPURequests, nil
+}
 
-		for _, pod := range pods.Items {
+// GetCPURequestsSumByTenant returns the sum of CPU requests for each tenant
+func (cluster *Cluster) GetCPURequestsSumByTenant(tenants []string) (map[string]int64, error) {
+	tenantCPURequests := make(map[string]int64)
+	for _, tenant := range tenants {
+		pods, err := cluster.listRequestingPods(tenant)
+		if err != nil {
+			return nil, err
+		}
 
-			// get DISCOUNT_REQUEST by DISCOUNT_LABEL
-			discount := pod.Labels[DISCOUNT_LABEL]
-			if discount == "" {
-				discount = "0"
-			}
-			// convert to float64
-			discountFloat, err := strconv.ParseFloat(discount, 64)
-			if err != nil || discountFloat < 0 || discountFloat > 1 {
-				return nil, err
-			}
+		for _, pod := range pods {
+			podRequests := GetPodRequests(pod)
+			tenantCPURequests[tenant] += podRequests.Cpu().MilliValue()
+		}
+	}
+	return tenantCPURequests, nil
+}
 
-			CPU_DISCOUNT_PERCENT = discountFloat
+// GetMemoryRequestsSumByTenant returns the sum of memory requests for each tenant of all clusters
+func GetMemoryRequestsSumByTenant(tenants []string) (map[string]int64, error) {
+	clusterTenantMemoryRequests, err := GetMemoryRequestsSumByClusterByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	return SumInt64ByTenant(clusterTenantMemoryRequests), nil
+}
 
-			tenantCPURequests[tenant] += pod.Spec.Containers[0].Resources.Requests.Cpu().MilliValue()
+// GetMemoryRequestsSumByClusterByTenant returns the sum of memory requests for each tenant keyed by cluster
+func GetMemoryRequestsSumByClusterByTenant(tenants []string) (map[string]map[string]int64, error) {
+	clusterTenantMemoryRequests := make(map[string]map[string]int64)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		tenantMemoryRequests, err := snapshot.GetMemoryRequestsSumByTenant(tenants)
+		if err != nil {
+			return nil, err
 		}
+		clusterTenantMemoryRequests[cluster.Name] = tenantMemoryRequests
 	}
-	return tenantCPURequests, nil
+	return clusterTenantMemoryRequests, nil
 }
 
 // GetMemoryRequestsSumByTenant returns the sum of memory requests for each tenant
-func GetMemoryRequestsSumByTenant(tenants []string) (map[string]int64, error) {
+func (cluster *Cluster) GetMemoryRequestsSumByTenant(tenants []string) (map[string]int64, error) {
 	tenantMemoryRequests := make(map[string]int64)
 	for _, tenant := range tenants {
-		pods, err := Clientset.CoreV1().Pods(tenant).List(context.TODO(), metav1.ListOptions{})
-		if err != nil && !strings.Contains(err.Error(), "not found") {
+		pods, err := cluster.listRequestingPods(tenant)
+		if err != nil {
 			return nil, err
 		}
 
-		for _, pod := range pods.Items {
-
-			// get DISCOUNT_REQUEST by DISCOUNT_LABEL
-			discount := pod.Labels[DISCOUNT_LABEL]
-			if discount == "" {
-				discount = "0"
-			}
-			// convert to float64
-			discountFloat, err := strconv.ParseFloat(discount, 64)
-			if err != nil || discountFloat < 0 || discountFloat > 1 {
-				return nil, err
-			}
+		for _, pod := range pods {
+			podRequests := GetPodRequests(pod)
+			tenantMemoryRequests[tenant] += podRequests.Memory().Value()
+		}
+	}
+	return tenantMemoryRequests, nil
+}
 
-			MEMORY_DISCOUNT_PERCENT = discountFloat
+// GetStorageRequestsSumByTenant returns the sum of storage requests for each tenant of all clusters
+func GetStorageRequestsSumByTenant(tenants []string) (map[string]map[string]int64, error) {
+	clusterTenantPVCs, err := GetStorageRequestsSumByClusterByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	return SumInt64ByTenantByKey(clusterTenantPVCs), nil
+}
 
-			tenantMemoryRequests[tenant] += pod.Spec.Containers[0].Resources.Requests.Memory().Value()
+// GetStorageRequestsSumByClusterByTenant returns the sum of storage requests for each tenant keyed by cluster
+func GetStorageRequestsSumByClusterByTenant(tenants []string) (map[string]map[string]map[string]int64, error) {
+	clusterTenantPVCs := make(map[string]map[string]map[string]int64)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
 		}
+		tenantPVCs, err := snapshot.GetStorageRequestsSumByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantPVCs[cluster.Name] = tenantPVCs
 	}
-	return tenantMemoryRequests, nil
+	return clusterTenantPVCs, nil
 }
 
 // GetStorageRequestsSumByTenant returns the sum of storage requests for each tenant
-func GetStorageRequestsSumByTenant(tenants []string) (map[string]map[string]int64, error) {
+func (cluster *Cluster) GetStorageRequestsSumByTenant(tenants []string) (map[string]map[string]int64, error) {
 	tenantPVCs := make(map[string]map[string]int64)
 	for _, tenant := range tenants {
-		pvcList, err := Clientset.CoreV1().PersistentVolumeClaims(tenant).List(context.TODO(), metav1.ListOptions{})
-
-		if err != nil && !strings.Contains(err.Error(), "not found") {
+		pvcList, err := cluster.PVCLister.PersistentVolumeClaims(tenant).List(labels.Everything())
+		if err != nil {
 			return nil, err
 		}
 
 		// create a map for each storage class with a count of pvc size if it exists
 		tenantPVCs[tenant] = make(map[string]int64)
-		for _, pvc := range pvcList.Items {
-			// get DISCOUNT_REQUEST by DISCOUNT_LABEL
-			discount := pvc.Labels[DISCOUNT_LABEL]
-			if discount == "" {
-				discount = "0"
-			}
-			// convert to float64
-			discountFloat, err := strconv.ParseFloat(discount, 64)
-			if err != nil || discountFloat < 0 || discountFloat > 1 {
-				return nil, err
+		for _, pvc := range pvcList {
+			if pvc.Spec.StorageClassName == nil {
+				continue
 			}
-
-			STORAGE_DISCOUNT_PERCENT = discountFloat
 			tenantPVCs[tenant][*pvc.Spec.StorageClassName] += pvc.Spec.Resources.Requests.Storage().Value()
 		}
 
@@ -159,36 +296,44 @@ func GetStorageRequestsSumByTenant(tenants []string) (map[string]map[string]int6
 	return tenantPVCs, nil
 }
 
-// GetIngressRequestsSumByTenant returns the sum of ingress requests for each tenant
+// GetIngressRequestsSumByTenant returns the sum of ingress requests for each tenant of all clusters
 func GetIngressRequestsSumByTenant(tenants []string) (map[string][]string, error) {
+	clusterTenantsIngress, err := GetIngressRequestsSumByClusterByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	return MergeStringsByTenant(clusterTenantsIngress), nil
+}
+
+// GetIngressRequestsSumByClusterByTenant returns the sum of ingress requests for each tenant keyed by cluster
+func GetIngressRequestsSumByClusterByTenant(tenants []string) (map[string]map[string][]string, error) {
+	clusterTenantsIngress := make(map[string]map[string][]string)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		tenantsIngress, err := snapshot.GetIngressRequestsSumByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantsIngress[cluster.Name] = tenantsIngress
+	}
+	return clusterTenantsIngress, nil
+}
+
+// GetIngressRequestsSumByTenant returns the sum of ingress requests for each tenant
+func (cluster *Cluster) GetIngressRequestsSumByTenant(tenants []string) (map[string][]string, error) {
 	tenantsIngress := make(map[string][]string)
 
 	for _, tenant := range tenants {
 		// get ingress for each namespace in the tenant and add it to the map of ingress for the tenant
-		ingressList, err := Clientset.NetworkingV1().Ingresses(tenant).List(context.TODO(), metav1.ListOptions{})
-
-		if err != nil && !strings.Contains(err.Error(), "not found") {
+		ingressList, err := cluster.listBilledIngresses(tenant)
+		if err != nil {
 			return nil, err
 		}
 
-		for _, ingress := range ingressList.Items {
-			// get DISCOUNT_REQUEST by DISCOUNT_LABEL
-			discount := ingress.Labels[DISCOUNT_LABEL]
-			if discount == "" {
-				discount = "0"
-			}
-			// convert to float64
-			discountFloat, err := strconv.ParseFloat(discount, 64)
-			if err != nil || discountFloat < 0 || discountFloat > 1 {
-				return nil, err
-			}
-
-			INGRESS_DISCOUNT_PERCENT = discountFloat
-
-			if strings.Contains(ingress.Name, "vcluster") && EXCLUDE_INGRESS_VCLUSTER {
-				continue
-			}
-
+		for _, ingress := range ingressList {
 			// apend ingress hostname to the list of ingress for the tenant
 			for _, rule := range ingress.Spec.Rules {
 				tenantsIngress[tenant] = append(tenantsIngress[tenant], rule.Host)
@@ -199,15 +344,86 @@ func GetIngressRequestsSumByTenant(tenants []string) (map[string][]string, error
 	return tenantsIngress, nil
 }
 
+// GetDiscount returns the discount set with the DISCOUNT_LABEL of the object labels, an invalid discount returns an error
+func GetDiscount(objectLabels map[string]string) (float64, error) {
+	discount, ok := objectLabels[DISCOUNT_LABEL]
+	if !ok || discount == "" {
+		return 0, nil
+	}
+
+	// convert to float64
+	discountFloat, err := strconv.ParseFloat(discount, 64)
+	if err != nil || discountFloat < 0 || discountFloat > 1 {
+		return 0, fmt.Errorf("invalid discount %s=%q, must be a float between 0 and 1", DISCOUNT_LABEL, discount)
+	}
+
+	return discountFloat, nil
+}
+
+// listRequestingPods returns the pods of the tenant which hold their requested resources
+func (cluster *Cluster) listRequestingPods(tenant string) ([]*v1.Pod, error) {
+	pods, err := cluster.PodLister.Pods(tenant).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	if !EXCLUDE_TERMINATED_PODS {
+		return pods, nil
+	}
+
+	requestingPods := make([]*v1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if !IsPodTerminated(pod) {
+			requestingPods = append(requestingPods, pod)
+		}
+	}
+	return requestingPods, nil
+}
+
+// listBilledIngresses returns the ingresses of the tenant without the excluded vcluster ingresses
+func (cluster *Cluster) listBilledIngresses(tenant string) ([]*networkingv1.Ingress, error) {
+	ingresses, err := cluster.IngressLister.Ingresses(tenant).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	billedIngresses := make([]*networkingv1.Ingress, 0, len(ingresses))
+	for _, ingress := range ingresses {
+		if strings.Contains(ingress.Name, "vcluster") && EXCLUDE_INGRESS_VCLUSTER {
+			continue
+		}
+		billedIngresses = append(billedIngresses, ingress)
+	}
+	return billedIngresses, nil
+}
+
+// GetStorageClassesInCluster returns the names of the storage classes of all clusters
 func GetStorageClassesInCluster() ([]string, error) {
 	storageClasses := make([]string, 0)
-	scList, err := Clientset.StorageV1().StorageClasses().List(context.TODO(), metav1.ListOptions{})
+	for _, cluster := range Clusters {
+		clusterStorageClasses, err := cluster.GetStorageClassesInCluster()
+		if err != nil {
+			return nil, err
+		}
+		for _, storageClass := range clusterStorageClasses {
+			if !Contains(storageClass, storageClasses) {
+				storageClasses = append(storageClasses, storageClass)
+			}
+		}
+	}
 
-	if err != nil && !strings.Contains(err.Error(), "not found") {
+	return storageClasses, nil
+}
+
+// GetStorageClassesInCluster returns the names of all storage classes in the cluster
+func (cluster *Cluster) GetStorageClassesInCluster() ([]string, error) {
+	storageClasses := make([]string, 0)
+	scList, err := cluster.StorageClassLister.List(labels.Everything())
+	if err != nil {
 		return nil, err
 	}
 
-	for _, sc := range scList.Items {
+	for _, sc := range scList {
 		storageClasses = append(storageClasses, sc.Name)
 	}
 
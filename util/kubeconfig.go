@@ -0,0 +1,77 @@
+package util
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	KUBECONFIG   string
+	KUBE_CONTEXT string
+)
+
+// GetRestConfig returns the in-cluster config or, if a kubeconfig or context is set or the api runs outside a cluster, the config of the kubeconfig context
+func GetRestConfig() (*rest.Config, error) {
+	if KUBECONFIG == "" && KUBE_CONTEXT == "" {
+		config, err := rest.InClusterConfig()
+		if err == nil {
+			InfoLogger.Println("Using in-cluster config")
+			return config, nil
+		}
+		WarningLogger.Printf("Cannot create in-cluster config, falling back to the default kubeconfig: %v", err)
+	}
+
+	return GetRestConfigForContext(KUBE_CONTEXT)
+}
+
+// GetRestConfigForContext returns the config of the provided kubeconfig context, uses the current context if it is empty
+func GetRestConfigForContext(kubeContext string) (*rest.Config, error) {
+	contexts, currentContext, err := GetKubeContexts(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	if !Contains(currentContext, contexts) {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", currentContext)
+	}
+	InfoLogger.Printf("Using kubeconfig context: %s", currentContext)
+
+	return getKubeconfigClientConfig(kubeContext).ClientConfig()
+}
+
+// GetKubeContexts returns the names of all contexts in the kubeconfig and the selected context
+func GetKubeContexts(kubeContext string) ([]string, string, error) {
+	rawConfig, err := getKubeconfigClientConfig(kubeContext).RawConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	contexts := make([]string, 0, len(rawConfig.Contexts))
+	for name := range rawConfig.Contexts {
+		contexts = append(contexts, name)
+	}
+
+	currentContext := rawConfig.CurrentContext
+	if kubeContext != "" {
+		currentContext = kubeContext
+	}
+
+	return contexts, currentContext, nil
+}
+
+// getKubeconfigClientConfig returns the client config of the kubeconfig files with the provided context
+func getKubeconfigClientConfig(kubeContext string) clientcmd.ClientConfig {
+	// uses $KUBECONFIG or ~/.kube/config if no kubeconfig is set
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if KUBECONFIG != "" {
+		loadingRules.Precedence = filepath.SplitList(KUBECONFIG)
+	}
+
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: kubeContext,
+	}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+}
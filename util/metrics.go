@@ -0,0 +1,286 @@
+package util
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/common/expfmt"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	k8smetrics "k8s.io/client-go/tools/metrics"
+)
+
+var (
+	// METRICS_TOKEN is the bearer token required to scrape the metrics if set
+	METRICS_TOKEN string
+
+	// MetricsRegistry is the registry of the metrics of the tenant-api
+	MetricsRegistry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tenant_api_http_requests_total",
+		Help: "Count of the HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "code"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tenant_api_http_request_duration_seconds",
+		Help:    "Latency of the HTTP requests by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	kubernetesRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tenant_api_kubernetes_requests_total",
+		Help: "Count of the requests to the Kubernetes API by cluster, method and status code.",
+	}, []string{"cluster", "method", "code"})
+	kubernetesRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tenant_api_kubernetes_request_errors_total",
+		Help: "Count of the requests to the Kubernetes API which failed or returned a status code of 400 or above by cluster and method.",
+	}, []string{"cluster", "method"})
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tenant_api_cache_requests_total",
+		Help: "Count of the reads of the caches by cache and result, a miss is a read of an unsynced informer cache or a load of the OIDC discovery.",
+	}, []string{"cache", "result"})
+
+	// kubernetesHostClusters are the names of the clusters by the host of their Kubernetes API
+	kubernetesHostClusters      = make(map[string]string)
+	kubernetesHostClustersMutex sync.RWMutex
+)
+
+// InitMetrics registers the metrics of the tenant-api and the Kubernetes clients,
+// the costs of the tenants are only registered if the metrics require the METRICS_TOKEN
+func InitMetrics() {
+	MetricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpRequestDuration,
+		kubernetesRequests,
+		kubernetesRequestErrors,
+		cacheRequests,
+	)
+
+	// the public metrics must not expose the costs of all tenants
+	if METRICS_TOKEN != "" {
+		MetricsRegistry.MustRegister(newTenantCostCollector())
+	} else {
+		WarningLogger.Println("METRICS_TOKEN is not set, the tenant costs are not exported in the metrics")
+	}
+
+	k8smetrics.Register(k8smetrics.RegisterOpts{
+		RequestResult: kubernetesResultMetric{},
+	})
+}
+
+// WriteMetrics writes the metrics of the registry in the Prometheus text format
+func WriteMetrics(w io.Writer) error {
+	metricFamilies, err := MetricsRegistry.Gather()
+	if err != nil {
+		return err
+	}
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, metricFamily := range metricFamilies {
+		if err := encoder.Encode(metricFamily); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ObserveHTTPRequest records an HTTP request of the route with the status code and its duration
+func ObserveHTTPRequest(method string, route string, code int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	httpRequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// recordCacheRequest records a read of the cache as hit or miss
+func recordCacheRequest(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+// registerKubernetesHost maps the host of the Kubernetes API of the cluster to its name for the request metrics
+func registerKubernetesHost(host string, cluster string) {
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return
+	}
+
+	kubernetesHostClustersMutex.Lock()
+	defer kubernetesHostClustersMutex.Unlock()
+	kubernetesHostClusters[hostURL.Host] = cluster
+}
+
+// kubernetesResultMetric counts the results of the requests of the Kubernetes clients by cluster
+type kubernetesResultMetric struct{}
+
+// Increment records the result of a request to the Kubernetes API of the host, the code is <error> if the request failed
+func (kubernetesResultMetric) Increment(_ context.Context, code string, method string, host string) {
+	kubernetesHostClustersMutex.RLock()
+	cluster, ok := kubernetesHostClusters[host]
+	kubernetesHostClustersMutex.RUnlock()
+	if !ok {
+		cluster = host
+	}
+
+	kubernetesRequests.WithLabelValues(cluster, method, code).Inc()
+	if status, err := strconv.Atoi(code); err != nil || status >= 400 {
+		kubernetesRequestErrors.WithLabelValues(cluster, method).Inc()
+	}
+}
+
+// tenantCostCollector collects the hourly costs of every tenant in each cluster on each scrape
+type tenantCostCollector struct {
+	cpu     *prometheus.Desc
+	memory  *prometheus.Desc
+	storage *prometheus.Desc
+	ingress *prometheus.Desc
+	total   *prometheus.Desc
+}
+
+// newTenantCostCollector returns a collector of the tenant cost gauges
+func newTenantCostCollector() *tenantCostCollector {
+	costLabels := []string{"tenant", "cluster", "currency"}
+	return &tenantCostCollector{
+		cpu:     prometheus.NewDesc("tenant_cost_cpu", "Hourly cost of the cpu of the tenant in the cluster with the discounts applied.", costLabels, nil),
+		memory:  prometheus.NewDesc("tenant_cost_memory", "Hourly cost of the memory of the tenant in the cluster with the discounts applied.", costLabels, nil),
+		storage: prometheus.NewDesc("tenant_cost_storage", "Hourly cost of the storage of a storage class of the tenant in the cluster with the discounts applied.", append(costLabels, "storage_class"), nil),
+		ingress: prometheus.NewDesc("tenant_cost_ingress", "Hourly cost of the ingresses of the tenant in the cluster with the discounts applied.", costLabels, nil),
+		total:   prometheus.NewDesc("tenant_cost_total", "Hourly cost of all resources of the tenant in the cluster with the discounts applied.", costLabels, nil),
+	}
+}
+
+// Describe sends the descriptions of the tenant cost gauges
+func (collector *tenantCostCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.cpu
+	ch <- collector.memory
+	ch <- collector.storage
+	ch <- collector.ingress
+	ch <- collector.total
+}
+
+// Collect sends the current costs of every tenant in each cluster in the currency of the tenant
+func (collector *tenantCostCollector) Collect(ch chan<- prometheus.Metric) {
+	tenants, err := GetTenantsInCluster()
+	if err != nil {
+		ErrorLogger.Printf("cannot collect the tenant costs: %s", err)
+		ch <- prometheus.NewInvalidMetric(collector.total, err)
+		return
+	}
+	clusterTenantSummaries, err := GetCostSummaryByClusterByTenant(tenants)
+	if err != nil {
+		ErrorLogger.Printf("cannot collect the tenant costs: %s", err)
+		ch <- prometheus.NewInvalidMetric(collector.total, err)
+		return
+	}
+
+	for cluster, tenantSummaries := range clusterTenantSummaries {
+		for tenant, summary := range tenantSummaries {
+			ch <- prometheus.MustNewConstMetric(collector.cpu, prometheus.GaugeValue, summary.CPU.Total, tenant, cluster, summary.Currency)
+			ch <- prometheus.MustNewConstMetric(collector.memory, prometheus.GaugeValue, summary.Memory.Total, tenant, cluster, summary.Currency)
+			ch <- prometheus.MustNewConstMetric(collector.ingress, prometheus.GaugeValue, summary.Ingress.Total, tenant, cluster, summary.Currency)
+			ch <- prometheus.MustNewConstMetric(collector.total, prometheus.GaugeValue, summary.Total, tenant, cluster, summary.Currency)
+			for storageClass, storageCost := range summary.Storage.StorageClasses {
+				ch <- prometheus.MustNewConstMetric(collector.storage, prometheus.GaugeValue, storageCost.Total, tenant, cluster, summary.Currency, storageClass)
+			}
+		}
+	}
+}
+
+// cachedPodLister counts the reads of the pod informer cache of the cluster
+type cachedPodLister struct {
+	corelisters.PodLister
+	cluster *Cluster
+}
+
+// Pods returns a lister of the pods of the namespace
+func (lister cachedPodLister) Pods(namespace string) corelisters.PodNamespaceLister {
+	recordCacheRequest("pods", lister.cluster.IsCacheSynced())
+	return lister.PodLister.Pods(namespace)
+}
+
+// cachedPVCLister counts the reads of the pvc informer cache of the cluster
+type cachedPVCLister struct {
+	corelisters.PersistentVolumeClaimLister
+	cluster *Cluster
+}
+
+// PersistentVolumeClaims returns a lister of the pvcs of the namespace
+func (lister cachedPVCLister) PersistentVolumeClaims(namespace string) corelisters.PersistentVolumeClaimNamespaceLister {
+	recordCacheRequest("persistentvolumeclaims", lister.cluster.IsCacheSynced())
+	return lister.PersistentVolumeClaimLister.PersistentVolumeClaims(namespace)
+}
+
+// cachedIngressLister counts the reads of the ingress informer cache of the cluster
+type cachedIngressLister struct {
+	networkinglisters.IngressLister
+	cluster *Cluster
+}
+
+// Ingresses returns a lister of the ingresses of the namespace
+func (lister cachedIngressLister) Ingresses(namespace string) networkinglisters.IngressNamespaceLister {
+	recordCacheRequest("ingresses", lister.cluster.IsCacheSynced())
+	return lister.IngressLister.Ingresses(namespace)
+}
+
+// cachedResourceQuotaLister counts the reads of the resource quota informer cache of the cluster
+type cachedResourceQuotaLister struct {
+	corelisters.ResourceQuotaLister
+	cluster *Cluster
+}
+
+// ResourceQuotas returns a lister of the resource quotas of the namespace
+func (lister cachedResourceQuotaLister) ResourceQuotas(namespace string) corelisters.ResourceQuotaNamespaceLister {
+	recordCacheRequest("resourcequotas", lister.cluster.IsCacheSynced())
+	return lister.ResourceQuotaLister.ResourceQuotas(namespace)
+}
+
+// cachedNamespaceLister counts the reads of the namespace informer cache of the cluster
+type cachedNamespaceLister struct {
+	corelisters.NamespaceLister
+	cluster *Cluster
+}
+
+// List returns the namespaces matching the selector
+func (lister cachedNamespaceLister) List(selector labels.Selector) ([]*v1.Namespace, error) {
+	recordCacheRequest("namespaces", lister.cluster.IsCacheSynced())
+	return lister.NamespaceLister.List(selector)
+}
+
+// Get returns the namespace with the name
+func (lister cachedNamespaceLister) Get(name string) (*v1.Namespace, error) {
+	recordCacheRequest("namespaces", lister.cluster.IsCacheSynced())
+	return lister.NamespaceLister.Get(name)
+}
+
+// cachedStorageClassLister counts the reads of the storage class informer cache of the cluster
+type cachedStorageClassLister struct {
+	storagelisters.StorageClassLister
+	cluster *Cluster
+}
+
+// List returns the storage classes matching the selector
+func (lister cachedStorageClassLister) List(selector labels.Selector) ([]*storagev1.StorageClass, error) {
+	recordCacheRequest("storageclasses", lister.cluster.IsCacheSynced())
+	return lister.StorageClassLister.List(selector)
+}
+
+// Get returns the storage class with the name
+func (lister cachedStorageClassLister) Get(name string) (*storagev1.StorageClass, error) {
+	recordCacheRequest("storageclasses", lister.cluster.IsCacheSynced())
+	return lister.StorageClassLister.Get(name)
+}
@@ -0,0 +1,292 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+)
+
+var (
+	OIDC_NAME          string
+	OIDC_ISSUER_URL    string
+	OIDC_CLIENT_ID     string
+	OIDC_CLIENT_SECRET string
+	OIDC_SCOPES        []string
+	OIDC_GROUPS_CLAIM  string
+)
+
+// OIDCProvider authenticates users with an OpenID Connect provider like Keycloak or Azure AD,
+// the groups are the values of the groups claim of the id token or of the userinfo
+type OIDCProvider struct {
+	name         string
+	issuerURL    string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	groupsClaim  string
+
+	// discovery is loaded on the first use and cached afterwards
+	discovery      *oidcDiscovery
+	discoveryMutex sync.Mutex
+}
+
+// oidcDiscovery is the provider metadata of the /.well-known/openid-configuration
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// NewOIDCProvider returns an OIDC provider of the issuer
+func NewOIDCProvider(name string, issuerURL string, clientID string, clientSecret string, scopes []string, groupsClaim string) *OIDCProvider {
+	return &OIDCProvider{
+		name:         name,
+		issuerURL:    strings.TrimSuffix(issuerURL, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		groupsClaim:  groupsClaim,
+	}
+}
+
+// Name returns the name of the provider
+func (provider *OIDCProvider) Name() string {
+	return provider.name
+}
+
+// AuthCodeURL returns the authorization endpoint url of the issuer with the state and, if provided, the S256 PKCE code challenge
+// or an empty string if the discovery failed
+func (provider *OIDCProvider) AuthCodeURL(redirectURL string, state string, codeChallenge string) string {
+	config, err := provider.oauth2Config(context.Background(), redirectURL)
+	if err != nil {
+		ErrorLogger.Printf("%s", err)
+		return ""
+	}
+
+	if codeChallenge == "" {
+		return config.AuthCodeURL(state)
+	}
+	return config.AuthCodeURL(state, oauth2.SetAuthURLParam("code_challenge", codeChallenge), oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+}
+
+// Authenticate exchanges the code for an id token and returns the user with the groups of the groups claim
+func (provider *OIDCProvider) Authenticate(ctx context.Context, code string, redirectURL string, codeVerifier string) (Identity, error) {
+	config, err := provider.oauth2Config(ctx, redirectURL)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	options := make([]oauth2.AuthCodeOption, 0, 1)
+	if codeVerifier != "" {
+		options = append(options, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
+	}
+
+	token, err := config.Exchange(ctx, code, options...)
+	if err != nil {
+		return Identity{}, fmt.Errorf("oidc code exchange failed: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return Identity{}, errors.New("oidc token response has no id_token")
+	}
+
+	return provider.identity(ctx, config, token)
+}
+
+// Refresh refreshes the token with its refresh token and returns the user with the current groups,
+// the scopes of some providers must include offline_access to get a refresh token
+func (provider *OIDCProvider) Refresh(ctx context.Context, token *oauth2.Token) (Identity, error) {
+	if token == nil || token.RefreshToken == "" {
+		return Identity{}, errors.New("oidc refresh token is missing")
+	}
+
+	config, err := provider.oauth2Config(ctx, "")
+	if err != nil {
+		return Identity{}, err
+	}
+
+	// a token without access token is refreshed by the token source
+	refreshedToken, err := config.TokenSource(ctx, &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	if err != nil {
+		return Identity{}, fmt.Errorf("oidc token refresh failed: %w", err)
+	}
+
+	return provider.identity(ctx, config, refreshedToken)
+}
+
+// identity returns the user of the claims of the id token or, if the token has no id token, of the userinfo
+func (provider *OIDCProvider) identity(ctx context.Context, config *oauth2.Config, token *oauth2.Token) (Identity, error) {
+	claims := jwt.MapClaims{}
+	if rawIDToken, ok := token.Extra("id_token").(string); ok && rawIDToken != "" {
+		var err error
+		if claims, err = provider.parseIDToken(rawIDToken); err != nil {
+			return Identity{}, err
+		}
+	}
+
+	// some providers only return the groups in the userinfo
+	if _, ok := claims[provider.groupsClaim]; !ok && provider.discovery.UserinfoEndpoint != "" {
+		userinfo, err := provider.getUserinfo(ctx, config.TokenSource(ctx, token))
+		if err != nil {
+			return Identity{}, err
+		}
+		if _, ok := claims["sub"]; !ok {
+			for claim, value := range userinfo {
+				claims[claim] = value
+			}
+		}
+		claims[provider.groupsClaim] = userinfo[provider.groupsClaim]
+	}
+
+	subject, _ := claims["sub"].(string)
+	for _, claim := range []string{"preferred_username", "email"} {
+		if value, ok := claims[claim].(string); ok && value != "" {
+			subject = value
+			break
+		}
+	}
+	if subject == "" {
+		return Identity{}, errors.New("oidc user has no subject")
+	}
+
+	return Identity{
+		Provider: provider.Name(),
+		Subject:  subject,
+		Groups:   getStringsClaim(claims[provider.groupsClaim]),
+		Token:    token,
+	}, nil
+}
+
+// parseIDToken validates the issuer, the audience and the expiration of the id token and returns its claims,
+// the signature is not verified as the token is received directly from the token endpoint over TLS (OpenID Connect Core 3.1.3.7)
+func (provider *OIDCProvider) parseIDToken(rawIDToken string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(rawIDToken, claims); err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	if err := claims.Valid(); err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != provider.issuerURL {
+		return nil, errors.New("invalid id_token: issuer does not match")
+	}
+	if !containsAudience(claims["aud"], provider.clientID) {
+		return nil, errors.New("invalid id_token: audience does not match")
+	}
+
+	return claims, nil
+}
+
+// getUserinfo returns the claims of the userinfo endpoint
+func (provider *OIDCProvider) getUserinfo(ctx context.Context, tokenSource oauth2.TokenSource) (map[string]interface{}, error) {
+	resp, err := oauth2.NewClient(ctx, tokenSource).Get(provider.discovery.UserinfoEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("oidc userinfo request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc userinfo request failed with status %d", resp.StatusCode)
+	}
+
+	userinfo := make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&userinfo); err != nil {
+		return nil, fmt.Errorf("invalid oidc userinfo: %w", err)
+	}
+	return userinfo, nil
+}
+
+// oauth2Config returns the oauth2 config with the endpoints of the discovery
+func (provider *OIDCProvider) oauth2Config(ctx context.Context, redirectURL string) (*oauth2.Config, error) {
+	discovery, err := provider.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Config{
+		ClientID:     provider.clientID,
+		ClientSecret: provider.clientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  discovery.AuthorizationEndpoint,
+			TokenURL: discovery.TokenEndpoint,
+		},
+		RedirectURL: redirectURL,
+		Scopes:      provider.scopes,
+	}, nil
+}
+
+// getDiscovery returns the cached provider metadata or loads it from the issuer
+func (provider *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	provider.discoveryMutex.Lock()
+	defer provider.discoveryMutex.Unlock()
+
+	recordCacheRequest("oidc_discovery", provider.discovery != nil)
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", provider.issuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery failed with status %d", resp.StatusCode)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("invalid oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != provider.issuerURL {
+		return nil, fmt.Errorf("oidc discovery issuer %s does not match %s", discovery.Issuer, provider.issuerURL)
+	}
+
+	provider.discovery = &discovery
+	return provider.discovery, nil
+}
+
+// containsAudience returns true if the audience claim is or contains the client id
+func containsAudience(audience interface{}, clientID string) bool {
+	for _, value := range getStringsClaim(audience) {
+		if value == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// getStringsClaim returns the claim as list of strings, a single string is a list with one element
+func getStringsClaim(claim interface{}) []string {
+	values := make([]string, 0)
+	switch claim := claim.(type) {
+	case string:
+		values = append(values, claim)
+	case []interface{}:
+		for _, value := range claim {
+			if value, ok := value.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
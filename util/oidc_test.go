@@ -0,0 +1,256 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+)
+
+// mockIdP is an OpenID Connect provider which issues the id tokens of a single user
+type mockIdP struct {
+	server *httptest.Server
+	// issuer of the discovery and of the id tokens, the url of the server if empty
+	issuer string
+	// groups of the id token or, if groupsInUserinfo is set, of the userinfo
+	groups           []string
+	groupsInUserinfo bool
+	// code which is exchanged for a token with the code verifier
+	code         string
+	codeVerifier string
+	// refresh token which is exchanged for a new token, revoked refresh tokens are rejected
+	refreshToken string
+
+	mutex              sync.Mutex
+	discoveryRequests  int
+	lastTokenGrantType string
+}
+
+// newMockIdP starts a mock OpenID Connect provider
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	InitLoggers()
+
+	idp := &mockIdP{
+		groups:       []string{"acme", "globex"},
+		code:         "valid-code",
+		codeVerifier: "verifier",
+		refreshToken: "valid-refresh",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/userinfo", idp.userinfo)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// provider returns an OIDC provider of the mock IdP with the groups claim
+func (idp *mockIdP) provider() *OIDCProvider {
+	return NewOIDCProvider("oidc", idp.server.URL, "tenant-api", "secret", []string{"openid"}, "groups")
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	idp.mutex.Lock()
+	idp.discoveryRequests++
+	idp.mutex.Unlock()
+
+	issuer := idp.issuer
+	if issuer == "" {
+		issuer = idp.server.URL
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": idp.server.URL + "/authorize",
+		"token_endpoint":         idp.server.URL + "/token",
+		"userinfo_endpoint":      idp.server.URL + "/userinfo",
+	})
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	idp.mutex.Lock()
+	idp.lastTokenGrantType = r.Form.Get("grant_type")
+	idp.mutex.Unlock()
+
+	switch r.Form.Get("grant_type") {
+	case "authorization_code":
+		if r.Form.Get("code") != idp.code || r.Form.Get("code_verifier") != idp.codeVerifier {
+			idp.tokenError(w, "invalid_grant")
+			return
+		}
+	case "refresh_token":
+		if r.Form.Get("refresh_token") != idp.refreshToken {
+			idp.tokenError(w, "invalid_grant")
+			return
+		}
+	default:
+		idp.tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  "access-" + r.Form.Get("grant_type"),
+		"token_type":    "Bearer",
+		"expires_in":    300,
+		"refresh_token": idp.refreshToken,
+		"id_token":      idp.idToken(),
+	})
+}
+
+func (idp *mockIdP) tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func (idp *mockIdP) userinfo(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer access-") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sub":    "1234",
+		"groups": idp.groups,
+	})
+}
+
+// idToken returns an id token of the user for the tenant-api client
+func (idp *mockIdP) idToken() string {
+	claims := jwt.MapClaims{
+		"iss":                idp.server.URL,
+		"aud":                []string{"tenant-api"},
+		"sub":                "1234",
+		"preferred_username": "jane",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+	if !idp.groupsInUserinfo {
+		claims["groups"] = idp.groups
+	}
+	idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("idp-key"))
+	return idToken
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := idp.provider()
+
+	authCodeURL, err := url.Parse(provider.AuthCodeURL("http://localhost/callback", "state", "challenge"))
+	if err != nil {
+		t.Fatalf("invalid auth code url: %s", err)
+	}
+	if authCodeURL.Path != "/authorize" || authCodeURL.Query().Get("state") != "state" ||
+		authCodeURL.Query().Get("code_challenge") != "challenge" || authCodeURL.Query().Get("code_challenge_method") != "S256" {
+		t.Errorf("unexpected auth code url %s", authCodeURL)
+	}
+
+	// the discovery is cached
+	provider.AuthCodeURL("http://localhost/callback", "state", "")
+	if idp.discoveryRequests != 1 {
+		t.Errorf("expected the discovery to be loaded once, got %d requests", idp.discoveryRequests)
+	}
+
+	// the issuer of the discovery must match the issuer url
+	idp.issuer = "https://other.example.com"
+	if _, err := idp.provider().getDiscovery(context.Background()); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected an issuer mismatch, got %v", err)
+	}
+	if url := idp.provider().AuthCodeURL("http://localhost/callback", "state", ""); url != "" {
+		t.Errorf("expected no auth code url without discovery, got %s", url)
+	}
+
+	notFound := NewOIDCProvider("oidc", idp.server.URL+"/missing", "tenant-api", "secret", nil, "groups")
+	if _, err := notFound.getDiscovery(context.Background()); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("expected a failed discovery, got %v", err)
+	}
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	idp := newMockIdP(t)
+
+	identity, err := idp.provider().Authenticate(context.Background(), "valid-code", "http://localhost/callback", "verifier")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if identity.Provider != "oidc" || identity.Subject != "jane" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if !reflect.DeepEqual(identity.Groups, []string{"acme", "globex"}) {
+		t.Errorf("expected the groups of the groups claim, got %v", identity.Groups)
+	}
+	if identity.Token == nil || identity.Token.RefreshToken != "valid-refresh" {
+		t.Errorf("expected the token with the refresh token, got %+v", identity.Token)
+	}
+
+	// the code verifier of the PKCE challenge is sent with the code
+	if _, err := idp.provider().Authenticate(context.Background(), "valid-code", "http://localhost/callback", "other"); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("expected a rejected code verifier, got %v", err)
+	}
+	if _, err := idp.provider().Authenticate(context.Background(), "invalid-code", "http://localhost/callback", "verifier"); err == nil || !strings.Contains(err.Error(), "code exchange failed") {
+		t.Errorf("expected a failed code exchange, got %v", err)
+	}
+
+	// the id token must be issued for the client
+	other := NewOIDCProvider("oidc", idp.server.URL, "other-client", "secret", nil, "groups")
+	if _, err := other.Authenticate(context.Background(), "valid-code", "http://localhost/callback", "verifier"); err == nil || !strings.Contains(err.Error(), "audience") {
+		t.Errorf("expected an audience mismatch, got %v", err)
+	}
+}
+
+func TestOIDCGroupsOfUserinfo(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.groupsInUserinfo = true
+	idp.groups = []string{"initech"}
+
+	identity, err := idp.provider().Authenticate(context.Background(), "valid-code", "http://localhost/callback", "verifier")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if identity.Subject != "jane" || !reflect.DeepEqual(identity.Groups, []string{"initech"}) {
+		t.Errorf("expected the groups of the userinfo, got %+v", identity)
+	}
+}
+
+func TestOIDCRefresh(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := idp.provider()
+
+	identity, err := provider.Authenticate(context.Background(), "valid-code", "http://localhost/callback", "verifier")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the refreshed identity has the current groups
+	idp.groups = []string{"acme"}
+	refreshed, err := provider.Refresh(context.Background(), identity.Token)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if idp.lastTokenGrantType != "refresh_token" {
+		t.Errorf("expected a refresh token grant, got %s", idp.lastTokenGrantType)
+	}
+	if !reflect.DeepEqual(refreshed.Groups, []string{"acme"}) {
+		t.Errorf("expected the current groups, got %v", refreshed.Groups)
+	}
+
+	if _, err := provider.Refresh(context.Background(), &oauth2.Token{AccessToken: "access"}); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected a missing refresh token, got %v", err)
+	}
+
+	// a revoked refresh token fails
+	idp.refreshToken = "rotated"
+	if _, err := provider.Refresh(context.Background(), identity.Token); err == nil || !strings.Contains(err.Error(), "refresh failed") {
+		t.Errorf("expected a failed refresh, got %v", err)
+	}
+}
@@ -1,10 +1,13 @@
 package util
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -14,18 +17,122 @@ var (
 
 // LoadEnv loads OS environment variables
 func LoadEnv() error {
+	// GitHub login is enabled with CLIENT_ID
 	if CLIENT_ID = os.Getenv("CLIENT_ID"); CLIENT_ID == "" {
-		err = errors.New("CLIENT_ID is not set")
-		ErrorLogger.Println(err)
-		Status = "Error: CLIENT_ID is not set"
-		return err
-	}
-
-	if CLIENT_SECRET = os.Getenv("CLIENT_SECRET"); CLIENT_SECRET == "" {
+		WarningLogger.Println("CLIENT_ID is not set, GitHub login disabled")
+	} else if CLIENT_SECRET = os.Getenv("CLIENT_SECRET"); CLIENT_SECRET == "" {
 		err = errors.New("CLIENT_SECRET is not set")
 		ErrorLogger.Println(err)
 		Status = "Error: CLIENT_SECRET is not set"
 		return err
+	} else if GITHUB_ORG = os.Getenv("GITHUB_ORG"); GITHUB_ORG == "" {
+		// the organization was fixed before it was configurable
+		GITHUB_ORG = "natron-io"
+		WarningLogger.Printf("GITHUB_ORG set using default: %s, set it to the organization of the tenant teams", GITHUB_ORG)
+	} else {
+		InfoLogger.Printf("GITHUB_ORG set using env: %s", GITHUB_ORG)
+	}
+
+	if tenantMapping := os.Getenv("TENANT_MAPPING"); tenantMapping != "" {
+		if err = json.Unmarshal([]byte(tenantMapping), &TENANT_MAPPING); err != nil {
+			err = errors.New("TENANT_MAPPING is not valid json")
+			ErrorLogger.Println(err)
+			Status = "Error: " + err.Error()
+			return err
+		}
+		InfoLogger.Printf("TENANT_MAPPING set using env: %s", tenantMapping)
+	}
+
+	if ADMIN_GROUP = os.Getenv("ADMIN_GROUP"); ADMIN_GROUP == "" {
+		InfoLogger.Println("ADMIN_GROUP is not set, platform admins disabled")
+	} else {
+		InfoLogger.Printf("ADMIN_GROUP set using env: %s", ADMIN_GROUP)
+	}
+
+	if roleMapping := os.Getenv("ROLE_MAPPING"); roleMapping != "" {
+		if err = json.Unmarshal([]byte(roleMapping), &ROLE_MAPPING); err != nil {
+			err = errors.New("ROLE_MAPPING is not valid json")
+			ErrorLogger.Println(err)
+			Status = "Error: " + err.Error()
+			return err
+		}
+		for group, roles := range ROLE_MAPPING {
+			for _, role := range roles {
+				if !IsRole(role) {
+					err = fmt.Errorf("ROLE_MAPPING of %s has unknown role %s", group, role)
+					ErrorLogger.Println(err)
+					Status = "Error: " + err.Error()
+					return err
+				}
+			}
+		}
+		InfoLogger.Printf("ROLE_MAPPING set using env: %s", roleMapping)
+	}
+
+	// members of a group without role mapping can read everything of their tenants including the costs
+	DEFAULT_ROLES = nil
+	if defaultRoles := os.Getenv("DEFAULT_ROLES"); defaultRoles == "" {
+		DEFAULT_ROLES = []Role{RoleViewer, RoleBilling}
+		InfoLogger.Printf("DEFAULT_ROLES set using default: %s", RoleViewer+","+RoleBilling)
+	} else {
+		for _, role := range strings.Split(defaultRoles, ",") {
+			if role = strings.TrimSpace(role); !IsRole(Role(role)) {
+				err = fmt.Errorf("DEFAULT_ROLES has unknown role %s", role)
+				ErrorLogger.Println(err)
+				Status = "Error: " + err.Error()
+				return err
+			}
+			DEFAULT_ROLES = append(DEFAULT_ROLES, Role(role))
+		}
+		InfoLogger.Printf("DEFAULT_ROLES set using env: %s", defaultRoles)
+	}
+
+	// OIDC login is enabled with OIDC_ISSUER_URL
+	if OIDC_ISSUER_URL = os.Getenv("OIDC_ISSUER_URL"); OIDC_ISSUER_URL == "" {
+		InfoLogger.Println("OIDC_ISSUER_URL is not set, OIDC login disabled")
+	} else {
+		InfoLogger.Printf("OIDC_ISSUER_URL set using env: %s", OIDC_ISSUER_URL)
+
+		if OIDC_CLIENT_ID = os.Getenv("OIDC_CLIENT_ID"); OIDC_CLIENT_ID == "" {
+			err = errors.New("OIDC_CLIENT_ID is not set")
+			ErrorLogger.Println(err)
+			Status = "Error: OIDC_CLIENT_ID is not set"
+			return err
+		}
+
+		if OIDC_CLIENT_SECRET = os.Getenv("OIDC_CLIENT_SECRET"); OIDC_CLIENT_SECRET == "" {
+			err = errors.New("OIDC_CLIENT_SECRET is not set")
+			ErrorLogger.Println(err)
+			Status = "Error: OIDC_CLIENT_SECRET is not set"
+			return err
+		}
+
+		if OIDC_NAME = os.Getenv("OIDC_NAME"); OIDC_NAME == "" {
+			OIDC_NAME = "oidc"
+			InfoLogger.Printf("OIDC_NAME set using default: %s", OIDC_NAME)
+		} else if OIDC_NAME == "github" {
+			err = errors.New("OIDC_NAME must not be github")
+			ErrorLogger.Println(err)
+			Status = "Error: " + err.Error()
+			return err
+		} else {
+			InfoLogger.Printf("OIDC_NAME set using env: %s", OIDC_NAME)
+		}
+
+		if oidcScopes := os.Getenv("OIDC_SCOPES"); oidcScopes == "" {
+			OIDC_SCOPES = []string{"openid", "profile", "email"}
+			InfoLogger.Printf("OIDC_SCOPES set using default: %s", strings.Join(OIDC_SCOPES, " "))
+		} else {
+			OIDC_SCOPES = strings.Fields(strings.ReplaceAll(oidcScopes, ",", " "))
+			InfoLogger.Printf("OIDC_SCOPES set using env: %s", strings.Join(OIDC_SCOPES, " "))
+		}
+
+		if OIDC_GROUPS_CLAIM = os.Getenv("OIDC_GROUPS_CLAIM"); OIDC_GROUPS_CLAIM == "" {
+			OIDC_GROUPS_CLAIM = "groups"
+			InfoLogger.Printf("OIDC_GROUPS_CLAIM set using default: %s", OIDC_GROUPS_CLAIM)
+		} else {
+			InfoLogger.Printf("OIDC_GROUPS_CLAIM set using env: %s", OIDC_GROUPS_CLAIM)
+		}
 	}
 
 	if CALLBACK_URL = os.Getenv("CALLBACK_URL"); CALLBACK_URL == "" {
@@ -52,11 +159,113 @@ func LoadEnv() error {
 		InfoLogger.Printf("MAX_REQUESTS set using env: %d", MAX_REQUESTS)
 	}
 
-	if SECRET_KEY = os.Getenv("SECRET_KEY"); SECRET_KEY == "" {
-		WarningLogger.Println("SECRET_KEY is not set")
-		// setting random key
-		SECRET_KEY = RandomStringBytes(32)
-		InfoLogger.Printf("SECRET_KEY is not set, using random key: %s", SECRET_KEY)
+	// the metrics are public without METRICS_TOKEN and do not contain the tenant costs
+	if METRICS_TOKEN = os.Getenv("METRICS_TOKEN"); METRICS_TOKEN == "" {
+		InfoLogger.Println("METRICS_TOKEN is not set")
+	} else {
+		InfoLogger.Println("METRICS_TOKEN set using env")
+	}
+
+	// the tokens are signed with the keys of SIGNING_KEYS_DIR, the SECRET_KEY or a generated key in this order
+	if SIGNING_KEYS_DIR = os.Getenv("SIGNING_KEYS_DIR"); SIGNING_KEYS_DIR == "" {
+		InfoLogger.Println("SIGNING_KEYS_DIR is not set")
+	} else {
+		InfoLogger.Printf("SIGNING_KEYS_DIR set using env: %s", SIGNING_KEYS_DIR)
+	}
+
+	if SIGNING_KEY_ID = os.Getenv("SIGNING_KEY_ID"); SIGNING_KEY_ID != "" {
+		InfoLogger.Printf("SIGNING_KEY_ID set using env: %s", SIGNING_KEY_ID)
+	}
+
+	if SECRET_KEY = os.Getenv("SECRET_KEY"); SECRET_KEY != "" {
+		InfoLogger.Println("SECRET_KEY set using env")
+	}
+
+	if ACCESS_TOKEN_TTL, err = time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); ACCESS_TOKEN_TTL <= 0 || err != nil {
+		WarningLogger.Println("ACCESS_TOKEN_TTL is not set or invalid duration value")
+		ACCESS_TOKEN_TTL = 15 * time.Minute
+		InfoLogger.Printf("ACCESS_TOKEN_TTL set using default: %s", ACCESS_TOKEN_TTL)
+	} else {
+		InfoLogger.Printf("ACCESS_TOKEN_TTL set using env: %s", ACCESS_TOKEN_TTL)
+	}
+
+	if REFRESH_TOKEN_TTL, err = time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); REFRESH_TOKEN_TTL <= 0 || err != nil {
+		WarningLogger.Println("REFRESH_TOKEN_TTL is not set or invalid duration value")
+		REFRESH_TOKEN_TTL = 24 * time.Hour
+		InfoLogger.Printf("REFRESH_TOKEN_TTL set using default: %s", REFRESH_TOKEN_TTL)
+	} else {
+		InfoLogger.Printf("REFRESH_TOKEN_TTL set using env: %s", REFRESH_TOKEN_TTL)
+	}
+
+	if CLUSTERS = os.Getenv("CLUSTERS"); CLUSTERS == "" {
+		InfoLogger.Println("CLUSTERS is not set, using a single cluster")
+	} else {
+		InfoLogger.Printf("CLUSTERS set using env: %s", CLUSTERS)
+	}
+
+	if CLUSTER_NAME = os.Getenv("CLUSTER_NAME"); CLUSTER_NAME == "" {
+		CLUSTER_NAME = "default"
+		InfoLogger.Printf("CLUSTER_NAME set using default: %s", CLUSTER_NAME)
+	} else {
+		InfoLogger.Printf("CLUSTER_NAME set using env: %s", CLUSTER_NAME)
+	}
+
+	if clusterCosts := os.Getenv("CLUSTER_COSTS"); clusterCosts != "" {
+		if err = json.Unmarshal([]byte(clusterCosts), &CLUSTER_COSTS); err != nil {
+			err = errors.New("CLUSTER_COSTS is not valid json")
+			ErrorLogger.Println(err)
+			Status = "Error: " + err.Error()
+			return err
+		}
+		InfoLogger.Printf("CLUSTER_COSTS set using env: %s", clusterCosts)
+	}
+
+	if CACHE_RESYNC_PERIOD, err = time.ParseDuration(os.Getenv("CACHE_RESYNC_PERIOD")); CACHE_RESYNC_PERIOD <= 0 || err != nil {
+		WarningLogger.Println("CACHE_RESYNC_PERIOD is not set or invalid duration value")
+		CACHE_RESYNC_PERIOD = 10 * time.Minute
+		InfoLogger.Printf("CACHE_RESYNC_PERIOD set using default: %s", CACHE_RESYNC_PERIOD)
+	} else {
+		InfoLogger.Printf("CACHE_RESYNC_PERIOD set using env: %s", CACHE_RESYNC_PERIOD)
+	}
+
+	if CACHE_SYNC_TIMEOUT, err = time.ParseDuration(os.Getenv("CACHE_SYNC_TIMEOUT")); CACHE_SYNC_TIMEOUT <= 0 || err != nil {
+		WarningLogger.Println("CACHE_SYNC_TIMEOUT is not set or invalid duration value")
+		CACHE_SYNC_TIMEOUT = 2 * time.Minute
+		InfoLogger.Printf("CACHE_SYNC_TIMEOUT set using default: %s", CACHE_SYNC_TIMEOUT)
+	} else {
+		InfoLogger.Printf("CACHE_SYNC_TIMEOUT set using env: %s", CACHE_SYNC_TIMEOUT)
+	}
+
+	if TENANT_LABEL = os.Getenv("TENANT_LABEL"); TENANT_LABEL == "" {
+		WarningLogger.Println("TENANT_LABEL is not set")
+		TENANT_LABEL = "natron.io/tenant"
+		InfoLogger.Printf("TENANT_LABEL set using default: %s", TENANT_LABEL)
+	} else {
+		InfoLogger.Printf("TENANT_LABEL set using env: %s", TENANT_LABEL)
+	}
+
+	if DATA_PATH = os.Getenv("DATA_PATH"); DATA_PATH == "" {
+		WarningLogger.Println("DATA_PATH is not set")
+		DATA_PATH = "./data"
+		InfoLogger.Printf("DATA_PATH set using default: %s", DATA_PATH)
+	} else {
+		InfoLogger.Printf("DATA_PATH set using env: %s", DATA_PATH)
+	}
+
+	if HISTORY_INTERVAL, err = time.ParseDuration(os.Getenv("HISTORY_INTERVAL")); HISTORY_INTERVAL <= 0 || err != nil {
+		WarningLogger.Println("HISTORY_INTERVAL is not set or invalid duration value")
+		HISTORY_INTERVAL = 5 * time.Minute
+		InfoLogger.Printf("HISTORY_INTERVAL set using default: %s", HISTORY_INTERVAL)
+	} else {
+		InfoLogger.Printf("HISTORY_INTERVAL set using env: %s", HISTORY_INTERVAL)
+	}
+
+	if HISTORY_RETENTION, err = time.ParseDuration(os.Getenv("HISTORY_RETENTION")); HISTORY_RETENTION <= 0 || err != nil {
+		WarningLogger.Println("HISTORY_RETENTION is not set or invalid duration value")
+		HISTORY_RETENTION = 2 * 365 * 24 * time.Hour
+		InfoLogger.Printf("HISTORY_RETENTION set using default: %s", HISTORY_RETENTION)
+	} else {
+		InfoLogger.Printf("HISTORY_RETENTION set using env: %s", HISTORY_RETENTION)
 	}
 
 	if DISCOUNT_LABEL = os.Getenv("DISCOUNT_LABEL"); DISCOUNT_LABEL == "" {
@@ -67,6 +276,40 @@ func LoadEnv() error {
 		InfoLogger.Printf("DISCOUNT_LABEL set using env: %s", DISCOUNT_LABEL)
 	}
 
+	if PRICING_FILE = os.Getenv("PRICING_FILE"); PRICING_FILE == "" {
+		InfoLogger.Println("PRICING_FILE is not set, using the cost env variables")
+	} else {
+		InfoLogger.Printf("PRICING_FILE set using env: %s", PRICING_FILE)
+	}
+
+	if PRICING_RELOAD_INTERVAL, err = time.ParseDuration(os.Getenv("PRICING_RELOAD_INTERVAL")); PRICING_RELOAD_INTERVAL <= 0 || err != nil {
+		WarningLogger.Println("PRICING_RELOAD_INTERVAL is not set or invalid duration value")
+		PRICING_RELOAD_INTERVAL = 30 * time.Second
+		InfoLogger.Printf("PRICING_RELOAD_INTERVAL set using default: %s", PRICING_RELOAD_INTERVAL)
+	} else {
+		InfoLogger.Printf("PRICING_RELOAD_INTERVAL set using env: %s", PRICING_RELOAD_INTERVAL)
+	}
+
+	if CURRENCY = os.Getenv("CURRENCY"); CURRENCY == "" {
+		WarningLogger.Println("CURRENCY is not set")
+		CURRENCY = "CHF"
+		InfoLogger.Printf("CURRENCY set using default: %s", CURRENCY)
+	} else {
+		InfoLogger.Printf("CURRENCY set using env: %s", CURRENCY)
+	}
+
+	if TENANT_TEMPLATES_FILE = os.Getenv("TENANT_TEMPLATES_FILE"); TENANT_TEMPLATES_FILE == "" {
+		InfoLogger.Println("TENANT_TEMPLATES_FILE is not set, using the default tenant template")
+	} else {
+		InfoLogger.Printf("TENANT_TEMPLATES_FILE set using env: %s", TENANT_TEMPLATES_FILE)
+	}
+
+	if RATES_FILE = os.Getenv("RATES_FILE"); RATES_FILE == "" {
+		InfoLogger.Println("RATES_FILE is not set, no currency conversion")
+	} else {
+		InfoLogger.Printf("RATES_FILE set using env: %s", RATES_FILE)
+	}
+
 	if CPU_COST, err = strconv.ParseFloat(os.Getenv("CPU_COST"), 64); CPU_COST == 0 || err != nil {
 		WarningLogger.Println("CPU_COST is not set or invalid float value")
 		CPU_COST = 1.00
@@ -107,6 +350,51 @@ func LoadEnv() error {
 		InfoLogger.Printf("EXCLUDE_INGRESS_VCLUSTER set using env: %t", EXCLUDE_INGRESS_VCLUSTER)
 	}
 
+	if EXCLUDE_TERMINATED_PODS, err = strconv.ParseBool(os.Getenv("EXCLUDE_TERMINATED_PODS")); !EXCLUDE_TERMINATED_PODS || err != nil {
+		WarningLogger.Println("EXCLUDE_TERMINATED_PODS is not set or invalid bool value")
+		EXCLUDE_TERMINATED_PODS = false
+		InfoLogger.Printf("EXCLUDE_TERMINATED_PODS set using default: %t", EXCLUDE_TERMINATED_PODS)
+	} else {
+		InfoLogger.Printf("EXCLUDE_TERMINATED_PODS set using env: %t", EXCLUDE_TERMINATED_PODS)
+	}
+
+	if BILL_ON_USAGE, err = strconv.ParseBool(os.Getenv("BILL_ON_USAGE")); !BILL_ON_USAGE || err != nil {
+		WarningLogger.Println("BILL_ON_USAGE is not set or invalid bool value")
+		BILL_ON_USAGE = false
+		InfoLogger.Printf("BILL_ON_USAGE set using default: %t", BILL_ON_USAGE)
+	} else {
+		InfoLogger.Printf("BILL_ON_USAGE set using env: %t", BILL_ON_USAGE)
+	}
+
+	switch DATASOURCE = os.Getenv("DATASOURCE"); DATASOURCE {
+	case "":
+		DATASOURCE = DatasourceKubernetes
+		InfoLogger.Printf("DATASOURCE set using default: %s", DATASOURCE)
+	case DatasourceKubernetes, DatasourcePrometheus:
+		InfoLogger.Printf("DATASOURCE set using env: %s", DATASOURCE)
+	default:
+		err = fmt.Errorf("DATASOURCE %s is not valid, must be %s or %s", DATASOURCE, DatasourceKubernetes, DatasourcePrometheus)
+		ErrorLogger.Println(err)
+		Status = "Error: " + err.Error()
+		return err
+	}
+
+	if DATASOURCE == DatasourcePrometheus {
+		if PROMETHEUS_URL = os.Getenv("PROMETHEUS_URL"); PROMETHEUS_URL == "" {
+			err = errors.New("PROMETHEUS_URL is not set")
+			ErrorLogger.Println(err)
+			Status = "Error: PROMETHEUS_URL is not set"
+			return err
+		}
+		InfoLogger.Printf("PROMETHEUS_URL set using env: %s", PROMETHEUS_URL)
+
+		if PROMETHEUS_CLUSTER_LABEL = os.Getenv("PROMETHEUS_CLUSTER_LABEL"); PROMETHEUS_CLUSTER_LABEL == "" {
+			InfoLogger.Println("PROMETHEUS_CLUSTER_LABEL is not set, the metrics are not filtered by cluster")
+		} else {
+			InfoLogger.Printf("PROMETHEUS_CLUSTER_LABEL set using env: %s", PROMETHEUS_CLUSTER_LABEL)
+		}
+	}
+
 	if SLACK_TOKEN = os.Getenv("SLACK_TOKEN"); SLACK_TOKEN == "" {
 		WarningLogger.Println("SLACK_TOKEN is not set")
 		SLACK_TOKEN = ""
@@ -138,43 +426,25 @@ func LoadEnv() error {
 	for _, env := range os.Environ() {
 		if strings.HasPrefix(env, "STORAGE_COST_") {
 			// split env variable to key and value
-			keyValue := strings.Split(env, "=")
-			// split key to storage class name and cost
-			key := strings.Split(keyValue[0], "_")
+			keyValue := strings.SplitN(env, "=", 2)
+			// the storage class name may contain underscores
+			storageClass := strings.TrimPrefix(keyValue[0], "STORAGE_COST_")
 			// parse value to float
 			value, err := strconv.ParseFloat(keyValue[1], 64)
 			if err != nil {
-				err = errors.New("STORAGE_COST_" + key[2] + " is not set or invalid float value")
+				err = errors.New("STORAGE_COST_" + storageClass + " is not set or invalid float value")
 				ErrorLogger.Println(err)
 				Status = "Error: " + err.Error()
 				return err
 			}
 			// add to tempStorageCost
-			tempStorageCost[key[2]] = map[string]float64{"cost": value}
-			InfoLogger.Printf("storage class %s set to cost value: %f", key[2], value)
+			tempStorageCost[storageClass] = map[string]float64{"cost": value}
+			InfoLogger.Printf("storage class %s set to cost value: %f", storageClass, value)
 		}
 	}
 	STORAGE_COST = tempStorageCost
 
-	storageClassesInCluster, err := GetStorageClassesInCluster()
-	if err != nil {
-		err = errors.New("cannot get storage classes in cluster")
-		ErrorLogger.Println(err)
-		Status = "Error: " + err.Error()
-		os.Exit(1)
-	}
-
-	// check if every storage class in cluster is in STORAGE_COST
-	for _, storageClass := range storageClassesInCluster {
-		if _, ok := STORAGE_COST[storageClass]; !ok {
-			err = errors.New("Storage class " + storageClass + " is not set")
-			ErrorLogger.Println(err)
-			Status = "Error: " + err.Error()
-			os.Exit(1)
-		}
-	}
-
-	if STORAGE_COST == nil {
+	if len(STORAGE_COST) == 0 {
 		WarningLogger.Println("STORAGE_COST is not set")
 		STORAGE_COST = map[string]map[string]float64{
 			"default": {"cost": 1.00},
@@ -0,0 +1,54 @@
+package util
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// GetPodRequests returns the effective requests of the pod the way the scheduler calculates them:
+// the sum of all containers, the max of that sum and each init container, plus the pod overhead
+func GetPodRequests(pod *v1.Pod) v1.ResourceList {
+	requests := v1.ResourceList{}
+
+	// app containers run at the same time
+	for _, container := range pod.Spec.Containers {
+		addResourceList(requests, container.Resources.Requests)
+	}
+
+	// init containers run one after another before the app containers
+	for _, container := range pod.Spec.InitContainers {
+		maxResourceList(requests, container.Resources.Requests)
+	}
+
+	// overhead of the runtime class
+	if pod.Spec.Overhead != nil {
+		addResourceList(requests, pod.Spec.Overhead)
+	}
+
+	return requests
+}
+
+// IsPodTerminated returns true if the pod is succeeded or failed and no longer holds its resources
+func IsPodTerminated(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+// addResourceList adds the resources of new to list
+func addResourceList(list, new v1.ResourceList) {
+	for name, quantity := range new {
+		if value, ok := list[name]; ok {
+			value.Add(quantity)
+			list[name] = value
+		} else {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// maxResourceList sets each resource of list to the max of list and new
+func maxResourceList(list, new v1.ResourceList) {
+	for name, quantity := range new {
+		if value, ok := list[name]; !ok || quantity.Cmp(value) > 0 {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// container returns a container with the cpu and memory requests
+func container(name string, cpu string, memory string) v1.Container {
+	return v1.Container{
+		Name: name,
+		Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse(cpu),
+			v1.ResourceMemory: resource.MustParse(memory),
+		}},
+	}
+}
+
+func TestGetPodRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   v1.PodSpec
+		cpu    int64
+		memory int64
+	}{
+		{
+			name:   "app containers are summed",
+			spec:   v1.PodSpec{Containers: []v1.Container{container("web", "500m", "1Gi"), container("sidecar", "100m", "128Mi")}},
+			cpu:    600,
+			memory: 1152 << 20,
+		},
+		{
+			name: "init containers below the app containers",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{container("migrate", "200m", "256Mi")},
+				Containers:     []v1.Container{container("web", "500m", "1Gi")},
+			},
+			cpu:    500,
+			memory: 1 << 30,
+		},
+		{
+			name: "the largest init container per resource",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{container("migrate", "2", "256Mi"), container("warmup", "100m", "4Gi")},
+				Containers:     []v1.Container{container("web", "500m", "1Gi")},
+			},
+			cpu:    2000,
+			memory: 4 << 30,
+		},
+		{
+			name: "overhead is added",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{container("migrate", "1", "256Mi")},
+				Containers:     []v1.Container{container("web", "500m", "1Gi")},
+				Overhead:       v1.ResourceList{v1.ResourceCPU: resource.MustParse("250m"), v1.ResourceMemory: resource.MustParse("160Mi")},
+			},
+			cpu:    1250,
+			memory: 1184 << 20,
+		},
+		{
+			name:   "no requests",
+			spec:   v1.PodSpec{Containers: []v1.Container{{Name: "web"}}},
+			cpu:    0,
+			memory: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := GetPodRequests(&v1.Pod{Spec: test.spec})
+			if cpu := requests.Cpu().MilliValue(); cpu != test.cpu {
+				t.Errorf("expected %dm cpu, got %dm", test.cpu, cpu)
+			}
+			if memory := requests.Memory().Value(); memory != test.memory {
+				t.Errorf("expected %d bytes of memory, got %d", test.memory, memory)
+			}
+		})
+	}
+}
+
+func TestGetPodRequestsKeepsSpec(t *testing.T) {
+	pod := &v1.Pod{Spec: v1.PodSpec{
+		InitContainers: []v1.Container{container("migrate", "2", "2Gi")},
+		Containers:     []v1.Container{container("web", "500m", "1Gi"), container("sidecar", "100m", "128Mi")},
+		Overhead:       v1.ResourceList{v1.ResourceCPU: resource.MustParse("250m")},
+	}}
+
+	GetPodRequests(pod)
+	GetPodRequests(pod)
+	if cpu := pod.Spec.Containers[0].Resources.Requests.Cpu().MilliValue(); cpu != 500 {
+		t.Errorf("expected the requests of the container to be unchanged, got %dm", cpu)
+	}
+	if cpu := pod.Spec.InitContainers[0].Resources.Requests.Cpu().MilliValue(); cpu != 2000 {
+		t.Errorf("expected the requests of the init container to be unchanged, got %dm", cpu)
+	}
+	if cpu := pod.Spec.Overhead.Cpu().MilliValue(); cpu != 250 {
+		t.Errorf("expected the overhead to be unchanged, got %dm", cpu)
+	}
+}
@@ -0,0 +1,445 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	PRICING_FILE            string
+	PRICING_RELOAD_INTERVAL time.Duration
+	RATES_FILE              string
+	CURRENCY                string
+	// pricingSchedule are the active pricings sorted by their effective date, the first is effective since ever,
+	// replaced with the rates on every reload of the PRICING_FILE or the RATES_FILE
+	pricingSchedule []PriceSchedule
+	rates           Rates
+	pricingMutex    sync.RWMutex
+	currencyRegex   = regexp.MustCompile(`^[A-Z]{3}$`)
+
+	ErrNoExchangeRate = errors.New("no exchange rate")
+)
+
+// Prices are the prices of the resources in the currency, zero values use the prices of the next level
+// and an empty currency uses the currency of the pricing
+type Prices struct {
+	Currency string             `json:"currency"`
+	CPU      float64            `json:"cpu"`
+	Memory   float64            `json:"memory"`
+	Ingress  float64            `json:"ingress"`
+	Storage  map[string]float64 `json:"storage"`
+}
+
+// Pricing are the global prices with overrides per cluster and per tenant tier,
+// the tier prices take precedence over the cluster prices and the tier currency is the currency of its tenants
+type Pricing struct {
+	Prices
+	Clusters map[string]Prices `json:"clusters"`
+	Tiers    map[string]Prices `json:"tiers"`
+	// Tenants maps a tenant to its tier
+	Tenants map[string]string `json:"tenants"`
+}
+
+// PriceSchedule is a pricing which replaces the previous pricing from its effective date on
+type PriceSchedule struct {
+	EffectiveFrom time.Time `json:"effective_from"`
+	Pricing
+}
+
+// PricingFile is the pricing effective until the first schedule and the scheduled pricings
+type PricingFile struct {
+	Pricing
+	Schedules []PriceSchedule `json:"schedules"`
+}
+
+// Rates are the exchange rates of one unit of the base currency
+type Rates struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// LoadPricing loads and validates the pricing of the PRICING_FILE or of the env variables if it is not set
+// and the rates of the RATES_FILE, needs synced informer caches
+func LoadPricing() error {
+	pricingData, ratesData, err := readPricingFiles()
+	if err == nil {
+		err = loadPricing(pricingData, ratesData)
+	}
+	if err != nil {
+		ErrorLogger.Println(err)
+		Status = "Error: " + err.Error()
+		return err
+	}
+	return nil
+}
+
+// RunPricingWatcher reloads the PRICING_FILE and the RATES_FILE each PRICING_RELOAD_INTERVAL if their content changed until stopCh is closed,
+// an invalid pricing is logged and the previous pricing is kept
+func RunPricingWatcher(stopCh <-chan struct{}) {
+	if PRICING_FILE == "" && RATES_FILE == "" {
+		return
+	}
+
+	// compare the content, a mounted ConfigMap is updated by swapping a symlink
+	lastPricingData, lastRatesData, _ := readPricingFiles()
+
+	ticker := time.NewTicker(PRICING_RELOAD_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		pricingData, ratesData, err := readPricingFiles()
+		if err != nil {
+			ErrorLogger.Printf("Error reading pricing files: %v", err)
+			continue
+		}
+		if bytes.Equal(pricingData, lastPricingData) && bytes.Equal(ratesData, lastRatesData) {
+			continue
+		}
+		lastPricingData, lastRatesData = pricingData, ratesData
+
+		if err := loadPricing(pricingData, ratesData); err != nil {
+			ErrorLogger.Printf("Error reloading pricing files, keeping the previous pricing: %v", err)
+			continue
+		}
+		InfoLogger.Println("pricing reloaded")
+	}
+}
+
+// GetCurrency returns the global currency of the current prices
+func GetCurrency() string {
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
+	return currentPricing().Currency
+}
+
+// GetTenantCurrency returns the currency of the current prices of the tenant
+func GetTenantCurrency(tenant string) string {
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
+	return currentPricing().tenantCurrency(tenant)
+}
+
+// GetTenantCurrencyAt returns the currency of the prices of the tenant effective at the time
+func GetTenantCurrencyAt(tenant string, at time.Time) string {
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
+	return pricingAt(at).tenantCurrency(tenant)
+}
+
+// ConvertCurrency converts the amount from a currency to another with the rates of the RATES_FILE
+func ConvertCurrency(amount float64, from string, to string) (float64, error) {
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
+	return rates.convert(amount, from, to)
+}
+
+// IsCurrency returns true if the currency is an ISO 4217 code
+func IsCurrency(currency string) bool {
+	return currencyRegex.MatchString(currency)
+}
+
+// readPricingFiles returns the content of the PRICING_FILE and the RATES_FILE, unset files are empty
+func readPricingFiles() ([]byte, []byte, error) {
+	var pricingData, ratesData []byte
+	var err error
+	if PRICING_FILE != "" {
+		if pricingData, err = os.ReadFile(PRICING_FILE); err != nil {
+			return nil, nil, fmt.Errorf("cannot read pricing file: %w", err)
+		}
+	}
+	if RATES_FILE != "" {
+		if ratesData, err = os.ReadFile(RATES_FILE); err != nil {
+			return nil, nil, fmt.Errorf("cannot read rates file: %w", err)
+		}
+	}
+	return pricingData, ratesData, nil
+}
+
+// loadPricing parses and validates the pricing and the rates and replaces the active ones,
+// an empty pricing uses the cost env variables
+func loadPricing(pricingData []byte, ratesData []byte) error {
+	pricingFile := PricingFile{Pricing: getEnvPricing()}
+	if PRICING_FILE != "" {
+		pricingFile = PricingFile{}
+		if err := yaml.UnmarshalStrict(pricingData, &pricingFile); err != nil {
+			return fmt.Errorf("invalid pricing file: %w", err)
+		}
+	}
+
+	newRates := Rates{Base: CURRENCY}
+	if RATES_FILE != "" {
+		if err := yaml.UnmarshalStrict(ratesData, &newRates); err != nil {
+			return fmt.Errorf("invalid rates file: %w", err)
+		}
+	}
+
+	schedule := append([]PriceSchedule{{Pricing: pricingFile.Pricing}}, pricingFile.Schedules...)
+	if err := validatePricing(schedule, newRates); err != nil {
+		return err
+	}
+
+	pricingMutex.Lock()
+	defer pricingMutex.Unlock()
+	pricingSchedule = schedule
+	rates = newRates
+	return nil
+}
+
+// getEnvPricing returns the pricing of the CURRENCY, CPU_COST, MEMORY_COST, INGRESS_COST, STORAGE_COST_<class> and CLUSTER_COSTS env variables
+func getEnvPricing() Pricing {
+	storage := make(map[string]float64)
+	for storageClass, storageCost := range STORAGE_COST {
+		storage[storageClass] = storageCost["cost"]
+	}
+
+	return Pricing{
+		Prices: Prices{
+			Currency: CURRENCY,
+			CPU:      CPU_COST,
+			Memory:   MEMORY_COST,
+			Ingress:  INGRESS_COST,
+			Storage:  storage,
+		},
+		Clusters: CLUSTER_COSTS,
+	}
+}
+
+// validatePricing sets the default currency, sorts the schedule by effective date and validates every pricing of it and the rates
+func validatePricing(schedule []PriceSchedule, newRates Rates) error {
+	if !IsCurrency(newRates.Base) {
+		return fmt.Errorf("invalid base currency %q of the rates", newRates.Base)
+	}
+	for currency, rate := range newRates.Rates {
+		if !IsCurrency(currency) || rate <= 0 {
+			return fmt.Errorf("invalid rate %s=%f, must be a positive rate of an ISO 4217 currency", currency, rate)
+		}
+	}
+
+	for i := range schedule {
+		if schedule[i].Currency == "" {
+			schedule[i].Currency = CURRENCY
+		}
+		if i > 0 && schedule[i].EffectiveFrom.IsZero() {
+			return fmt.Errorf("schedule %d has no effective_from date", i)
+		}
+	}
+
+	// the first pricing stays first, it is effective since ever
+	sort.SliceStable(schedule[1:], func(i, j int) bool {
+		return schedule[i+1].EffectiveFrom.Before(schedule[j+1].EffectiveFrom)
+	})
+
+	for i, priceSchedule := range schedule {
+		if i > 1 && priceSchedule.EffectiveFrom.Equal(schedule[i-1].EffectiveFrom) {
+			return fmt.Errorf("more than one schedule is effective from %s", priceSchedule.EffectiveFrom.Format(time.RFC3339))
+		}
+		if err := priceSchedule.validate(newRates); err != nil {
+			if i == 0 {
+				return err
+			}
+			return fmt.Errorf("schedule effective from %s: %w", priceSchedule.EffectiveFrom.Format(time.RFC3339), err)
+		}
+	}
+
+	return nil
+}
+
+// validate checks the prices and currencies are valid, the clusters and tiers exist
+// and every storage class of each cluster has a price
+func (p *Pricing) validate(newRates Rates) error {
+	if err := p.validatePrices("global", p.Prices); err != nil {
+		return err
+	}
+	for clusterName, prices := range p.Clusters {
+		if GetCluster(clusterName) == nil {
+			return fmt.Errorf("prices set for unknown cluster %s", clusterName)
+		}
+		if err := p.validatePrices("cluster "+clusterName, prices); err != nil {
+			return err
+		}
+	}
+	for tier, prices := range p.Tiers {
+		if err := p.validatePrices("tier "+tier, prices); err != nil {
+			return err
+		}
+	}
+	for tenant, tier := range p.Tenants {
+		if _, ok := p.Tiers[tier]; !ok {
+			return fmt.Errorf("tenant %s has unknown tier %s", tenant, tier)
+		}
+	}
+
+	// the global and the cluster prices are the fallback of every tier
+	for tier, tierPrices := range p.Tiers {
+		for _, prices := range append([]Prices{p.Prices}, clusterPrices(p.Clusters)...) {
+			if _, err := newRates.convert(1, p.currencyOf(prices), p.currencyOf(tierPrices)); err != nil {
+				return fmt.Errorf("prices cannot be used for tier %s: %w", tier, err)
+			}
+		}
+	}
+
+	for _, cluster := range Clusters {
+		storageClassesInCluster, err := cluster.GetStorageClassesInCluster()
+		if err != nil {
+			return errors.New("cannot get storage classes in cluster " + cluster.Name)
+		}
+
+		// check if every storage class in cluster has a global or a cluster price
+		for _, storageClass := range storageClassesInCluster {
+			if _, err := p.getStorageClassCost(cluster.Name, "", storageClass); err != nil {
+				return errors.New("Storage class " + storageClass + " of cluster " + cluster.Name + " is not set")
+			}
+		}
+	}
+
+	return nil
+}
+
+// validatePrices checks that none of the prices is negative and the currency is valid
+func (p *Pricing) validatePrices(level string, prices Prices) error {
+	if prices.CPU < 0 || prices.Memory < 0 || prices.Ingress < 0 {
+		return fmt.Errorf("%s prices must not be negative", level)
+	}
+	for storageClass, storageCost := range prices.Storage {
+		if storageCost < 0 {
+			return fmt.Errorf("%s price of storage class %s must not be negative", level, storageClass)
+		}
+	}
+
+	if currency := p.currencyOf(prices); !IsCurrency(currency) {
+		return fmt.Errorf("%s currency %q is not an ISO 4217 code", level, currency)
+	}
+	return nil
+}
+
+// clusterPrices returns the prices of all clusters
+func clusterPrices(clusters map[string]Prices) []Prices {
+	prices := make([]Prices, 0, len(clusters))
+	for _, clusterPrices := range clusters {
+		prices = append(prices, clusterPrices)
+	}
+	return prices
+}
+
+// currentPricing returns the pricing effective now, needs the pricingMutex
+func currentPricing() *Pricing {
+	return pricingAt(time.Now())
+}
+
+// pricingAt returns the pricing effective at the time, needs the pricingMutex
+func pricingAt(t time.Time) *Pricing {
+	if len(pricingSchedule) == 0 {
+		return &Pricing{}
+	}
+	pricing := &pricingSchedule[0].Pricing
+	for i := 1; i < len(pricingSchedule) && !pricingSchedule[i].EffectiveFrom.After(t); i++ {
+		pricing = &pricingSchedule[i].Pricing
+	}
+	return pricing
+}
+
+// levels returns the tier, the cluster and the global prices of the tenant in the cluster in order of precedence
+func (p *Pricing) levels(cluster string, tenant string) []Prices {
+	return []Prices{p.Tiers[p.Tenants[tenant]], p.Clusters[cluster], p.Prices}
+}
+
+// currencyOf returns the currency of the prices
+func (p *Pricing) currencyOf(prices Prices) string {
+	if prices.Currency == "" {
+		return p.Currency
+	}
+	return prices.Currency
+}
+
+// tenantCurrency returns the currency of the tier of the tenant
+func (p *Pricing) tenantCurrency(tenant string) string {
+	if tier, ok := p.Tiers[p.Tenants[tenant]]; ok {
+		return p.currencyOf(tier)
+	}
+	return p.Currency
+}
+
+// resolvePrice returns the first non zero price of the levels of the tenant in the cluster in the currency of the tenant, needs the pricingMutex
+func (p *Pricing) resolvePrice(cluster string, tenant string, price func(Prices) float64) float64 {
+	for _, prices := range p.levels(cluster, tenant) {
+		if value := price(prices); value != 0 {
+			converted, err := rates.convert(value, p.currencyOf(prices), p.tenantCurrency(tenant))
+			if err != nil {
+				// not reached, the rates are validated with the pricing
+				ErrorLogger.Printf("%s", err)
+			}
+			return converted
+		}
+	}
+	return 0
+}
+
+// getCPUCost returns the price of a CPU core of the tenant in the cluster
+func (p *Pricing) getCPUCost(cluster string, tenant string) float64 {
+	return p.resolvePrice(cluster, tenant, func(prices Prices) float64 { return prices.CPU })
+}
+
+// getMemoryCost returns the price of a GB of memory of the tenant in the cluster
+func (p *Pricing) getMemoryCost(cluster string, tenant string) float64 {
+	return p.resolvePrice(cluster, tenant, func(prices Prices) float64 { return prices.Memory })
+}
+
+// getIngressCost returns the price of a single ingress of the tenant in the cluster
+func (p *Pricing) getIngressCost(cluster string, tenant string) float64 {
+	return p.resolvePrice(cluster, tenant, func(prices Prices) float64 { return prices.Ingress })
+}
+
+// getStorageClassCost returns the price of a GB of the storage class of the tenant in the cluster,
+// a storage class without a price returns an error
+func (p *Pricing) getStorageClassCost(cluster string, tenant string, storageClass string) (float64, error) {
+	if storageCost := p.resolvePrice(cluster, tenant, func(prices Prices) float64 { return prices.Storage[storageClass] }); storageCost != 0 {
+		return storageCost, nil
+	}
+	// a global price of zero makes the storage class free
+	if _, ok := p.Storage[storageClass]; ok {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("storage class %s not found", storageClass)
+}
+
+// convert converts the amount from a currency to another over the base currency
+func (r Rates) convert(amount float64, from string, to string) (float64, error) {
+	if from == to {
+		return amount, nil
+	}
+	fromRate, err := r.rate(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := r.rate(to)
+	if err != nil {
+		return 0, err
+	}
+	return amount / fromRate * toRate, nil
+}
+
+// rate returns the rate of one unit of the base currency in the currency
+func (r Rates) rate(currency string) (float64, error) {
+	if currency == r.Base {
+		return 1, nil
+	}
+	rate, ok := r.Rates[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w from %s to %s", ErrNoExchangeRate, r.Base, currency)
+	}
+	return rate, nil
+}
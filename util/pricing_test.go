@@ -0,0 +1,210 @@
+package util
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scheduledPricing is a pricing in CHF with a gold tier in EUR for acme and a schedule which raises the prices from march on
+const scheduledPricing = `
+cpu: 1
+memory: 2
+tiers:
+  gold:
+    currency: EUR
+    cpu: 0.4
+tenants:
+  acme: gold
+schedules:
+- effective_from: "2026-03-01T00:00:00Z"
+  cpu: 2
+  memory: 3
+  tiers:
+    gold:
+      cpu: 1
+  tenants:
+    acme: gold
+`
+
+func TestPricingSchedule(t *testing.T) {
+	setupCluster(t, scheduledPricing)
+	march := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		tenant   string
+		currency string
+		cpu      float64
+		memory   float64
+	}{
+		{name: "tier before the schedule", at: march.Add(-time.Second), tenant: "acme", currency: "EUR", cpu: 0.4, memory: 1},
+		{name: "global before the schedule", at: march.Add(-time.Second), tenant: "globex", currency: "CHF", cpu: 1, memory: 2},
+		{name: "tier from the effective date", at: march, tenant: "acme", currency: "CHF", cpu: 1, memory: 3},
+		{name: "global after the effective date", at: march.AddDate(1, 0, 0), tenant: "globex", currency: "CHF", cpu: 2, memory: 3},
+		{name: "first pricing since ever", at: time.Time{}, tenant: "acme", currency: "EUR", cpu: 0.4, memory: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if currency := GetTenantCurrencyAt(test.tenant, test.at); currency != test.currency {
+				t.Errorf("expected the currency %s, got %s", test.currency, currency)
+			}
+			pricingMutex.RLock()
+			defer pricingMutex.RUnlock()
+			pricing := pricingAt(test.at)
+			if cpu := pricing.getCPUCost("prod", test.tenant); math.Abs(cpu-test.cpu) > 1e-9 {
+				t.Errorf("expected a cpu price of %v, got %v", test.cpu, cpu)
+			}
+			// the memory price of the tier falls back to the global price in the currency of the tier
+			if memory := pricing.getMemoryCost("prod", test.tenant); math.Abs(memory-test.memory) > 1e-9 {
+				t.Errorf("expected a memory price of %v, got %v", test.memory, memory)
+			}
+		})
+	}
+}
+
+func TestPricingTiers(t *testing.T) {
+	setupCluster(t, "cpu: 1\n")
+	pricing := "cpu: 1\nmemory: 2\nclusters:\n  prod:\n    cpu: 3\ntiers:\n  gold:\n    cpu: 0.5\ntenants:\n  acme: gold\n"
+	if err := loadPricing([]byte(pricing), nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
+	current := currentPricing()
+	// the tier price takes precedence over the cluster price, the missing tier prices fall back to the cluster and the global prices
+	if cpu, memory := current.getCPUCost("prod", "acme"), current.getMemoryCost("prod", "acme"); cpu != 0.5 || memory != 2 {
+		t.Errorf("expected the cpu price of the tier and the global memory price, got %v and %v", cpu, memory)
+	}
+	if cpu, memory := current.getCPUCost("prod", "globex"), current.getMemoryCost("prod", "globex"); cpu != 3 || memory != 2 {
+		t.Errorf("expected the cpu price of the cluster and the global memory price, got %v and %v", cpu, memory)
+	}
+	if cpu := current.getCPUCost("staging", "globex"); cpu != 1 {
+		t.Errorf("expected the global cpu price in another cluster, got %v", cpu)
+	}
+}
+
+func TestLoadPricingValidatesTiers(t *testing.T) {
+	setupCluster(t, "cpu: 1\n")
+
+	invalid := map[string]string{
+		"unknown tier":        "cpu: 1\ntenants:\n  acme: gold\n",
+		"negative tier price": "cpu: 1\ntiers:\n  gold:\n    cpu: -1\n",
+		"unknown cluster":     "cpu: 1\nclusters:\n  staging:\n    cpu: 2\n",
+	}
+	for name, pricing := range invalid {
+		if err := loadPricing([]byte(pricing), nil); err == nil {
+			t.Errorf("expected the pricing with %s to be invalid", name)
+		}
+	}
+}
+
+func TestLoadPricingValidatesSchedules(t *testing.T) {
+	setupCluster(t, "cpu: 1\n")
+	rates := []byte("base: CHF\nrates:\n  EUR: 0.5\n")
+
+	invalid := map[string]string{
+		"no effective date":      "cpu: 1\nschedules:\n- cpu: 2\n",
+		"same effective date":    "cpu: 1\nschedules:\n- effective_from: \"2026-03-01T00:00:00Z\"\n  cpu: 2\n- effective_from: \"2026-03-01T00:00:00Z\"\n  cpu: 3\n",
+		"negative price":         "cpu: 1\nschedules:\n- effective_from: \"2026-03-01T00:00:00Z\"\n  cpu: -2\n",
+		"tier without rate":      "cpu: 1\ntiers:\n  gold:\n    currency: USD\n",
+		"invalid currency":       "cpu: 1\ncurrency: francs\n",
+		"unknown pricing fields": "cpu: 1\ncpus: 2\n",
+	}
+	for name, pricing := range invalid {
+		if err := loadPricing([]byte(pricing), rates); err == nil {
+			t.Errorf("expected the pricing with %s to be invalid", name)
+		}
+	}
+	if err := loadPricing([]byte("cpu: 1\n"), []byte("base: CHF\nrates:\n  EUR: 0\n")); err == nil {
+		t.Errorf("expected a rate of zero to be invalid")
+	}
+
+	// an invalid pricing keeps the active one
+	if currency := GetTenantCurrency("acme"); currency != "CHF" {
+		t.Errorf("expected the active pricing to be kept, got the currency %s", currency)
+	}
+
+	// the schedules are sorted by their effective date
+	unsorted := "cpu: 1\nschedules:\n- effective_from: \"2026-05-01T00:00:00Z\"\n  cpu: 3\n- effective_from: \"2026-03-01T00:00:00Z\"\n  cpu: 2\n"
+	if err := loadPricing([]byte(unsorted), rates); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
+	for at, expected := range map[time.Time]float64{
+		time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC): 1,
+		time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC): 2,
+		time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC): 3,
+	} {
+		if cpu := pricingAt(at).getCPUCost("prod", "acme"); cpu != expected {
+			t.Errorf("expected a cpu price of %v at %s, got %v", expected, at.Format(time.RFC3339), cpu)
+		}
+	}
+}
+
+func TestConvertCurrency(t *testing.T) {
+	setupCluster(t, "cpu: 1\n")
+	if err := loadPricing([]byte("cpu: 1\n"), []byte("base: CHF\nrates:\n  EUR: 0.5\n  USD: 2\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		amount   float64
+		from     string
+		to       string
+		expected float64
+	}{
+		{amount: 10, from: "CHF", to: "CHF", expected: 10},
+		{amount: 10, from: "CHF", to: "EUR", expected: 5},
+		{amount: 5, from: "EUR", to: "CHF", expected: 10},
+		{amount: 5, from: "EUR", to: "USD", expected: 20},
+	}
+	for _, test := range tests {
+		converted, err := ConvertCurrency(test.amount, test.from, test.to)
+		if err != nil || math.Abs(converted-test.expected) > 1e-9 {
+			t.Errorf("expected %v %s to be %v %s, got %v %v", test.amount, test.from, test.expected, test.to, converted, err)
+		}
+	}
+	if _, err := ConvertCurrency(1, "CHF", "GBP"); !errors.Is(err, ErrNoExchangeRate) {
+		t.Errorf("expected no exchange rate, got %v", err)
+	}
+}
+
+func TestConvertCostSamples(t *testing.T) {
+	setupCluster(t, "cpu: 1\ntiers:\n  gold:\n    currency: EUR\ntenants:\n  acme: gold\n")
+
+	samples := []CostSample{
+		{Tenant: "acme", Currency: "CHF", CPUCost: 2, CPUListCost: 4, StorageCost: map[string]float64{"ssd": 6}},
+		{Tenant: "acme", Currency: "EUR", CPUCost: 2, CPUListCost: 4, StorageCost: map[string]float64{"ssd": 6}},
+		// samples without a currency are in the current currency of the tenant
+		{Tenant: "acme", CPUCost: 2},
+	}
+	converted, err := ConvertCostSamples(samples, "EUR")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []CostSample{
+		{Tenant: "acme", Currency: "EUR", CPUCost: 1, CPUListCost: 2, StorageCost: map[string]float64{"ssd": 3}},
+		{Tenant: "acme", Currency: "EUR", CPUCost: 2, CPUListCost: 4, StorageCost: map[string]float64{"ssd": 6}},
+		{Tenant: "acme", Currency: "EUR", CPUCost: 2},
+	}
+	for i, sample := range converted {
+		if sample.Currency != expected[i].Currency || sample.CPUCost != expected[i].CPUCost || sample.CPUListCost != expected[i].CPUListCost ||
+			sample.StorageCost["ssd"] != expected[i].StorageCost["ssd"] {
+			t.Errorf("expected sample %d to be %+v, got %+v", i, expected[i], sample)
+		}
+	}
+	if samples[0].CPUCost != 2 || samples[0].StorageCost["ssd"] != 6 {
+		t.Errorf("expected the samples to be unchanged, got %+v", samples[0])
+	}
+
+	if _, err := ConvertCostSamples(samples, "USD"); err == nil || !strings.Contains(err.Error(), "USD") {
+		t.Errorf("expected no exchange rate to USD, got %v", err)
+	}
+}
@@ -0,0 +1,667 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DatasourceKubernetes reads the pods, pvcs and ingresses from the informer caches
+	DatasourceKubernetes = "kubernetes"
+	// DatasourcePrometheus reads the pods, pvcs and ingresses from the kube-state-metrics and cAdvisor metrics in Prometheus
+	DatasourcePrometheus = "prometheus"
+
+	// prometheusMaxPoints is the max count of timestamps of a range query, Prometheus rejects more than 11000
+	prometheusMaxPoints = 10000
+)
+
+var (
+	DATASOURCE               string
+	PROMETHEUS_URL           string
+	PROMETHEUS_CLUSTER_LABEL string
+
+	ErrPrometheusQuery = errors.New("prometheus query failed")
+
+	prometheusClient = &http.Client{Timeout: 30 * time.Second}
+
+	// invalidPrometheusLabelChars are replaced by kube-state-metrics in the names of the kubernetes labels
+	invalidPrometheusLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// the PromQL queries of the objects of the tenants by name, %s is the selector of the namespaces and the cluster
+var (
+	prometheusPodQueries = map[string]string{
+		"pod_info":            `kube_pod_info{%s}`,
+		"pod_phase":           `kube_pod_status_phase{%s} == 1`,
+		"pod_labels":          `kube_pod_labels{%s}`,
+		"pod_cpu_requests":    `sum by (namespace, pod) (kube_pod_container_resource_requests{resource="cpu",%s})`,
+		"pod_memory_requests": `sum by (namespace, pod) (kube_pod_container_resource_requests{resource="memory",%s})`,
+	}
+	prometheusUsageQueries = map[string]string{
+		"pod_cpu_usage":    `sum by (namespace, pod) (rate(container_cpu_usage_seconds_total{container!="",container!="POD",%s}[5m]))`,
+		"pod_memory_usage": `sum by (namespace, pod) (container_memory_working_set_bytes{container!="",container!="POD",%s})`,
+	}
+	prometheusPVCQueries = map[string]string{
+		"pvc_info":     `kube_persistentvolumeclaim_info{%s}`,
+		"pvc_requests": `kube_persistentvolumeclaim_resource_requests_storage_bytes{%s}`,
+		"pvc_labels":   `kube_persistentvolumeclaim_labels{%s}`,
+	}
+	prometheusIngressQueries = map[string]string{
+		"ingress_info":   `kube_ingress_info{%s}`,
+		"ingress_path":   `kube_ingress_path{%s}`,
+		"ingress_labels": `kube_ingress_labels{%s}`,
+	}
+)
+
+// prometheusSample is the value of a series at a point in time
+type prometheusSample struct {
+	Metric map[string]string
+	Value  float64
+}
+
+// prometheusVectors are the samples of each query by name at a point in time
+type prometheusVectors map[string][]prometheusSample
+
+// prometheusSnapshot is a cluster with the pods, pvcs and ingresses of the tenants read from Prometheus at the timestamp
+type prometheusSnapshot struct {
+	timestamp time.Time
+	cluster   *Cluster
+}
+
+// getPrometheusCostSamples returns a cost sample of the tenant in each cluster for each HISTORY_INTERVAL in [from, to)
+// calculated with the prices effective at the time from the objects of the tenant in Prometheus at the time
+func getPrometheusCostSamples(tenant string, from time.Time, to time.Time) ([]CostSample, error) {
+	// Prometheus limits the count of points of a range query
+	step := HISTORY_INTERVAL
+	if minStep := to.Sub(from) / prometheusMaxPoints; minStep > step {
+		step = minStep.Truncate(time.Second) + time.Second
+	}
+
+	samples := make([]CostSample, 0)
+	for _, cluster := range Clusters {
+		snapshots, err := cluster.getPrometheusSnapshots([]string{tenant}, from, to, step)
+		if err != nil {
+			return nil, err
+		}
+		for _, snapshot := range snapshots {
+			snapshotSamples, err := snapshot.cluster.getCostSamples([]string{tenant}, snapshot.timestamp)
+			if err != nil {
+				return nil, err
+			}
+			for _, sample := range snapshotSamples {
+				sample.Interval = step
+				samples = append(samples, sample)
+			}
+		}
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Timestamp.Before(samples[j].Timestamp)
+	})
+	return samples, nil
+}
+
+// checkPrometheusRetention returns ErrPeriodUncovered if Prometheus has no pods of a cluster within a HISTORY_INTERVAL after the time,
+// e.g. the time is before the retention of Prometheus (15d by default)
+func checkPrometheusRetention(from time.Time) error {
+	for _, cluster := range Clusters {
+		selector := ""
+		if PROMETHEUS_CLUSTER_LABEL != "" {
+			selector = fmt.Sprintf("%s=%q", PROMETHEUS_CLUSTER_LABEL, cluster.Name)
+		}
+		timestampSamples, err := queryPrometheusRange(fmt.Sprintf("count(kube_pod_info{%s})", selector), from, from.Add(HISTORY_INTERVAL), HISTORY_INTERVAL)
+		if err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+		if len(timestampSamples) == 0 {
+			return ErrPeriodUncovered
+		}
+	}
+	return nil
+}
+
+// getPrometheusSnapshots returns a snapshot of the objects of the tenants for each step in [from, to) with data in Prometheus
+func (cluster *Cluster) getPrometheusSnapshots(tenants []string, from time.Time, to time.Time, step time.Duration) ([]prometheusSnapshot, error) {
+	queries := mergeQueries(prometheusPodQueries, prometheusPVCQueries, prometheusIngressQueries)
+	if BILL_ON_USAGE {
+		queries = mergeQueries(queries, prometheusUsageQueries)
+	}
+
+	// the timestamps of the range query are start + n * step until end
+	end := to.Add(-time.Second)
+	if end.Before(from) {
+		return nil, nil
+	}
+	timestampVectors, err := cluster.queryPrometheus(queries, tenants, from, end, step)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamps := make([]int64, 0, len(timestampVectors))
+	for timestamp := range timestampVectors {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	snapshots := make([]prometheusSnapshot, 0, len(timestamps))
+	for _, timestamp := range timestamps {
+		snapshots = append(snapshots, prometheusSnapshot{
+			timestamp: time.Unix(timestamp, 0).UTC(),
+			cluster:   cluster.newPrometheusSnapshot(timestampVectors[timestamp]),
+		})
+	}
+	return snapshots, nil
+}
+
+// snapshot returns the cluster with the pods, pvcs, ingresses and pod usage of the tenants read from Prometheus
+// with one query of each kind for all tenants, with DATASOURCE kubernetes the cluster reads the informer caches
+func (cluster *Cluster) snapshot(tenants []string) (*Cluster, error) {
+	if DATASOURCE != DatasourcePrometheus || len(tenants) == 0 {
+		return cluster, nil
+	}
+
+	queries := mergeQueries(prometheusPodQueries, prometheusPVCQueries, prometheusIngressQueries, prometheusUsageQueries)
+	vectors, err := cluster.queryPrometheusAt(queries, tenants, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return cluster.newPrometheusSnapshot(vectors), nil
+}
+
+// newPrometheusSnapshot returns a copy of the cluster with the objects of the vectors indexed by namespace
+func (cluster *Cluster) newPrometheusSnapshot(vectors prometheusVectors) *Cluster {
+	podLister, pvcLister, ingressLister := vectors.newSnapshotListers()
+	return &Cluster{
+		Name:                cluster.Name,
+		NamespaceLister:     cluster.NamespaceLister,
+		ResourceQuotaLister: cluster.ResourceQuotaLister,
+		StorageClassLister:  cluster.StorageClassLister,
+		PodLister:           podLister,
+		PVCLister:           pvcLister,
+		IngressLister:       ingressLister,
+		prometheusUsage:     vectors.podUsage(),
+	}
+}
+
+// queryPrometheusAt returns the samples of each query for the tenants in the cluster at the time
+func (cluster *Cluster) queryPrometheusAt(queries map[string]string, tenants []string, at time.Time) (prometheusVectors, error) {
+	timestampVectors, err := cluster.queryPrometheus(queries, tenants, at, at, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	for _, vectors := range timestampVectors {
+		return vectors, nil
+	}
+	return prometheusVectors{}, nil
+}
+
+// queryPrometheus evaluates each query for the tenants in the cluster at each step in [start, end] and returns the samples keyed by unix timestamp
+func (cluster *Cluster) queryPrometheus(queries map[string]string, tenants []string, start time.Time, end time.Time, step time.Duration) (map[int64]prometheusVectors, error) {
+	selector := cluster.prometheusSelector(tenants)
+
+	timestampVectors := make(map[int64]prometheusVectors)
+	for name, query := range queries {
+		timestampSamples, err := queryPrometheusRange(fmt.Sprintf(query, selector), start, end, step)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+		for timestamp, samples := range timestampSamples {
+			if timestampVectors[timestamp] == nil {
+				timestampVectors[timestamp] = make(prometheusVectors)
+			}
+			timestampVectors[timestamp][name] = samples
+		}
+	}
+	return timestampVectors, nil
+}
+
+// prometheusSelector returns the label selector of the namespaces of the tenants and of the cluster if PROMETHEUS_CLUSTER_LABEL is set
+func (cluster *Cluster) prometheusSelector(tenants []string) string {
+	namespaces := make([]string, 0, len(tenants))
+	for _, tenant := range tenants {
+		namespaces = append(namespaces, regexp.QuoteMeta(tenant))
+	}
+	selector := fmt.Sprintf("namespace=~%q", strings.Join(namespaces, "|"))
+	if PROMETHEUS_CLUSTER_LABEL != "" {
+		selector += fmt.Sprintf(",%s=%q", PROMETHEUS_CLUSTER_LABEL, cluster.Name)
+	}
+	return selector
+}
+
+// queryPrometheusRange evaluates the PromQL query at each step in [start, end] and returns the samples keyed by unix timestamp
+func queryPrometheusRange(query string, start time.Time, end time.Time, step time.Duration) (map[int64][]prometheusSample, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+
+	resp, err := prometheusClient.PostForm(strings.TrimSuffix(PROMETHEUS_URL, "/")+"/api/v1/query_range", params)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrPrometheusQuery, err)
+	}
+	defer resp.Body.Close()
+
+	// the errors of the queries are returned in the body with the status code
+	var result struct {
+		Status    string `json:"status"`
+		ErrorType string `json:"errorType"`
+		Error     string `json:"error"`
+		Data      struct {
+			Result []struct {
+				Metric map[string]string    `json:"metric"`
+				Values [][2]json.RawMessage `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w with status %d: %s", ErrPrometheusQuery, resp.StatusCode, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("%w: %s: %s", ErrPrometheusQuery, result.ErrorType, result.Error)
+	}
+
+	timestampSamples := make(map[int64][]prometheusSample)
+	for _, series := range result.Data.Result {
+		for _, point := range series.Values {
+			var timestamp float64
+			var value string
+			if err := json.Unmarshal(point[0], &timestamp); err != nil {
+				return nil, fmt.Errorf("%w, invalid timestamp: %s", ErrPrometheusQuery, err)
+			}
+			if err := json.Unmarshal(point[1], &value); err != nil {
+				return nil, fmt.Errorf("%w, invalid value: %s", ErrPrometheusQuery, err)
+			}
+			parsedValue, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(parsedValue) || math.IsInf(parsedValue, 0) {
+				continue
+			}
+			timestampSamples[int64(timestamp)] = append(timestampSamples[int64(timestamp)], prometheusSample{
+				Metric: series.Metric,
+				Value:  parsedValue,
+			})
+		}
+	}
+	return timestampSamples, nil
+}
+
+// pods returns the pods of the vectors with the requests of their containers as requests of a single container,
+// their phase and the discount label
+func (vectors prometheusVectors) pods() []*v1.Pod {
+	pods := make(map[string]*v1.Pod)
+	for _, sample := range vectors["pod_info"] {
+		pods[objectKey(sample, "pod")] = &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sample.Metric["namespace"],
+				Name:      sample.Metric["pod"],
+				Labels:    make(map[string]string),
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{
+					Name: "requests",
+					Resources: v1.ResourceRequirements{
+						Requests: make(v1.ResourceList),
+					},
+				}},
+			},
+		}
+	}
+
+	for _, sample := range vectors["pod_phase"] {
+		if pod, ok := pods[objectKey(sample, "pod")]; ok {
+			pod.Status.Phase = v1.PodPhase(sample.Metric["phase"])
+		}
+	}
+	for _, sample := range vectors["pod_labels"] {
+		if pod, ok := pods[objectKey(sample, "pod")]; ok {
+			setDiscountLabel(&pod.ObjectMeta, sample)
+		}
+	}
+	for _, sample := range vectors["pod_cpu_requests"] {
+		if pod, ok := pods[objectKey(sample, "pod")]; ok {
+			pod.Spec.Containers[0].Resources.Requests[v1.ResourceCPU] = *resource.NewMilliQuantity(int64(math.Round(sample.Value*1000)), resource.DecimalSI)
+		}
+	}
+	for _, sample := range vectors["pod_memory_requests"] {
+		if pod, ok := pods[objectKey(sample, "pod")]; ok {
+			pod.Spec.Containers[0].Resources.Requests[v1.ResourceMemory] = *resource.NewQuantity(int64(sample.Value), resource.BinarySI)
+		}
+	}
+
+	podList := make([]*v1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		podList = append(podList, pod)
+	}
+	sort.Slice(podList, func(i, j int) bool { return podList[i].Name < podList[j].Name })
+	return podList
+}
+
+// podUsage returns the usage of each pod for each tenant of the vectors
+func (vectors prometheusVectors) podUsage() map[string]map[string]PodUsage {
+	tenantPodUsage := make(map[string]map[string]PodUsage)
+	addUsage := func(sample prometheusSample, usage PodUsage) {
+		tenant := sample.Metric["namespace"]
+		if tenantPodUsage[tenant] == nil {
+			tenantPodUsage[tenant] = make(map[string]PodUsage)
+		}
+		tenantPodUsage[tenant][sample.Metric["pod"]] = tenantPodUsage[tenant][sample.Metric["pod"]].add(usage)
+	}
+	for _, sample := range vectors["pod_cpu_usage"] {
+		addUsage(sample, PodUsage{CPU: int64(math.Round(sample.Value * 1000))})
+	}
+	for _, sample := range vectors["pod_memory_usage"] {
+		addUsage(sample, PodUsage{Memory: int64(sample.Value)})
+	}
+	return tenantPodUsage
+}
+
+// pvcs returns the pvcs of the vectors with their storage class, requested storage and the discount label
+func (vectors prometheusVectors) pvcs() []*v1.PersistentVolumeClaim {
+	pvcs := make(map[string]*v1.PersistentVolumeClaim)
+	for _, sample := range vectors["pvc_info"] {
+		pvc := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sample.Metric["namespace"],
+				Name:      sample.Metric["persistentvolumeclaim"],
+				Labels:    make(map[string]string),
+			},
+			Spec: v1.PersistentVolumeClaimSpec{
+				Resources: v1.ResourceRequirements{
+					Requests: make(v1.ResourceList),
+				},
+			},
+		}
+		if storageClass := sample.Metric["storageclass"]; storageClass != "" {
+			pvc.Spec.StorageClassName = &storageClass
+		}
+		pvcs[objectKey(sample, "persistentvolumeclaim")] = pvc
+	}
+
+	for _, sample := range vectors["pvc_labels"] {
+		if pvc, ok := pvcs[objectKey(sample, "persistentvolumeclaim")]; ok {
+			setDiscountLabel(&pvc.ObjectMeta, sample)
+		}
+	}
+	for _, sample := range vectors["pvc_requests"] {
+		if pvc, ok := pvcs[objectKey(sample, "persistentvolumeclaim")]; ok {
+			pvc.Spec.Resources.Requests[v1.ResourceStorage] = *resource.NewQuantity(int64(sample.Value), resource.BinarySI)
+		}
+	}
+
+	pvcList := make([]*v1.PersistentVolumeClaim, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		pvcList = append(pvcList, pvc)
+	}
+	sort.Slice(pvcList, func(i, j int) bool { return pvcList[i].Name < pvcList[j].Name })
+	return pvcList
+}
+
+// ingresses returns the ingresses of the vectors with a rule for each host of their paths and the discount label
+func (vectors prometheusVectors) ingresses() []*networkingv1.Ingress {
+	ingresses := make(map[string]*networkingv1.Ingress)
+	for _, sample := range vectors["ingress_info"] {
+		ingresses[objectKey(sample, "ingress")] = &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sample.Metric["namespace"],
+				Name:      sample.Metric["ingress"],
+				Labels:    make(map[string]string),
+			},
+		}
+	}
+
+	for _, sample := range vectors["ingress_labels"] {
+		if ingress, ok := ingresses[objectKey(sample, "ingress")]; ok {
+			setDiscountLabel(&ingress.ObjectMeta, sample)
+		}
+	}
+	// the paths of the same host belong to one rule
+	for _, sample := range vectors["ingress_path"] {
+		ingress, ok := ingresses[objectKey(sample, "ingress")]
+		if !ok {
+			continue
+		}
+		host := sample.Metric["host"]
+		hasRule := false
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host == host {
+				hasRule = true
+				break
+			}
+		}
+		if !hasRule {
+			ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{Host: host})
+		}
+	}
+
+	ingressList := make([]*networkingv1.Ingress, 0, len(ingresses))
+	for _, ingress := range ingresses {
+		sort.Slice(ingress.Spec.Rules, func(i, j int) bool { return ingress.Spec.Rules[i].Host < ingress.Spec.Rules[j].Host })
+		ingressList = append(ingressList, ingress)
+	}
+	sort.Slice(ingressList, func(i, j int) bool { return ingressList[i].Name < ingressList[j].Name })
+	return ingressList
+}
+
+// prometheusPodLister lists the pods of a namespace from Prometheus at the time of the call
+type prometheusPodLister struct {
+	cluster *Cluster
+}
+
+// List is not supported for all namespaces, the pods are listed by tenant
+func (lister prometheusPodLister) List(selector labels.Selector) ([]*v1.Pod, error) {
+	return nil, fmt.Errorf("listing the pods of all namespaces from Prometheus is not supported")
+}
+
+// Pods returns a lister of the pods of the namespace
+func (lister prometheusPodLister) Pods(namespace string) corelisters.PodNamespaceLister {
+	return prometheusPodNamespaceLister{cluster: lister.cluster, namespace: namespace}
+}
+
+type prometheusPodNamespaceLister struct {
+	cluster   *Cluster
+	namespace string
+}
+
+// List returns the pods of the namespace in Prometheus matching the selector
+func (lister prometheusPodNamespaceLister) List(selector labels.Selector) ([]*v1.Pod, error) {
+	vectors, err := lister.cluster.queryPrometheusAt(prometheusPodQueries, []string{lister.namespace}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]*v1.Pod, 0)
+	for _, pod := range vectors.pods() {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// Get returns the pod of the namespace in Prometheus with the name
+func (lister prometheusPodNamespaceLister) Get(name string) (*v1.Pod, error) {
+	pods, err := lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		if pod.Name == name {
+			return pod, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(v1.Resource("pod"), name)
+}
+
+// prometheusPVCLister lists the pvcs of a namespace from Prometheus at the time of the call
+type prometheusPVCLister struct {
+	cluster *Cluster
+}
+
+// List is not supported for all namespaces, the pvcs are listed by tenant
+func (lister prometheusPVCLister) List(selector labels.Selector) ([]*v1.PersistentVolumeClaim, error) {
+	return nil, fmt.Errorf("listing the pvcs of all namespaces from Prometheus is not supported")
+}
+
+// PersistentVolumeClaims returns a lister of the pvcs of the namespace
+func (lister prometheusPVCLister) PersistentVolumeClaims(namespace string) corelisters.PersistentVolumeClaimNamespaceLister {
+	return prometheusPVCNamespaceLister{cluster: lister.cluster, namespace: namespace}
+}
+
+type prometheusPVCNamespaceLister struct {
+	cluster   *Cluster
+	namespace string
+}
+
+// List returns the pvcs of the namespace in Prometheus matching the selector
+func (lister prometheusPVCNamespaceLister) List(selector labels.Selector) ([]*v1.PersistentVolumeClaim, error) {
+	vectors, err := lister.cluster.queryPrometheusAt(prometheusPVCQueries, []string{lister.namespace}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	pvcs := make([]*v1.PersistentVolumeClaim, 0)
+	for _, pvc := range vectors.pvcs() {
+		if selector.Matches(labels.Set(pvc.Labels)) {
+			pvcs = append(pvcs, pvc)
+		}
+	}
+	return pvcs, nil
+}
+
+// Get returns the pvc of the namespace in Prometheus with the name
+func (lister prometheusPVCNamespaceLister) Get(name string) (*v1.PersistentVolumeClaim, error) {
+	pvcs, err := lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, pvc := range pvcs {
+		if pvc.Name == name {
+			return pvc, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(v1.Resource("persistentvolumeclaim"), name)
+}
+
+// prometheusIngressLister lists the ingresses of a namespace from Prometheus at the time of the call
+type prometheusIngressLister struct {
+	cluster *Cluster
+}
+
+// List is not supported for all namespaces, the ingresses are listed by tenant
+func (lister prometheusIngressLister) List(selector labels.Selector) ([]*networkingv1.Ingress, error) {
+	return nil, fmt.Errorf("listing the ingresses of all namespaces from Prometheus is not supported")
+}
+
+// Ingresses returns a lister of the ingresses of the namespace
+func (lister prometheusIngressLister) Ingresses(namespace string) networkinglisters.IngressNamespaceLister {
+	return prometheusIngressNamespaceLister{cluster: lister.cluster, namespace: namespace}
+}
+
+type prometheusIngressNamespaceLister struct {
+	cluster   *Cluster
+	namespace string
+}
+
+// List returns the ingresses of the namespace in Prometheus matching the selector
+func (lister prometheusIngressNamespaceLister) List(selector labels.Selector) ([]*networkingv1.Ingress, error) {
+	vectors, err := lister.cluster.queryPrometheusAt(prometheusIngressQueries, []string{lister.namespace}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	ingresses := make([]*networkingv1.Ingress, 0)
+	for _, ingress := range vectors.ingresses() {
+		if selector.Matches(labels.Set(ingress.Labels)) {
+			ingresses = append(ingresses, ingress)
+		}
+	}
+	return ingresses, nil
+}
+
+// Get returns the ingress of the namespace in Prometheus with the name
+func (lister prometheusIngressNamespaceLister) Get(name string) (*networkingv1.Ingress, error) {
+	ingresses, err := lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, ingress := range ingresses {
+		if ingress.Name == name {
+			return ingress, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(networkingv1.Resource("ingress"), name)
+}
+
+// getPrometheusPodUsage returns the usage of each pod of the tenant from the cAdvisor metrics in Prometheus
+func (cluster *Cluster) getPrometheusPodUsage(tenant string) (map[string]PodUsage, error) {
+	vectors, err := cluster.queryPrometheusAt(prometheusUsageQueries, []string{tenant}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	podUsage := vectors.podUsage()[tenant]
+	if podUsage == nil {
+		podUsage = make(map[string]PodUsage)
+	}
+	return podUsage, nil
+}
+
+// setDiscountLabel sets the DISCOUNT_LABEL of the object from the kubernetes labels exported by kube-state-metrics
+func setDiscountLabel(object *metav1.ObjectMeta, sample prometheusSample) {
+	if discount, ok := sample.Metric["label_"+invalidPrometheusLabelChars.ReplaceAllString(DISCOUNT_LABEL, "_")]; ok && discount != "" {
+		object.Labels[DISCOUNT_LABEL] = discount
+	}
+}
+
+// objectKey returns the namespace/name key of the object of the sample with the name in the label
+func objectKey(sample prometheusSample, nameLabel string) string {
+	return sample.Metric["namespace"] + "/" + sample.Metric[nameLabel]
+}
+
+// newSnapshotListers returns listers of the pods, pvcs and ingresses of the vectors
+func (vectors prometheusVectors) newSnapshotListers() (corelisters.PodLister, corelisters.PersistentVolumeClaimLister, networkinglisters.IngressLister) {
+	podIndexer := newNamespaceIndexer()
+	for _, pod := range vectors.pods() {
+		podIndexer.Add(pod)
+	}
+	pvcIndexer := newNamespaceIndexer()
+	for _, pvc := range vectors.pvcs() {
+		pvcIndexer.Add(pvc)
+	}
+	ingressIndexer := newNamespaceIndexer()
+	for _, ingress := range vectors.ingresses() {
+		ingressIndexer.Add(ingress)
+	}
+	return corelisters.NewPodLister(podIndexer), corelisters.NewPersistentVolumeClaimLister(pvcIndexer), networkinglisters.NewIngressLister(ingressIndexer)
+}
+
+// newNamespaceIndexer returns an indexer of objects by namespace
+func newNamespaceIndexer() cache.Indexer {
+	return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+}
+
+// mergeQueries returns the queries of all maps in one map
+func mergeQueries(queryMaps ...map[string]string) map[string]string {
+	queries := make(map[string]string)
+	for _, queryMap := range queryMaps {
+		for name, query := range queryMap {
+			queries[name] = query
+		}
+	}
+	return queries
+}
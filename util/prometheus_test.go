@@ -0,0 +1,268 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// fakeSeries is a series returned by the fake Prometheus for the queries containing the match
+type fakeSeries struct {
+	match  string
+	metric string
+	value  string
+}
+
+// fakePrometheus serves the query_range API with the value of each matching series at every step and counts the queries
+type fakePrometheus struct {
+	series []fakeSeries
+	// oldest unix timestamp with data, the retention of Prometheus
+	oldest  int64
+	mutex   sync.Mutex
+	queries []string
+}
+
+func (prometheus *fakePrometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.URL.Path != "/api/v1/query_range" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"invalid request"}`))
+		return
+	}
+	query := r.Form.Get("query")
+	prometheus.mutex.Lock()
+	prometheus.queries = append(prometheus.queries, query)
+	prometheus.mutex.Unlock()
+
+	if strings.Contains(query, "invalid") {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+		return
+	}
+
+	start, _ := strconv.ParseInt(r.Form.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(r.Form.Get("end"), 10, 64)
+	step, _ := strconv.ParseFloat(r.Form.Get("step"), 64)
+
+	results := make([]string, 0)
+	for _, series := range prometheus.series {
+		if !strings.Contains(query, series.match) {
+			continue
+		}
+		values := make([]string, 0)
+		for timestamp := start; timestamp <= end; timestamp += int64(step) {
+			if timestamp < prometheus.oldest {
+				continue
+			}
+			values = append(values, fmt.Sprintf(`[%d,%q]`, timestamp, series.value))
+		}
+		results = append(results, fmt.Sprintf(`{"metric":%s,"values":[%s]}`, series.metric, strings.Join(values, ",")))
+	}
+	fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[%s]}}`, strings.Join(results, ","))
+}
+
+// queryCount returns the count of the queries received since the last call
+func (prometheus *fakePrometheus) queryCount() int {
+	prometheus.mutex.Lock()
+	defer prometheus.mutex.Unlock()
+	count := len(prometheus.queries)
+	prometheus.queries = nil
+	return count
+}
+
+// setupPrometheus starts a fake Prometheus with the series and uses it as datasource of a single cluster with the pricing
+func setupPrometheus(t *testing.T, series []fakeSeries, pricing string) (*fakePrometheus, *Cluster) {
+	t.Helper()
+	InitLoggers()
+
+	prometheus := &fakePrometheus{series: series}
+	server := httptest.NewServer(prometheus)
+	t.Cleanup(server.Close)
+
+	previousDatasource, previousURL, previousClusters := DATASOURCE, PROMETHEUS_URL, Clusters
+	previousPricingFile, previousRatesFile := PRICING_FILE, RATES_FILE
+	t.Cleanup(func() {
+		DATASOURCE, PROMETHEUS_URL, Clusters = previousDatasource, previousURL, previousClusters
+		PRICING_FILE, RATES_FILE = previousPricingFile, previousRatesFile
+	})
+
+	DATASOURCE = DatasourcePrometheus
+	PROMETHEUS_URL = server.URL
+	PROMETHEUS_CLUSTER_LABEL = ""
+	HISTORY_INTERVAL = time.Hour
+	DISCOUNT_LABEL = "natron.io/discount"
+	CURRENCY = "CHF"
+	BILL_ON_USAGE = false
+	EXCLUDE_TERMINATED_PODS = true
+
+	PRICING_FILE, RATES_FILE = "pricing.yaml", "rates.yaml"
+	if err := loadPricing([]byte(pricing), []byte("base: CHF\nrates:\n  EUR: 0.5\n")); err != nil {
+		t.Fatalf("cannot load the pricing: %s", err)
+	}
+
+	cluster := &Cluster{Name: "prod"}
+	cluster.PodLister = prometheusPodLister{cluster: cluster}
+	cluster.PVCLister = prometheusPVCLister{cluster: cluster}
+	cluster.IngressLister = prometheusIngressLister{cluster: cluster}
+	Clusters = []*Cluster{cluster}
+	return prometheus, cluster
+}
+
+// tenantSeries are the kube-state-metrics and cAdvisor series of a running pod, a pvc and an ingress of the tenant
+func tenantSeries(tenant string) []fakeSeries {
+	pod := fmt.Sprintf(`{"namespace":%q,"pod":"web"}`, tenant)
+	return []fakeSeries{
+		{match: "kube_pod_info", metric: pod, value: "1"},
+		{match: "kube_pod_status_phase", metric: fmt.Sprintf(`{"namespace":%q,"pod":"web","phase":"Running"}`, tenant), value: "1"},
+		{match: "kube_pod_labels", metric: fmt.Sprintf(`{"namespace":%q,"pod":"web","label_natron_io_discount":"0.5"}`, tenant), value: "1"},
+		{match: `resource="cpu"`, metric: pod, value: "2"},
+		{match: `resource="memory"`, metric: pod, value: "1073741824"},
+		{match: "container_cpu_usage_seconds_total", metric: pod, value: "0.5"},
+		{match: "container_memory_working_set_bytes", metric: pod, value: "536870912"},
+		{match: "kube_persistentvolumeclaim_info", metric: fmt.Sprintf(`{"namespace":%q,"persistentvolumeclaim":"data","storageclass":"ssd"}`, tenant), value: "1"},
+		{match: "kube_persistentvolumeclaim_resource_requests_storage_bytes", metric: fmt.Sprintf(`{"namespace":%q,"persistentvolumeclaim":"data"}`, tenant), value: "2147483648"},
+		{match: "kube_ingress_info", metric: fmt.Sprintf(`{"namespace":%q,"ingress":"web"}`, tenant), value: "1"},
+		{match: "kube_ingress_path", metric: fmt.Sprintf(`{"namespace":%q,"ingress":"web","host":"a.example.com","path":"/"}`, tenant), value: "1"},
+		{match: "kube_ingress_path", metric: fmt.Sprintf(`{"namespace":%q,"ingress":"web","host":"a.example.com","path":"/api"}`, tenant), value: "1"},
+	}
+}
+
+func TestQueryPrometheusRange(t *testing.T) {
+	_, _ = setupPrometheus(t, []fakeSeries{
+		{match: "up", metric: `{"job":"a"}`, value: "1.5"},
+		{match: "up", metric: `{"job":"b"}`, value: "NaN"},
+	}, "cpu: 1\n")
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	timestampSamples, err := queryPrometheusRange("up", start, start.Add(2*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(timestampSamples) != 3 {
+		t.Fatalf("expected 3 timestamps, got %d", len(timestampSamples))
+	}
+	samples := timestampSamples[start.Add(time.Minute).Unix()]
+	if len(samples) != 1 {
+		t.Fatalf("expected the NaN sample to be skipped, got %v", samples)
+	}
+	if samples[0].Metric["job"] != "a" || samples[0].Value != 1.5 {
+		t.Errorf("unexpected sample %v", samples[0])
+	}
+
+	if _, err := queryPrometheusRange("invalid(", start, start, time.Minute); err == nil || !strings.Contains(err.Error(), "parse error") {
+		t.Errorf("expected the error of Prometheus, got %v", err)
+	}
+}
+
+func TestPrometheusSnapshotListers(t *testing.T) {
+	series := append(tenantSeries("acme"), tenantSeries("globex")...)
+	prometheus, cluster := setupPrometheus(t, series, "cpu: 1\nmemory: 1\ningress: 1\nstorage:\n  ssd: 1\n")
+
+	snapshot, err := cluster.snapshot([]string{"acme", "globex"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	queries := prometheus.queryCount()
+	if expected := len(mergeQueries(prometheusPodQueries, prometheusPVCQueries, prometheusIngressQueries, prometheusUsageQueries)); queries != expected {
+		t.Errorf("expected one query per metric for all tenants (%d), got %d", expected, queries)
+	}
+
+	pods, err := snapshot.PodLister.Pods("acme").List(labels.Everything())
+	if err != nil || len(pods) != 1 {
+		t.Fatalf("expected the pod of the tenant, got %v %v", pods, err)
+	}
+	pod := pods[0]
+	if pod.Status.Phase != v1.PodRunning || pod.Labels[DISCOUNT_LABEL] != "0.5" {
+		t.Errorf("unexpected phase %s or labels %v", pod.Status.Phase, pod.Labels)
+	}
+	if requests := GetPodRequests(pod); requests.Cpu().MilliValue() != 2000 || requests.Memory().Value() != 1073741824 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if usage := snapshot.prometheusUsage["acme"]["web"]; usage.CPU != 500 || usage.Memory != 536870912 {
+		t.Errorf("unexpected usage %v", usage)
+	}
+
+	pvc, err := snapshot.PVCLister.PersistentVolumeClaims("globex").Get("data")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *pvc.Spec.StorageClassName != "ssd" || pvc.Spec.Resources.Requests.Storage().Value() != 2147483648 {
+		t.Errorf("unexpected pvc %v", pvc.Spec)
+	}
+
+	ingress, err := snapshot.IngressLister.Ingresses("acme").Get("web")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(ingress.Spec.Rules) != 1 || ingress.Spec.Rules[0].Host != "a.example.com" {
+		t.Errorf("expected one rule for the paths of the host, got %v", ingress.Spec.Rules)
+	}
+
+	// the cost summary of all tenants reads the snapshot once
+	clusterTenantSummaries, err := GetCostSummaryByClusterByTenant([]string{"acme", "globex"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tenantSummaries := SumCostSummaryByTenant(clusterTenantSummaries)
+	if queries := prometheus.queryCount(); queries != len(mergeQueries(prometheusPodQueries, prometheusPVCQueries, prometheusIngressQueries, prometheusUsageQueries)) {
+		t.Errorf("expected the snapshot to be read once for the summary, got %d queries", queries)
+	}
+	// 2 cores and 1 GB with 50% discount, 2 GB of ssd and one hostname
+	if summary := tenantSummaries["acme"]; summary.CPU.Total != 1 || summary.Memory.Total != 0.5 || summary.Storage.Total != 2 || summary.Ingress.Total != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
+
+func TestGetPrometheusCostSamples(t *testing.T) {
+	pricing := `cpu: 1
+storage:
+  ssd: 0
+schedules:
+  - effective_from: "2026-01-01T12:00:00Z"
+    currency: EUR
+    cpu: 4
+    storage:
+      ssd: 0
+`
+	_, _ = setupPrometheus(t, tenantSeries("acme"), pricing)
+
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples, err := GetCostSamples("acme", from, from.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(samples) != 24 {
+		t.Fatalf("expected a sample each HISTORY_INTERVAL, got %d", len(samples))
+	}
+
+	for _, sample := range samples {
+		if sample.Interval != time.Hour || sample.CPU != 2000 {
+			t.Fatalf("unexpected sample %+v", sample)
+		}
+		// the prices and the currency effective at the timestamp of the sample, 2 cores with 50% discount
+		expectedCurrency, expectedCost := "CHF", 1.0
+		if !sample.Timestamp.Before(from.Add(12 * time.Hour)) {
+			expectedCurrency, expectedCost = "EUR", 4.0
+		}
+		if sample.Currency != expectedCurrency || math.Abs(sample.CPUCost-expectedCost) > 1e-9 || math.Abs(sample.CPUListCost-2*expectedCost) > 1e-9 {
+			t.Errorf("sample at %s: expected %v %s, got %v %s", sample.Timestamp, expectedCost, expectedCurrency, sample.CPUCost, sample.Currency)
+		}
+	}
+
+	// 12h at 1 CHF and 12h at 4 EUR = 8 CHF
+	history, err := GetCostHistory("acme", from, from.Add(24*time.Hour), 24*time.Hour, "CHF")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(history) != 1 || math.Abs(history[0].CPU-(12+12*4/0.5)) > 1e-9 {
+		t.Errorf("unexpected history %+v", history)
+	}
+}
@@ -0,0 +1,504 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ManagedByLabel marks the objects created by the tenant provisioning
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "tenant-api"
+
+	DefaultTenantTemplate = "default"
+
+	ProvisionCreated   = "created"
+	ProvisionUpdated   = "updated"
+	ProvisionUnchanged = "unchanged"
+	ProvisionDeleted   = "deleted"
+	ProvisionRejected  = "rejected"
+	ProvisionRevoked   = "revoked"
+)
+
+var (
+	// TENANT_TEMPLATES_FILE is the YAML or JSON file with the tenant templates keyed by name
+	TENANT_TEMPLATES_FILE string
+	TenantTemplates       map[string]TenantTemplate
+
+	ErrTemplateNotFound     = errors.New("tenant template not found")
+	ErrInvalidProvision     = errors.New("invalid provisioning")
+	ErrTenantNotFound       = errors.New("tenant not found")
+	ErrTenantNotProvisioned = errors.New("tenant was not provisioned by the tenant-api")
+)
+
+// TenantTemplate are the objects created in the namespace of a new tenant, the role binding binds the cluster role
+// to the group of the team, e.g. the GitHub team in the groups claim of the OIDC tokens of the cluster
+type TenantTemplate struct {
+	Labels        map[string]string               `json:"labels"`
+	Annotations   map[string]string               `json:"annotations"`
+	Quota         v1.ResourceList                 `json:"quota"`
+	LimitRange    []v1.LimitRangeItem             `json:"limit_range"`
+	NetworkPolicy *networkingv1.NetworkPolicySpec `json:"network_policy"`
+	ClusterRole   string                          `json:"cluster_role"`
+	GroupPrefix   string                          `json:"group_prefix"`
+}
+
+// TenantProvision is the provisioning or deprovisioning of a tenant in a cluster with the action on each object
+type TenantProvision struct {
+	Tenant   string              `json:"tenant"`
+	Team     string              `json:"team,omitempty"`
+	Cluster  string              `json:"cluster"`
+	Template string              `json:"template,omitempty"`
+	DryRun   bool                `json:"dry_run"`
+	Objects  []ProvisionedObject `json:"objects"`
+}
+
+// ProvisionedObject is an object of a tenant and the action of the provisioning on it
+type ProvisionedObject struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// LoadTenantTemplates loads the templates of the TENANT_TEMPLATES_FILE, the default template is built in unless the file overrides it
+func LoadTenantTemplates() error {
+	templates := map[string]TenantTemplate{
+		DefaultTenantTemplate: defaultTenantTemplate(),
+	}
+
+	if TENANT_TEMPLATES_FILE != "" {
+		data, err := os.ReadFile(TENANT_TEMPLATES_FILE)
+		if err != nil {
+			return err
+		}
+		fileTemplates := make(map[string]TenantTemplate)
+		if err := yaml.UnmarshalStrict(data, &fileTemplates); err != nil {
+			return fmt.Errorf("invalid tenant templates file: %w", err)
+		}
+		for name, template := range fileTemplates {
+			if len(template.Quota) == 0 {
+				return fmt.Errorf("tenant template %s has no quota", name)
+			}
+			templates[name] = template
+		}
+	}
+
+	TenantTemplates = templates
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	InfoLogger.Printf("tenant templates loaded: %s", strings.Join(names, ", "))
+	return nil
+}
+
+// ProvisionTenant creates the namespace of the tenant with the resource quota, limit range, network policy and role binding
+// of the template in the cluster, existing objects are kept so it can be run again, the namespace gets the missing labels
+func ProvisionTenant(ctx context.Context, provision TenantProvision) (TenantProvision, error) {
+	if errs := validation.IsDNS1123Label(provision.Tenant); len(errs) > 0 {
+		return TenantProvision{}, fmt.Errorf("%w, tenant %s: %s", ErrInvalidProvision, provision.Tenant, strings.Join(errs, ", "))
+	}
+	cluster := GetCluster(provision.Cluster)
+	if cluster == nil {
+		return TenantProvision{}, fmt.Errorf("%w, unknown cluster %s", ErrInvalidProvision, provision.Cluster)
+	}
+	template, ok := TenantTemplates[provision.Template]
+	if !ok {
+		return TenantProvision{}, fmt.Errorf("%w %s", ErrTemplateNotFound, provision.Template)
+	}
+	tenantLabels, err := tenantNamespaceLabels()
+	if err != nil {
+		return TenantProvision{}, err
+	}
+
+	createOptions := metav1.CreateOptions{}
+	updateOptions := metav1.UpdateOptions{}
+	if provision.DryRun {
+		createOptions.DryRun = []string{metav1.DryRunAll}
+		updateOptions.DryRun = []string{metav1.DryRunAll}
+	}
+	objectLabels := map[string]string{ManagedByLabel: managedByValue}
+	for key, value := range template.Labels {
+		objectLabels[key] = value
+	}
+	objectMeta := metav1.ObjectMeta{
+		Name:      provision.Tenant,
+		Namespace: provision.Tenant,
+		Labels:    objectLabels,
+	}
+
+	// namespace
+	namespaces := cluster.Clientset.CoreV1().Namespaces()
+	namespaceAction := ProvisionUnchanged
+	namespace, err := namespaces.Get(ctx, provision.Tenant, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		namespace = &v1.Namespace{}
+		namespace.Name = provision.Tenant
+		namespace.Labels = mergeLabels(objectLabels, tenantLabels)
+		namespace.Annotations = template.Annotations
+		if _, err = namespaces.Create(ctx, namespace, createOptions); err != nil {
+			return TenantProvision{}, err
+		}
+		namespaceAction = ProvisionCreated
+	} else if err != nil {
+		return TenantProvision{}, err
+	} else if missing := missingLabels(namespace.Labels, mergeLabels(template.Labels, tenantLabels)); len(missing) > 0 {
+		// an existing namespace is adopted as tenant but not marked as managed by the tenant-api
+		namespace.Labels = mergeLabels(namespace.Labels, missing)
+		if _, err = namespaces.Update(ctx, namespace, updateOptions); err != nil {
+			return TenantProvision{}, err
+		}
+		namespaceAction = ProvisionUpdated
+	}
+	provision.Objects = []ProvisionedObject{{Kind: "Namespace", Name: provision.Tenant, Action: namespaceAction}}
+
+	// the namespaced objects cannot be created in a dry run of a new namespace
+	skipCreate := provision.DryRun && namespaceAction == ProvisionCreated
+	ensure := func(kind string, get func() error, create func() error) error {
+		if !skipCreate {
+			err := get()
+			if err == nil {
+				provision.Objects = append(provision.Objects, ProvisionedObject{Kind: kind, Name: provision.Tenant, Action: ProvisionUnchanged})
+				return nil
+			}
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+			if err := create(); err != nil {
+				return fmt.Errorf("cannot create %s %s: %w", kind, provision.Tenant, err)
+			}
+		}
+		provision.Objects = append(provision.Objects, ProvisionedObject{Kind: kind, Name: provision.Tenant, Action: ProvisionCreated})
+		return nil
+	}
+
+	// resource quota named after the tenant
+	quotas := cluster.Clientset.CoreV1().ResourceQuotas(provision.Tenant)
+	err = ensure("ResourceQuota", func() error {
+		_, err := quotas.Get(ctx, provision.Tenant, metav1.GetOptions{})
+		return err
+	}, func() error {
+		_, err := quotas.Create(ctx, &v1.ResourceQuota{ObjectMeta: objectMeta, Spec: v1.ResourceQuotaSpec{Hard: template.Quota}}, createOptions)
+		return err
+	})
+	if err != nil {
+		return TenantProvision{}, err
+	}
+
+	if len(template.LimitRange) > 0 {
+		limitRanges := cluster.Clientset.CoreV1().LimitRanges(provision.Tenant)
+		err = ensure("LimitRange", func() error {
+			_, err := limitRanges.Get(ctx, provision.Tenant, metav1.GetOptions{})
+			return err
+		}, func() error {
+			_, err := limitRanges.Create(ctx, &v1.LimitRange{ObjectMeta: objectMeta, Spec: v1.LimitRangeSpec{Limits: template.LimitRange}}, createOptions)
+			return err
+		})
+		if err != nil {
+			return TenantProvision{}, err
+		}
+	}
+
+	if template.NetworkPolicy != nil {
+		networkPolicies := cluster.Clientset.NetworkingV1().NetworkPolicies(provision.Tenant)
+		err = ensure("NetworkPolicy", func() error {
+			_, err := networkPolicies.Get(ctx, provision.Tenant, metav1.GetOptions{})
+			return err
+		}, func() error {
+			_, err := networkPolicies.Create(ctx, &networkingv1.NetworkPolicy{ObjectMeta: objectMeta, Spec: *template.NetworkPolicy}, createOptions)
+			return err
+		})
+		if err != nil {
+			return TenantProvision{}, err
+		}
+	}
+
+	if template.ClusterRole != "" && provision.Team != "" {
+		roleBindings := cluster.Clientset.RbacV1().RoleBindings(provision.Tenant)
+		err = ensure("RoleBinding", func() error {
+			_, err := roleBindings.Get(ctx, provision.Tenant, metav1.GetOptions{})
+			return err
+		}, func() error {
+			_, err := roleBindings.Create(ctx, &rbacv1.RoleBinding{
+				ObjectMeta: objectMeta,
+				Subjects: []rbacv1.Subject{{
+					Kind:     rbacv1.GroupKind,
+					APIGroup: rbacv1.GroupName,
+					Name:     template.GroupPrefix + provision.Team,
+				}},
+				RoleRef: rbacv1.RoleRef{
+					Kind:     "ClusterRole",
+					APIGroup: rbacv1.GroupName,
+					Name:     template.ClusterRole,
+				},
+			}, createOptions)
+			return err
+		})
+		if err != nil {
+			return TenantProvision{}, err
+		}
+	}
+
+	return provision, nil
+}
+
+// DeprovisionTenant deletes the namespace of the tenant with all its objects in the cluster and returns the deleted objects,
+// only namespaces created by the provisioning are deleted
+func DeprovisionTenant(ctx context.Context, provision TenantProvision, actor string, now time.Time) (TenantProvision, error) {
+	cluster := GetCluster(provision.Cluster)
+	if cluster == nil {
+		return TenantProvision{}, fmt.Errorf("%w, unknown cluster %s", ErrInvalidProvision, provision.Cluster)
+	}
+
+	namespaces := cluster.Clientset.CoreV1().Namespaces()
+	namespace, err := namespaces.Get(ctx, provision.Tenant, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return TenantProvision{}, ErrTenantNotFound
+	}
+	if err != nil {
+		return TenantProvision{}, err
+	}
+	if namespace.Labels[ManagedByLabel] != managedByValue {
+		return TenantProvision{}, ErrTenantNotProvisioned
+	}
+
+	objects, err := cluster.listTenantObjects(ctx, provision.Tenant)
+	if err != nil {
+		return TenantProvision{}, err
+	}
+	provision.Objects = append([]ProvisionedObject{{Kind: "Namespace", Name: provision.Tenant, Action: ProvisionDeleted}}, objects...)
+
+	deleteOptions := metav1.DeleteOptions{}
+	if provision.DryRun {
+		deleteOptions.DryRun = []string{metav1.DryRunAll}
+	}
+	// the objects of the namespace are deleted with it
+	if err := namespaces.Delete(ctx, provision.Tenant, deleteOptions); err != nil {
+		return TenantProvision{}, err
+	}
+
+	// the stored data of the tenant is kept as long as the tenant has a namespace in another cluster
+	for _, other := range Clusters {
+		if other == cluster {
+			continue
+		}
+		_, err := other.NamespaceLister.Get(provision.Tenant)
+		if err == nil {
+			return provision, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return TenantProvision{}, err
+		}
+	}
+
+	storedObjects, err := deprovisionTenantData(provision.Tenant, actor, provision.DryRun, now)
+	if err != nil {
+		return TenantProvision{}, err
+	}
+	provision.Objects = append(provision.Objects, storedObjects...)
+
+	return provision, nil
+}
+
+// deprovisionTenantData deletes the budget and the API tokens of the tenant, rejects its pending quota requests
+// and revokes the sessions with access to it, the dry run only returns them. The invoices and the cost history are kept
+func deprovisionTenantData(tenant string, actor string, dryRun bool, now time.Time) ([]ProvisionedObject, error) {
+	objects := make([]ProvisionedObject, 0)
+
+	if _, err := GetBudget(tenant); err == nil {
+		if !dryRun {
+			if err := DeleteBudget(tenant); err != nil && !errors.Is(err, ErrBudgetNotFound) {
+				return nil, err
+			}
+		}
+		objects = append(objects, ProvisionedObject{Kind: "Budget", Name: tenant, Action: ProvisionDeleted})
+	} else if !errors.Is(err, ErrBudgetNotFound) {
+		return nil, err
+	}
+
+	tokens, err := DeleteTenantAPITokens(tenant, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		objects = append(objects, ProvisionedObject{Kind: "APIToken", Name: token.ID, Action: ProvisionDeleted})
+	}
+
+	requests, err := RejectTenantQuotaRequests(tenant, actor, "tenant deprovisioned", dryRun, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, request := range requests {
+		objects = append(objects, ProvisionedObject{Kind: "QuotaRequest", Name: request.ID, Action: ProvisionRejected})
+	}
+
+	sessions, err := RevokeTenantSessions(tenant, dryRun, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		objects = append(objects, ProvisionedObject{Kind: "Session", Name: session.Provider + "/" + session.Subject, Action: ProvisionRevoked})
+	}
+
+	return objects, nil
+}
+
+// RecordProvisionAuditEvent records the provisioning or deprovisioning of a tenant in the audit trail
+func RecordProvisionAuditEvent(provision TenantProvision, action string, actor string, now time.Time) error {
+	details := map[string]string{
+		"cluster": provision.Cluster,
+	}
+	if provision.Team != "" {
+		details["team"] = provision.Team
+	}
+	if provision.Template != "" {
+		details["template"] = provision.Template
+	}
+	for _, object := range provision.Objects {
+		details[object.Kind+"/"+object.Name] = object.Action
+	}
+	return RecordAuditEvent(AuditEvent{
+		Timestamp: now,
+		Actor:     actor,
+		Action:    action,
+		Tenant:    provision.Tenant,
+		Target:    provision.Tenant,
+		Details:   details,
+	})
+}
+
+// listTenantObjects returns the objects of the namespace which are deleted with it
+func (cluster *Cluster) listTenantObjects(ctx context.Context, namespace string) ([]ProvisionedObject, error) {
+	objects := make([]ProvisionedObject, 0)
+	add := func(kind string, names []string) {
+		sort.Strings(names)
+		for _, name := range names {
+			objects = append(objects, ProvisionedObject{Kind: kind, Name: name, Action: ProvisionDeleted})
+		}
+	}
+
+	core := cluster.Clientset.CoreV1()
+	pods, err := core.Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	add("Pod", names)
+
+	pvcs, err := core.PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names = make([]string, 0, len(pvcs.Items))
+	for _, pvc := range pvcs.Items {
+		names = append(names, pvc.Name)
+	}
+	add("PersistentVolumeClaim", names)
+
+	ingresses, err := cluster.Clientset.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names = make([]string, 0, len(ingresses.Items))
+	for _, ingress := range ingresses.Items {
+		names = append(names, ingress.Name)
+	}
+	add("Ingress", names)
+
+	quotas, err := core.ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names = make([]string, 0, len(quotas.Items))
+	for _, quota := range quotas.Items {
+		names = append(names, quota.Name)
+	}
+	add("ResourceQuota", names)
+
+	return objects, nil
+}
+
+// tenantNamespaceLabels returns the labels which make a namespace a tenant with the TENANT_LABEL key or selector
+func tenantNamespaceLabels() (map[string]string, error) {
+	if !strings.ContainsAny(TENANT_LABEL, "=!(),") {
+		return map[string]string{TENANT_LABEL: "true"}, nil
+	}
+	tenantLabels, err := labels.ConvertSelectorToLabelsMap(TENANT_LABEL)
+	if err != nil {
+		return nil, fmt.Errorf("%w, TENANT_LABEL %s is no key or equality selector", ErrInvalidProvision, TENANT_LABEL)
+	}
+	return tenantLabels, nil
+}
+
+// mergeLabels returns the labels of a with the labels of b, b takes precedence
+func mergeLabels(a map[string]string, b map[string]string) map[string]string {
+	merged := make(map[string]string, len(a)+len(b))
+	for key, value := range a {
+		merged[key] = value
+	}
+	for key, value := range b {
+		merged[key] = value
+	}
+	return merged
+}
+
+// missingLabels returns the required labels which are not set to their value in the labels
+func missingLabels(current map[string]string, required map[string]string) map[string]string {
+	missing := make(map[string]string)
+	for key, value := range required {
+		if current[key] != value {
+			missing[key] = value
+		}
+	}
+	return missing
+}
+
+// defaultTenantTemplate is the built-in template with a small quota, default container resources,
+// a network policy which only allows ingress from the namespace and the edit cluster role for the team
+func defaultTenantTemplate() TenantTemplate {
+	return TenantTemplate{
+		Quota: v1.ResourceList{
+			v1.ResourceRequestsCPU:     resource.MustParse("2"),
+			v1.ResourceRequestsMemory:  resource.MustParse("4Gi"),
+			v1.ResourceRequestsStorage: resource.MustParse("20Gi"),
+			v1.ResourcePods:            resource.MustParse("50"),
+		},
+		LimitRange: []v1.LimitRangeItem{{
+			Type: v1.LimitTypeContainer,
+			DefaultRequest: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("100m"),
+				v1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+			Default: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("500m"),
+				v1.ResourceMemory: resource.MustParse("512Mi"),
+			},
+		}},
+		NetworkPolicy: &networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+			}},
+		},
+		ClusterRole: "edit",
+	}
+}
@@ -0,0 +1,231 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeKubernetes serves a provisioned namespace with a pod and records the deletions of the namespace
+type fakeKubernetes struct {
+	namespace string
+	mutex     sync.Mutex
+	deletions []string
+}
+
+func (kubernetes *fakeKubernetes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	namespacePath := "/api/v1/namespaces/" + kubernetes.namespace
+
+	switch {
+	case r.URL.Path == namespacePath && r.Method == http.MethodGet:
+		fmt.Fprintf(w, `{"kind":"Namespace","apiVersion":"v1","metadata":{"name":%q,"labels":{%q:%q}}}`, kubernetes.namespace, ManagedByLabel, managedByValue)
+	case r.URL.Path == namespacePath && r.Method == http.MethodDelete:
+		var options metav1.DeleteOptions
+		json.NewDecoder(r.Body).Decode(&options)
+		kubernetes.mutex.Lock()
+		kubernetes.deletions = append(kubernetes.deletions, strings.Join(options.DryRun, ","))
+		kubernetes.mutex.Unlock()
+		fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+	case r.URL.Path == namespacePath+"/pods":
+		fmt.Fprintf(w, `{"kind":"PodList","apiVersion":"v1","items":[{"metadata":{"name":"web","namespace":%q}}]}`, kubernetes.namespace)
+	case r.URL.Path == namespacePath+"/persistentvolumeclaims":
+		fmt.Fprint(w, `{"kind":"PersistentVolumeClaimList","apiVersion":"v1","items":[]}`)
+	case r.URL.Path == namespacePath+"/resourcequotas":
+		fmt.Fprintf(w, `{"kind":"ResourceQuotaList","apiVersion":"v1","items":[{"metadata":{"name":%q,"namespace":%q}}]}`, kubernetes.namespace, kubernetes.namespace)
+	case r.URL.Path == "/apis/networking.k8s.io/v1/namespaces/"+kubernetes.namespace+"/ingresses":
+		fmt.Fprint(w, `{"kind":"IngressList","apiVersion":"networking.k8s.io/v1","items":[]}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+	}
+}
+
+// setupDeprovision uses a cluster with the provisioned tenant and the globex tenant and stores a budget, an API token, a pending quota request,
+// a session, the cost history and a closed invoice of the tenant
+func setupDeprovision(t *testing.T, tenant string) (*fakeKubernetes, *Cluster, time.Time) {
+	t.Helper()
+	setupStore(t)
+	setupSecretKey(t)
+	cluster := setupCluster(t, "cpu: 1\n", tenantNamespace(tenant), runningPod(tenant, "web", "1", "1Gi"), tenantNamespace("globex"))
+
+	kube := &fakeKubernetes{namespace: tenant}
+	server := httptest.NewServer(kube)
+	t.Cleanup(server.Close)
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cluster.Clientset = clientset
+
+	previousDefaultRoles := DEFAULT_ROLES
+	t.Cleanup(func() { DEFAULT_ROLES = previousDefaultRoles })
+	DEFAULT_ROLES = []Role{RoleViewer}
+
+	// the history covers the last month
+	now := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	for timestamp := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC); timestamp.Before(now); timestamp = timestamp.Add(HISTORY_INTERVAL) {
+		if err := RecordCostSamples(timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if _, err := CloseInvoice(tenant, "2026-02", now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := SetBudget(Budget{Tenant: tenant, Amount: 100}, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, _, err := CreateAPIToken(tenant, "ci", []string{"read:costs"}, "jane", nil, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := CreateQuotaRequest(QuotaRequest{Tenant: tenant, Hard: map[string]string{"requests.cpu": "4"}, RequestedBy: "jane"}, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, _, err := CreateSession(Identity{Provider: "github", Subject: "jane", Groups: []string{tenant}}, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return kube, cluster, now
+}
+
+func TestDeprovisionTenantDryRun(t *testing.T) {
+	kube, _, now := setupDeprovision(t, "acme")
+
+	provision, err := DeprovisionTenant(context.Background(), TenantProvision{Tenant: "acme", Cluster: "prod", DryRun: true}, "admin", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(kube.deletions) != 1 || kube.deletions[0] != "All" {
+		t.Errorf("expected a dry run deletion of the namespace, got %v", kube.deletions)
+	}
+
+	actions := make(map[string]string)
+	for _, object := range provision.Objects {
+		actions[object.Kind] = object.Action
+	}
+	expected := map[string]string{
+		"Namespace":     ProvisionDeleted,
+		"Pod":           ProvisionDeleted,
+		"ResourceQuota": ProvisionDeleted,
+		"Budget":        ProvisionDeleted,
+		"APIToken":      ProvisionDeleted,
+		"QuotaRequest":  ProvisionRejected,
+		"Session":       ProvisionRevoked,
+	}
+	for kind, action := range expected {
+		if actions[kind] != action {
+			t.Errorf("expected %s to be %s, got %q", kind, action, actions[kind])
+		}
+	}
+	if _, ok := actions["Invoice"]; ok {
+		t.Errorf("expected the invoices to be kept, got %v", provision.Objects)
+	}
+
+	// nothing is changed
+	if _, err := GetBudget("acme"); err != nil {
+		t.Errorf("expected the budget to be kept, got %v", err)
+	}
+	if tokens, _ := GetAPITokens("acme"); len(tokens) != 1 {
+		t.Errorf("expected the API token to be kept, got %v", tokens)
+	}
+	if requests, _ := GetQuotaRequests("acme", QuotaRequestPending); len(requests) != 1 {
+		t.Errorf("expected the quota request to be pending, got %v", requests)
+	}
+	if sessions, _ := RevokeTenantSessions("acme", true, now); len(sessions) != 1 {
+		t.Errorf("expected the session to be kept, got %v", sessions)
+	}
+}
+
+func TestDeprovisionTenant(t *testing.T) {
+	kube, cluster, now := setupDeprovision(t, "acme")
+
+	if _, err := DeprovisionTenant(context.Background(), TenantProvision{Tenant: "acme", Cluster: "prod"}, "admin", now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(kube.deletions) != 1 || kube.deletions[0] != "" {
+		t.Errorf("expected a deletion of the namespace, got %v", kube.deletions)
+	}
+
+	// the budget and the API tokens are deleted, the quota requests rejected and the sessions revoked
+	if _, err := GetBudget("acme"); !errors.Is(err, ErrBudgetNotFound) {
+		t.Errorf("expected the budget to be deleted, got %v", err)
+	}
+	if tokens, _ := GetAPITokens("acme"); len(tokens) != 0 {
+		t.Errorf("expected the API tokens to be deleted, got %v", tokens)
+	}
+	requests, _ := GetQuotaRequests("acme", "")
+	if len(requests) != 1 || requests[0].Status != QuotaRequestRejected || requests[0].DecidedBy != "admin" {
+		t.Errorf("expected the quota request to be rejected, got %v", requests)
+	}
+	if sessions, _ := RevokeTenantSessions("acme", true, now); len(sessions) != 0 {
+		t.Errorf("expected the sessions to be revoked, got %v", sessions)
+	}
+
+	// the invoices and the cost history are kept
+	if invoices, _ := GetInvoices("acme"); len(invoices) != 1 || invoices[0].ID != "2026-02" {
+		t.Errorf("expected the invoice to be kept, got %v", invoices)
+	}
+	if samples, _ := GetCostSamples("acme", now.AddDate(0, 0, -1), now); len(samples) == 0 {
+		t.Error("expected the cost history to be kept")
+	}
+
+	// the deprovisioned tenant gets the invoice of the month of the deprovisioning
+	cluster.NamespaceLister = setupCluster(t, "cpu: 1\n", tenantNamespace("globex")).NamespaceLister
+	Clusters = []*Cluster{cluster}
+	for timestamp := now; timestamp.Before(time.Date(2026, 4, 1, 1, 0, 0, 0, time.UTC)); timestamp = timestamp.Add(HISTORY_INTERVAL) {
+		if err := RecordCostSamples(timestamp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := CloseLastMonthInvoices(time.Date(2026, 4, 1, 1, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	invoices, _ := GetInvoices("acme")
+	if len(invoices) != 2 || invoices[1].ID != "2026-03" || invoices[1].Total <= 0 {
+		t.Errorf("expected a final invoice of the deprovisioned tenant, got %v", invoices)
+	}
+}
+
+func TestGetInvoiceTenants(t *testing.T) {
+	setupStore(t)
+	setupCluster(t, "cpu: 1\n", tenantNamespace("acme"))
+
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	if err := DB.Update(func(tx *bolt.Tx) error {
+		tenantHistory, err := tx.Bucket([]byte(historyBucket)).CreateBucketIfNotExists([]byte("globex"))
+		if err != nil {
+			return err
+		}
+		return putJSON(tenantHistory, historyKey(from.Add(time.Hour), "prod"), CostSample{Tenant: "globex"})
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// deprovisioned with DATASOURCE prometheus without recorded samples
+	if err := RecordProvisionAuditEvent(TenantProvision{Tenant: "initech", Cluster: "prod"}, "tenant.deprovisioned", "admin", from.Add(48*time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := RecordProvisionAuditEvent(TenantProvision{Tenant: "hooli", Cluster: "prod"}, "tenant.deprovisioned", "admin", from.Add(-time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tenants, err := getInvoiceTenants(from, to)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Join(tenants, ",") != "acme,globex,initech" {
+		t.Errorf("expected the tenants in the cluster, with history and deprovisioned in the period, got %v", tenants)
+	}
+}
@@ -1,21 +1,159 @@
 package util
 
 import (
-	"context"
+	"sort"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-// GetRessourceQuota returns the resource quota for the given tenant and label set in the config namespace
+// storageClassQuotaSuffix is the suffix of the storage request quota of a storage class
+const storageClassQuotaSuffix = ".storageclass.storage.k8s.io/requests.storage"
+
+// QuotaUsage is the hard limit and the usage of a resource, cpu resources are in millicores and the others in their base unit
+type QuotaUsage struct {
+	Hard      int64   `json:"hard"`
+	Used      int64   `json:"used"`
+	Remaining int64   `json:"remaining"`
+	Percent   float64 `json:"percent"`
+}
+
+// TenantQuotaUsage is the usage of every resource of the resource quotas of a tenant and the storage usage of each storage class
+type TenantQuotaUsage struct {
+	Resources map[string]QuotaUsage `json:"resources"`
+	Storage   map[string]QuotaUsage `json:"storage"`
+}
+
+// GetRessourceQuota returns the resource quota for the given tenant with the hard limits and the usage summed over all clusters
 func GetRessourceQuota(tenant string) (v1.ResourceQuota, error) {
-	// get resource quota in namespace "test-tenant-config"
+	clusterQuotas, err := GetRessourceQuotaByCluster(tenant)
+	if err != nil {
+		return v1.ResourceQuota{}, err
+	}
+
+	quota := v1.ResourceQuota{}
+	quota.Name = tenant
+	quota.Namespace = tenant
+	quota.Spec.Hard = make(v1.ResourceList)
+	quota.Status.Hard = make(v1.ResourceList)
+	quota.Status.Used = make(v1.ResourceList)
+	for _, clusterQuota := range clusterQuotas {
+		addResourceList(quota.Spec.Hard, clusterQuota.Spec.Hard)
+		addResourceList(quota.Status.Hard, clusterQuota.Status.Hard)
+		addResourceList(quota.Status.Used, clusterQuota.Status.Used)
+	}
+
+	return quota, nil
+}
+
+// GetRessourceQuotaByCluster returns the resource quota for the given tenant keyed by cluster, clusters without the quota are omitted
+func GetRessourceQuotaByCluster(tenant string) (map[string]v1.ResourceQuota, error) {
+	clusterQuotas := make(map[string]v1.ResourceQuota)
+	for _, cluster := range Clusters {
+		quota, err := cluster.GetRessourceQuota(tenant)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		clusterQuotas[cluster.Name] = quota
+	}
+
+	if len(clusterQuotas) == 0 {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "resourcequotas"}, tenant)
+	}
+
+	return clusterQuotas, nil
+}
 
-	// get resource quota from namespace
-	quota, err := Clientset.CoreV1().ResourceQuotas(tenant).Get(context.TODO(), tenant, metav1.GetOptions{})
+// GetRessourceQuota returns the effective resource quota of all resource quotas in the namespace of the tenant,
+// a resource limited by several quotas takes the hard limit and usage of the quota with the least remaining
+func (cluster *Cluster) GetRessourceQuota(tenant string) (v1.ResourceQuota, error) {
+	// get the resource quotas from the informer cache of the namespace
+	quotas, err := cluster.ResourceQuotaLister.ResourceQuotas(tenant).List(labels.Everything())
 	if err != nil {
 		return v1.ResourceQuota{}, err
 	}
+	if len(quotas) == 0 {
+		return v1.ResourceQuota{}, apierrors.NewNotFound(schema.GroupResource{Resource: "resourcequotas"}, tenant)
+	}
+
+	// merge in a stable order
+	sort.Slice(quotas, func(i, j int) bool {
+		return quotas[i].Name < quotas[j].Name
+	})
+
+	merged := v1.ResourceQuota{}
+	merged.Name = tenant
+	merged.Namespace = tenant
+	merged.Spec.Hard = make(v1.ResourceList)
+	merged.Status.Hard = make(v1.ResourceList)
+	merged.Status.Used = make(v1.ResourceList)
+	for _, quota := range quotas {
+		for resourceName, hard := range quota.Spec.Hard {
+			used := quota.Status.Used[resourceName]
+			if mergedHard, ok := merged.Spec.Hard[resourceName]; ok {
+				mergedUsed := merged.Status.Used[resourceName]
+				if quantityRemaining(mergedHard, mergedUsed) <= quantityRemaining(hard, used) {
+					continue
+				}
+			}
+			merged.Spec.Hard[resourceName] = hard.DeepCopy()
+			merged.Status.Hard[resourceName] = hard.DeepCopy()
+			merged.Status.Used[resourceName] = used.DeepCopy()
+		}
+	}
+
+	return merged, nil
+}
+
+// GetQuotaUsage returns the usage of every resource of the resource quota and the storage usage of each storage class
+func GetQuotaUsage(quota v1.ResourceQuota) TenantQuotaUsage {
+	usage := TenantQuotaUsage{
+		Resources: make(map[string]QuotaUsage),
+		Storage:   make(map[string]QuotaUsage),
+	}
+	for resourceName, hard := range quota.Spec.Hard {
+		quotaUsage := newQuotaUsage(resourceName, hard, quota.Status.Used[resourceName])
+		usage.Resources[string(resourceName)] = quotaUsage
+		if strings.HasSuffix(string(resourceName), storageClassQuotaSuffix) {
+			usage.Storage[strings.TrimSuffix(string(resourceName), storageClassQuotaSuffix)] = quotaUsage
+		}
+	}
+	return usage
+}
+
+// newQuotaUsage returns the usage of the resource, a resource with a hard limit of zero is fully used
+func newQuotaUsage(resourceName v1.ResourceName, hard resource.Quantity, used resource.Quantity) QuotaUsage {
+	usage := QuotaUsage{
+		Hard: quantityValue(resourceName, hard),
+		Used: quantityValue(resourceName, used),
+	}
+	if usage.Remaining = usage.Hard - usage.Used; usage.Remaining < 0 {
+		usage.Remaining = 0
+	}
+	if usage.Hard > 0 {
+		usage.Percent = float64(usage.Used) / float64(usage.Hard) * 100
+	} else {
+		usage.Percent = 100
+	}
+	return usage
+}
+
+// quantityValue returns cpu quantities in millicores and the other quantities in their base unit
+func quantityValue(resourceName v1.ResourceName, quantity resource.Quantity) int64 {
+	if resourceName == v1.ResourceCPU || strings.HasSuffix(string(resourceName), ".cpu") {
+		return quantity.MilliValue()
+	}
+	return quantity.Value()
+}
 
-	return *quota, nil
+// quantityRemaining returns the remaining quantity in milli units to compare resources of every unit
+func quantityRemaining(hard resource.Quantity, used resource.Quantity) int64 {
+	return hard.MilliValue() - used.MilliValue()
 }
@@ -0,0 +1,442 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	quotaRequestBucket = "quota_requests"
+
+	QuotaRequestPending   = "pending"
+	QuotaRequestApproving = "approving"
+	QuotaRequestApproved  = "approved"
+	QuotaRequestRejected  = "rejected"
+
+	// quotaRequestClaimTimeout is the time after which the claim of an approval is stale, e.g. of a crashed approval
+	quotaRequestClaimTimeout = 5 * time.Minute
+
+	storageClassClaimsQuotaSuffix = ".storageclass.storage.k8s.io/persistentvolumeclaims"
+)
+
+var (
+	ErrQuotaRequestNotFound = errors.New("quota request not found")
+	ErrQuotaRequestDecided  = errors.New("quota request is already decided")
+	ErrInvalidQuotaRequest  = errors.New("invalid quota request")
+
+	// quotaResources are the standard resources of a resource quota
+	quotaResources = map[v1.ResourceName]bool{
+		v1.ResourceCPU:                      true,
+		v1.ResourceMemory:                   true,
+		v1.ResourceEphemeralStorage:         true,
+		v1.ResourceRequestsCPU:              true,
+		v1.ResourceRequestsMemory:           true,
+		v1.ResourceRequestsStorage:          true,
+		v1.ResourceRequestsEphemeralStorage: true,
+		v1.ResourceLimitsCPU:                true,
+		v1.ResourceLimitsMemory:             true,
+		v1.ResourceLimitsEphemeralStorage:   true,
+		v1.ResourcePods:                     true,
+		v1.ResourceServices:                 true,
+		v1.ResourceServicesLoadBalancers:    true,
+		v1.ResourceServicesNodePorts:        true,
+		v1.ResourcePersistentVolumeClaims:   true,
+		v1.ResourceConfigMaps:               true,
+		v1.ResourceSecrets:                  true,
+		v1.ResourceReplicationControllers:   true,
+		v1.ResourceQuotas:                   true,
+	}
+)
+
+// QuotaRequest is a change of the hard limits of a resource quota of a tenant proposed by the tenant
+// and approved or rejected by a platform admin, an approved change is applied to the cluster
+type QuotaRequest struct {
+	ID          string            `json:"id"`
+	Tenant      string            `json:"tenant"`
+	Cluster     string            `json:"cluster"`
+	Quota       string            `json:"quota"`
+	Hard        map[string]string `json:"hard"`
+	Reason      string            `json:"reason"`
+	Status      string            `json:"status"`
+	RequestedBy string            `json:"requested_by"`
+	RequestedAt time.Time         `json:"requested_at"`
+	DecidedBy   string            `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time        `json:"decided_at,omitempty"`
+	Comment     string            `json:"comment,omitempty"`
+	// time at which an admin claimed the request to apply it
+	ClaimedAt *time.Time `json:"claimed_at,omitempty"`
+	// hard limits of the resource quota before the change was applied
+	PreviousHard map[string]string `json:"previous_hard,omitempty"`
+}
+
+// CreateQuotaRequest validates and stores a pending quota request of the tenant, the cluster defaults to the first cluster
+// and the resource quota to the one named after the tenant
+func CreateQuotaRequest(request QuotaRequest, now time.Time) (QuotaRequest, error) {
+	if request.Cluster == "" {
+		request.Cluster = Clusters[0].Name
+	}
+	if GetCluster(request.Cluster) == nil {
+		return QuotaRequest{}, fmt.Errorf("%w, unknown cluster %s", ErrInvalidQuotaRequest, request.Cluster)
+	}
+	if request.Quota == "" {
+		request.Quota = request.Tenant
+	}
+
+	if len(request.Hard) == 0 {
+		return QuotaRequest{}, fmt.Errorf("%w, at least one hard limit is required", ErrInvalidQuotaRequest)
+	}
+	if _, err := parseHardLimits(request.Hard); err != nil {
+		return QuotaRequest{}, err
+	}
+
+	id, err := randomToken(12)
+	if err != nil {
+		return QuotaRequest{}, err
+	}
+	request.ID = id
+	request.Status = QuotaRequestPending
+	request.RequestedAt = now.UTC()
+	request.DecidedBy = ""
+	request.DecidedAt = nil
+	request.Comment = ""
+	request.ClaimedAt = nil
+	request.PreviousHard = nil
+
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket([]byte(quotaRequestBucket)), id, request)
+	})
+	if err != nil {
+		return QuotaRequest{}, err
+	}
+
+	return request, RecordAuditEvent(AuditEvent{
+		Timestamp: now,
+		Actor:     request.RequestedBy,
+		Action:    "quota_request.created",
+		Tenant:    request.Tenant,
+		Target:    request.ID,
+		Details:   auditHardLimits(request),
+	})
+}
+
+// GetQuotaRequests returns the quota requests of the tenant or of all tenants if tenant is empty ordered by their creation,
+// filtered by the status if it is set
+func GetQuotaRequests(tenant string, status string) ([]QuotaRequest, error) {
+	requests := make([]QuotaRequest, 0)
+	err := DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(quotaRequestBucket)).ForEach(func(_, value []byte) error {
+			var request QuotaRequest
+			if err := json.Unmarshal(value, &request); err != nil {
+				return err
+			}
+			if (tenant == "" || request.Tenant == tenant) && (status == "" || request.Status == status) {
+				requests = append(requests, request)
+			}
+			return nil
+		})
+	})
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].RequestedAt.Before(requests[j].RequestedAt)
+	})
+	return requests, err
+}
+
+// GetQuotaRequest returns the quota request of the tenant with the id
+func GetQuotaRequest(tenant string, id string) (QuotaRequest, error) {
+	var request QuotaRequest
+	err := DB.View(func(tx *bolt.Tx) error {
+		var err error
+		request, err = getQuotaRequest(tx.Bucket([]byte(quotaRequestBucket)), tenant, id)
+		return err
+	})
+	return request, err
+}
+
+// ApproveQuotaRequest claims the pending quota request, applies its hard limits to the resource quota in the cluster and approves it,
+// the request is pending again if the change cannot be applied
+func ApproveQuotaRequest(ctx context.Context, tenant string, id string, decidedBy string, comment string, now time.Time) (QuotaRequest, error) {
+	// only one admin applies the request, the others get ErrQuotaRequestDecided
+	request, err := claimQuotaRequest(tenant, id, now)
+	if err != nil {
+		return QuotaRequest{}, err
+	}
+
+	previousHard, err := applyQuotaRequest(ctx, request)
+	if err != nil {
+		if releaseErr := releaseQuotaRequest(request); releaseErr != nil {
+			ErrorLogger.Printf("cannot release quota request %s: %s", id, releaseErr)
+		}
+		return QuotaRequest{}, err
+	}
+
+	request.PreviousHard = make(map[string]string, len(previousHard))
+	for resourceName, quantity := range previousHard {
+		request.PreviousHard[string(resourceName)] = quantity.String()
+	}
+	return decideQuotaRequest(request, QuotaRequestApproving, QuotaRequestApproved, decidedBy, comment, now)
+}
+
+// RejectQuotaRequest rejects the pending quota request or the request with a stale claim without changing the resource quota
+func RejectQuotaRequest(tenant string, id string, decidedBy string, comment string, now time.Time) (QuotaRequest, error) {
+	request, err := GetQuotaRequest(tenant, id)
+	if err != nil {
+		return QuotaRequest{}, err
+	}
+	if request.Status != QuotaRequestPending && !request.isStaleClaim(now) {
+		return QuotaRequest{}, ErrQuotaRequestDecided
+	}
+	return decideQuotaRequest(request, request.Status, QuotaRequestRejected, decidedBy, comment, now)
+}
+
+// RejectTenantQuotaRequests rejects the pending quota requests of the tenant and returns them, the dry run only returns them
+func RejectTenantQuotaRequests(tenant string, decidedBy string, comment string, dryRun bool, now time.Time) ([]QuotaRequest, error) {
+	requests, err := GetQuotaRequests(tenant, QuotaRequestPending)
+	if err != nil || dryRun {
+		return requests, err
+	}
+
+	rejected := make([]QuotaRequest, 0, len(requests))
+	for _, request := range requests {
+		request, err := decideQuotaRequest(request, QuotaRequestPending, QuotaRequestRejected, decidedBy, comment, now)
+		// decided by an admin in the meantime
+		if errors.Is(err, ErrQuotaRequestDecided) {
+			continue
+		}
+		if err != nil {
+			return rejected, err
+		}
+		rejected = append(rejected, request)
+	}
+	return rejected, nil
+}
+
+// applyQuotaRequest sets the hard limits of the quota request on the resource quota and returns the hard limits before the change
+func applyQuotaRequest(ctx context.Context, request QuotaRequest) (v1.ResourceList, error) {
+	cluster := GetCluster(request.Cluster)
+	if cluster == nil {
+		return nil, fmt.Errorf("%w, unknown cluster %s", ErrInvalidQuotaRequest, request.Cluster)
+	}
+	hard, err := parseHardLimits(request.Hard)
+	if err != nil {
+		return nil, err
+	}
+	previousHard, err := cluster.applyResourceQuota(ctx, request.Tenant, request.Quota, hard)
+	if err != nil {
+		return nil, fmt.Errorf("cannot apply quota request %s: %w", request.ID, err)
+	}
+	return previousHard, nil
+}
+
+// claimQuotaRequest claims the pending quota request for its approval with the status approving and the time of the claim
+// and returns the claimed request, a stale claim is claimed again. ErrQuotaRequestDecided if it is decided or claimed
+func claimQuotaRequest(tenant string, id string, now time.Time) (QuotaRequest, error) {
+	var request QuotaRequest
+	err := DB.Update(func(tx *bolt.Tx) error {
+		requests := tx.Bucket([]byte(quotaRequestBucket))
+		var err error
+		if request, err = getQuotaRequest(requests, tenant, id); err != nil {
+			return err
+		}
+		if request.Status != QuotaRequestPending && !request.isStaleClaim(now) {
+			return ErrQuotaRequestDecided
+		}
+		claimedAt := now.UTC()
+		request.Status = QuotaRequestApproving
+		request.ClaimedAt = &claimedAt
+		return putJSON(requests, request.ID, request)
+	})
+	if err != nil {
+		return QuotaRequest{}, err
+	}
+	return request, nil
+}
+
+// releaseQuotaRequest sets the claimed quota request pending again, ErrQuotaRequestDecided if it was claimed again in the meantime
+func releaseQuotaRequest(request QuotaRequest) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		requests := tx.Bucket([]byte(quotaRequestBucket))
+		stored, err := getQuotaRequest(requests, request.Tenant, request.ID)
+		if err != nil {
+			return err
+		}
+		if stored.Status != QuotaRequestApproving || !stored.claimTime().Equal(request.claimTime()) {
+			return ErrQuotaRequestDecided
+		}
+		stored.Status = QuotaRequestPending
+		stored.ClaimedAt = nil
+		return putJSON(requests, stored.ID, stored)
+	})
+}
+
+// isStaleClaim returns true if the request is approving for longer than quotaRequestClaimTimeout,
+// requests claimed before the claims had a time are stale
+func (request QuotaRequest) isStaleClaim(now time.Time) bool {
+	return request.Status == QuotaRequestApproving && (request.ClaimedAt == nil || now.Sub(*request.ClaimedAt) > quotaRequestClaimTimeout)
+}
+
+// claimTime returns the time of the claim of the request, zero if it is not claimed
+func (request QuotaRequest) claimTime() time.Time {
+	if request.ClaimedAt == nil {
+		return time.Time{}
+	}
+	return *request.ClaimedAt
+}
+
+// decideQuotaRequest stores the decision of the quota request if it still has the status from and records it in the audit trail
+func decideQuotaRequest(request QuotaRequest, from string, status string, decidedBy string, comment string, now time.Time) (QuotaRequest, error) {
+	decidedAt := now.UTC()
+	request.Status = status
+	request.DecidedBy = decidedBy
+	request.DecidedAt = &decidedAt
+	request.Comment = comment
+
+	err := DB.Update(func(tx *bolt.Tx) error {
+		requests := tx.Bucket([]byte(quotaRequestBucket))
+		stored, err := getQuotaRequest(requests, request.Tenant, request.ID)
+		if err != nil {
+			return err
+		}
+		// another admin decided or claimed the request in the meantime
+		if stored.Status != from || !stored.claimTime().Equal(request.claimTime()) {
+			return ErrQuotaRequestDecided
+		}
+		return putJSON(requests, request.ID, request)
+	})
+	if err != nil {
+		return QuotaRequest{}, err
+	}
+
+	details := auditHardLimits(request)
+	for resourceName, value := range request.PreviousHard {
+		details["previous."+resourceName] = value
+	}
+	if comment != "" {
+		details["comment"] = comment
+	}
+	return request, RecordAuditEvent(AuditEvent{
+		Timestamp: now,
+		Actor:     decidedBy,
+		Action:    "quota_request." + status,
+		Tenant:    request.Tenant,
+		Target:    request.ID,
+		Details:   details,
+	})
+}
+
+// applyResourceQuota sets the hard limits of the resource quota in the namespace, which is created if it does not exist,
+// and returns the hard limits before the change
+func (cluster *Cluster) applyResourceQuota(ctx context.Context, namespace string, name string, hard v1.ResourceList) (v1.ResourceList, error) {
+	quotas := cluster.Clientset.CoreV1().ResourceQuotas(namespace)
+
+	var previousHard v1.ResourceList
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		quota, err := quotas.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			previousHard = v1.ResourceList{}
+			quota = &v1.ResourceQuota{}
+			quota.Name = name
+			quota.Namespace = namespace
+			quota.Spec.Hard = hard
+			_, err = quotas.Create(ctx, quota, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		previousHard = quota.Spec.Hard.DeepCopy()
+		if quota.Spec.Hard == nil {
+			quota.Spec.Hard = make(v1.ResourceList)
+		}
+		for resourceName, quantity := range hard {
+			quota.Spec.Hard[resourceName] = quantity
+		}
+		_, err = quotas.Update(ctx, quota, metav1.UpdateOptions{})
+		return err
+	})
+	return previousHard, err
+}
+
+// getQuotaRequest returns the stored quota request of the tenant with the id
+func getQuotaRequest(requests *bolt.Bucket, tenant string, id string) (QuotaRequest, error) {
+	var request QuotaRequest
+	data := requests.Get([]byte(id))
+	if data == nil {
+		return request, ErrQuotaRequestNotFound
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return request, err
+	}
+	if request.Tenant != tenant {
+		return QuotaRequest{}, ErrQuotaRequestNotFound
+	}
+	return request, nil
+}
+
+// parseHardLimits parses the hard limits of a quota request, the resources must be resources of a resource quota
+// and the quantities must not be negative
+func parseHardLimits(limits map[string]string) (v1.ResourceList, error) {
+	hard := make(v1.ResourceList, len(limits))
+	for resourceName, value := range limits {
+		if !isQuotaResource(resourceName) {
+			return nil, fmt.Errorf("%w, %q is no resource of a resource quota", ErrInvalidQuotaRequest, resourceName)
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil || quantity.Sign() < 0 {
+			return nil, fmt.Errorf("%w, invalid quantity %q of %s", ErrInvalidQuotaRequest, value, resourceName)
+		}
+		hard[v1.ResourceName(resourceName)] = quantity
+	}
+	return hard, nil
+}
+
+// isQuotaResource returns true if the resource can be limited by a resource quota: a standard resource, the storage
+// or the claims of a storage class, the count of an object or the requests of an extended resource or of hugepages
+func isQuotaResource(resourceName string) bool {
+	if quotaResources[v1.ResourceName(resourceName)] {
+		return true
+	}
+	for _, suffix := range []string{storageClassQuotaSuffix, storageClassClaimsQuotaSuffix} {
+		if storageClass := strings.TrimSuffix(resourceName, suffix); storageClass != resourceName {
+			return len(validation.IsDNS1123Subdomain(storageClass)) == 0
+		}
+	}
+	if object := strings.TrimPrefix(resourceName, "count/"); object != resourceName {
+		return len(validation.IsQualifiedName(object)) == 0
+	}
+	if extended := strings.TrimPrefix(resourceName, v1.DefaultResourceRequestsPrefix); extended != resourceName {
+		if strings.HasPrefix(extended, v1.ResourceHugePagesPrefix) {
+			_, err := resource.ParseQuantity(strings.TrimPrefix(extended, v1.ResourceHugePagesPrefix))
+			return err == nil
+		}
+		// extended resources have a domain, e.g. nvidia.com/gpu
+		return strings.Contains(extended, "/") && len(validation.IsQualifiedName(extended)) == 0
+	}
+	return false
+}
+
+// auditHardLimits returns the cluster, quota and hard limits of the quota request as audit details
+func auditHardLimits(request QuotaRequest) map[string]string {
+	details := map[string]string{
+		"cluster": request.Cluster,
+		"quota":   request.Quota,
+	}
+	for resourceName, value := range request.Hard {
+		details["hard."+resourceName] = value
+	}
+	return details
+}
@@ -0,0 +1,221 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeQuotaAPI serves the resource quota acme of the namespace acme, the updates fail while failing is set
+type fakeQuotaAPI struct {
+	mutex   sync.Mutex
+	quota   v1.ResourceQuota
+	failing bool
+	updates int
+}
+
+func (api *fakeQuotaAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path != "/api/v1/namespaces/acme/resourcequotas/acme" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(api.quota)
+	case http.MethodPut:
+		if api.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","message":"etcd unavailable","code":500}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&api.quota)
+		api.updates++
+		json.NewEncoder(w).Encode(api.quota)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// setupQuotaRequests uses a cluster with the resource quota acme of the tenant acme with a hard limit of 2 cpus
+func setupQuotaRequests(t *testing.T) *fakeQuotaAPI {
+	t.Helper()
+	setupStore(t)
+	cluster := setupCluster(t, "cpu: 1\n", tenantNamespace("acme"))
+
+	api := &fakeQuotaAPI{}
+	api.quota.Kind, api.quota.APIVersion = "ResourceQuota", "v1"
+	api.quota.Name, api.quota.Namespace = "acme", "acme"
+	api.quota.Spec.Hard = v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse("2")}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cluster.Clientset = clientset
+	return api
+}
+
+func TestCreateQuotaRequest(t *testing.T) {
+	setupQuotaRequests(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	valid := []string{"requests.cpu", "limits.memory", "pods", "count/deployments.apps", "ssd.storageclass.storage.k8s.io/requests.storage",
+		"ssd.storageclass.storage.k8s.io/persistentvolumeclaims", "requests.nvidia.com/gpu", "requests.hugepages-2Mi"}
+	for _, resourceName := range valid {
+		request, err := CreateQuotaRequest(QuotaRequest{Tenant: "acme", Hard: map[string]string{resourceName: "4"}, RequestedBy: "jane"}, now)
+		if err != nil {
+			t.Errorf("expected %s to be valid, got %v", resourceName, err)
+			continue
+		}
+		if request.Status != QuotaRequestPending || request.Cluster != "prod" || request.Quota != "acme" {
+			t.Errorf("unexpected request %+v", request)
+		}
+	}
+
+	invalid := []string{"", "cpus", "requests.gpu", "count/", "Invalid_Class.storageclass.storage.k8s.io/requests.storage", "requests.hugepages-huge"}
+	for _, resourceName := range invalid {
+		if _, err := CreateQuotaRequest(QuotaRequest{Tenant: "acme", Hard: map[string]string{resourceName: "4"}}, now); !errors.Is(err, ErrInvalidQuotaRequest) {
+			t.Errorf("expected %q to be invalid, got %v", resourceName, err)
+		}
+	}
+	if _, err := CreateQuotaRequest(QuotaRequest{Tenant: "acme", Hard: map[string]string{"requests.cpu": "-1"}}, now); !errors.Is(err, ErrInvalidQuotaRequest) {
+		t.Errorf("expected a negative quantity to be invalid, got %v", err)
+	}
+	if _, err := CreateQuotaRequest(QuotaRequest{Tenant: "acme", Cluster: "other", Hard: map[string]string{"requests.cpu": "4"}}, now); !errors.Is(err, ErrInvalidQuotaRequest) {
+		t.Errorf("expected an unknown cluster to be invalid, got %v", err)
+	}
+}
+
+func TestApproveQuotaRequest(t *testing.T) {
+	api := setupQuotaRequests(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	request, err := CreateQuotaRequest(QuotaRequest{Tenant: "acme", Hard: map[string]string{"requests.cpu": "4"}, RequestedBy: "jane"}, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// a failed update leaves the request pending
+	api.failing = true
+	if _, err := ApproveQuotaRequest(context.Background(), "acme", request.ID, "admin", "", now); err == nil || !strings.Contains(err.Error(), "cannot apply") {
+		t.Errorf("expected a failed approval, got %v", err)
+	}
+	if stored, _ := GetQuotaRequest("acme", request.ID); stored.Status != QuotaRequestPending || stored.ClaimedAt != nil {
+		t.Errorf("expected the request to be pending again, got %+v", stored)
+	}
+
+	api.failing = false
+	approved, err := ApproveQuotaRequest(context.Background(), "acme", request.ID, "admin", "ok", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if approved.Status != QuotaRequestApproved || approved.DecidedBy != "admin" || approved.PreviousHard["requests.cpu"] != "2" {
+		t.Errorf("unexpected approved request %+v", approved)
+	}
+	if hard := api.quota.Spec.Hard[v1.ResourceRequestsCPU]; hard.String() != "4" {
+		t.Errorf("expected the hard limit to be applied, got %s", hard.String())
+	}
+
+	// a decided request cannot be decided again
+	if _, err := ApproveQuotaRequest(context.Background(), "acme", request.ID, "admin", "", now); !errors.Is(err, ErrQuotaRequestDecided) {
+		t.Errorf("expected a decided request, got %v", err)
+	}
+	if _, err := RejectQuotaRequest("acme", request.ID, "admin", "", now); !errors.Is(err, ErrQuotaRequestDecided) {
+		t.Errorf("expected a decided request, got %v", err)
+	}
+	if _, err := ApproveQuotaRequest(context.Background(), "globex", request.ID, "admin", "", now); !errors.Is(err, ErrQuotaRequestNotFound) {
+		t.Errorf("expected the request of another tenant to be not found, got %v", err)
+	}
+	if api.updates != 1 {
+		t.Errorf("expected the quota to be updated once, got %d", api.updates)
+	}
+
+	events, _ := GetAuditEvents("acme")
+	if len(events) != 2 || events[0].Action != "quota_request.created" || events[1].Action != "quota_request.approved" || events[1].Details["previous.requests.cpu"] != "2" {
+		t.Errorf("expected the creation and the approval in the audit trail, got %v", events)
+	}
+}
+
+func TestQuotaRequestClaim(t *testing.T) {
+	api := setupQuotaRequests(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	request, err := CreateQuotaRequest(QuotaRequest{Tenant: "acme", Hard: map[string]string{"requests.cpu": "4"}, RequestedBy: "jane"}, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// an approval claims the request and crashes before it is decided
+	claimed, err := claimQuotaRequest("acme", request.ID, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if claimed.Status != QuotaRequestApproving || claimed.ClaimedAt == nil || !claimed.ClaimedAt.Equal(now) {
+		t.Errorf("unexpected claimed request %+v", claimed)
+	}
+
+	// the claim blocks the other admins
+	if _, err := ApproveQuotaRequest(context.Background(), "acme", request.ID, "admin", "", now.Add(time.Minute)); !errors.Is(err, ErrQuotaRequestDecided) {
+		t.Errorf("expected a claimed request, got %v", err)
+	}
+	if _, err := RejectQuotaRequest("acme", request.ID, "admin", "", now.Add(time.Minute)); !errors.Is(err, ErrQuotaRequestDecided) {
+		t.Errorf("expected a claimed request, got %v", err)
+	}
+	if requests, _ := RejectTenantQuotaRequests("acme", "admin", "", false, now.Add(time.Minute)); len(requests) != 0 {
+		t.Errorf("expected the claimed request not to be rejected, got %v", requests)
+	}
+
+	// the stale claim is claimed again, the crashed approval can neither release nor decide it
+	stale := now.Add(quotaRequestClaimTimeout + time.Second)
+	approved, err := ApproveQuotaRequest(context.Background(), "acme", request.ID, "admin", "", stale)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if approved.Status != QuotaRequestApproved || !approved.ClaimedAt.Equal(stale) || api.updates != 1 {
+		t.Errorf("unexpected approved request %+v with %d updates", approved, api.updates)
+	}
+	if err := releaseQuotaRequest(claimed); !errors.Is(err, ErrQuotaRequestDecided) {
+		t.Errorf("expected the release of the stale claim to fail, got %v", err)
+	}
+	if _, err := decideQuotaRequest(claimed, QuotaRequestApproving, QuotaRequestApproved, "crashed", "", stale); !errors.Is(err, ErrQuotaRequestDecided) {
+		t.Errorf("expected the decision of the stale claim to fail, got %v", err)
+	}
+}
+
+func TestRejectStaleQuotaRequestClaim(t *testing.T) {
+	api := setupQuotaRequests(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	request, err := CreateQuotaRequest(QuotaRequest{Tenant: "acme", Hard: map[string]string{"requests.cpu": "4"}, RequestedBy: "jane"}, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := claimQuotaRequest("acme", request.ID, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rejected, err := RejectQuotaRequest("acme", request.ID, "admin", "too much", now.Add(quotaRequestClaimTimeout+time.Second))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rejected.Status != QuotaRequestRejected || rejected.Comment != "too much" || api.updates != 0 {
+		t.Errorf("unexpected rejected request %+v with %d updates", rejected, api.updates)
+	}
+}
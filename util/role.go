@@ -0,0 +1,96 @@
+package util
+
+import (
+	"sort"
+)
+
+// Role is the role of a user in a tenant
+type Role string
+
+const (
+	// RoleViewer can read the resources, requests and quotas of the tenant
+	RoleViewer Role = "viewer"
+	// RoleDeveloper has the rights of a viewer and is the role of the members working on the workloads of the tenant
+	RoleDeveloper Role = "developer"
+	// RoleBilling has the rights of a viewer and can read the costs and invoices of the tenant
+	RoleBilling Role = "billing"
+	// RoleAdmin has all rights in the tenant
+	RoleAdmin Role = "admin"
+)
+
+var (
+	// ROLE_MAPPING maps a team or group to the roles its members have in the tenants of the group
+	ROLE_MAPPING map[string][]Role
+	// DEFAULT_ROLES are the roles of the members of a group without a role mapping
+	DEFAULT_ROLES []Role
+
+	// impliedRoles are the roles granted by each role
+	impliedRoles = map[Role][]Role{
+		RoleViewer:    {RoleViewer},
+		RoleDeveloper: {RoleDeveloper, RoleViewer},
+		RoleBilling:   {RoleBilling, RoleViewer},
+		RoleAdmin:     {RoleAdmin, RoleDeveloper, RoleBilling, RoleViewer},
+	}
+)
+
+// IsRole returns true if the role is known
+func IsRole(role Role) bool {
+	_, ok := impliedRoles[role]
+	return ok
+}
+
+// HasRole returns true if one of the roles is or implies the required role
+func HasRole(roles []Role, required Role) bool {
+	for _, role := range roles {
+		for _, impliedRole := range impliedRoles[role] {
+			if impliedRole == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetTenantRoles returns the roles of the identity in each of its tenants, the roles of a group are
+// the ROLE_MAPPING or DEFAULT_ROLES of the group and the roles granted by the identity provider
+func GetTenantRoles(identity Identity) map[string][]Role {
+	tenantRoles := make(map[string][]Role)
+	for _, group := range identity.Groups {
+		groupRoles, ok := ROLE_MAPPING[group]
+		if !ok {
+			groupRoles = DEFAULT_ROLES
+		}
+		groupRoles = append(append([]Role{}, groupRoles...), identity.GroupRoles[group]...)
+
+		for _, tenant := range GetTenantsOfGroups([]string{group}) {
+			for _, role := range groupRoles {
+				if !containsRole(role, tenantRoles[tenant]) {
+					tenantRoles[tenant] = append(tenantRoles[tenant], role)
+				}
+			}
+		}
+	}
+	return tenantRoles
+}
+
+// GetTenantsWithRole returns the sorted tenants in which the roles include the required role
+func GetTenantsWithRole(tenantRoles map[string][]Role, required Role) []string {
+	tenants := make([]string, 0, len(tenantRoles))
+	for tenant, roles := range tenantRoles {
+		if HasRole(roles, required) {
+			tenants = append(tenants, tenant)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// containsRole returns true if the role is in the roles
+func containsRole(role Role, roles []Role) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
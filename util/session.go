@@ -0,0 +1,434 @@
+package util
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/oauth2"
+)
+
+const (
+	sessionBucket = "sessions"
+	// revoked session ids with the expiry of their last access token
+	revocationBucket = "revocations"
+	// pending logins with the identity providers keyed by their state
+	loginStateBucket = "login_states"
+	// time to log in with the identity provider
+	loginStateTTL = 10 * time.Minute
+)
+
+var (
+	ACCESS_TOKEN_TTL  time.Duration
+	REFRESH_TOKEN_TTL time.Duration
+
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrInvalidLoginState   = errors.New("invalid or expired login state")
+)
+
+// Session is a login of a user, the refresh token of the session is only stored as hash and
+// the token of the identity provider only encrypted with the signing key of TokenKeyID
+type Session struct {
+	ID               string        `json:"id"`
+	Provider         string        `json:"provider"`
+	Subject          string        `json:"subject"`
+	Tenants          []string      `json:"tenants,omitempty"`
+	RefreshTokenHash string        `json:"refresh_token_hash"`
+	Token            *oauth2.Token `json:"-"`
+	EncryptedToken   string        `json:"encrypted_token"`
+	TokenKeyID       string        `json:"token_key_id,omitempty"`
+	CreatedAt        time.Time     `json:"created_at"`
+	ExpiresAt        time.Time     `json:"expires_at"`
+}
+
+// LoginState is a pending login with an identity provider, a state can be used once with the redirect uri of its authorization request
+type LoginState struct {
+	Provider     string    `json:"provider"`
+	RedirectURI  string    `json:"redirect_uri"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// RunSessionPruner deletes the expired sessions, revocations and login states each hour until stopCh is closed
+func RunSessionPruner(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if err := PruneSessions(time.Now()); err != nil {
+			ErrorLogger.Printf("Error pruning sessions: %v", err)
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CreateSession stores a new session of the identity and returns it with its refresh token,
+// the session expires REFRESH_TOKEN_TTL after the login
+func CreateSession(identity Identity, now time.Time) (Session, string, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	session := Session{
+		ID:        id,
+		Provider:  identity.Provider,
+		Subject:   identity.Subject,
+		Tenants:   GetTenantsWithRole(GetTenantRoles(identity), RoleViewer),
+		Token:     identity.Token,
+		CreatedAt: now.UTC(),
+		ExpiresAt: now.Add(REFRESH_TOKEN_TTL).UTC(),
+	}
+
+	if err := session.sealToken(); err != nil {
+		return Session{}, "", err
+	}
+	refreshToken, err := rotateRefreshToken(&session)
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket([]byte(sessionBucket)), session.ID, session)
+	})
+	return session, refreshToken, err
+}
+
+// RefreshSession checks the identity of the session of the refresh token again with its identity provider and
+// returns the current identity with the session and a new refresh token, the previous refresh token is invalid afterwards
+func RefreshSession(ctx context.Context, refreshToken string, now time.Time) (Identity, Session, string, error) {
+	session, err := getSession(refreshToken, now)
+	if err != nil {
+		return Identity{}, Session{}, "", err
+	}
+	if err := session.openToken(); err != nil {
+		return Identity{}, Session{}, "", err
+	}
+
+	provider, err := GetIdentityProvider(session.Provider)
+	if err != nil {
+		return Identity{}, Session{}, "", err
+	}
+
+	identity, err := provider.Refresh(ctx, session.Token)
+	if err != nil {
+		return Identity{}, Session{}, "", err
+	}
+
+	previousRefreshTokenHash := session.RefreshTokenHash
+	session.Tenants = GetTenantsWithRole(GetTenantRoles(identity), RoleViewer)
+	// the new token is encrypted with the active signing key, so the sessions outlive a key rotation
+	session.Token = identity.Token
+	if err := session.sealToken(); err != nil {
+		return Identity{}, Session{}, "", err
+	}
+	newRefreshToken, err := rotateRefreshToken(&session)
+	if err != nil {
+		return Identity{}, Session{}, "", err
+	}
+
+	// the session must not have been revoked or refreshed in the meantime
+	err = DB.Update(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket([]byte(sessionBucket))
+		var storedSession Session
+		if data := sessions.Get([]byte(session.ID)); data == nil || json.Unmarshal(data, &storedSession) != nil ||
+			storedSession.RefreshTokenHash != previousRefreshTokenHash {
+			return ErrInvalidRefreshToken
+		}
+		return putJSON(sessions, session.ID, session)
+	})
+	if err != nil {
+		return Identity{}, Session{}, "", err
+	}
+
+	return identity, session, newRefreshToken, nil
+}
+
+// RevokeSession deletes the session and revokes its access tokens until the last of them has expired
+func RevokeSession(id string, now time.Time) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(sessionBucket)).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return putJSON(tx.Bucket([]byte(revocationBucket)), id, now.Add(ACCESS_TOKEN_TTL).UTC())
+	})
+}
+
+// RevokeRefreshSession revokes the unexpired session of the refresh token, its access tokens need not be valid anymore
+func RevokeRefreshSession(refreshToken string, now time.Time) error {
+	session, err := getSession(refreshToken, now)
+	if err != nil {
+		return err
+	}
+	return RevokeSession(session.ID, now)
+}
+
+// RevokeTenantSessions revokes the sessions with access to the tenant and returns them, the dry run only returns them
+func RevokeTenantSessions(tenant string, dryRun bool, now time.Time) ([]Session, error) {
+	revoked := make([]Session, 0)
+	update := func(tx *bolt.Tx) error {
+		sessions := tx.Bucket([]byte(sessionBucket))
+		if err := sessions.ForEach(func(_, value []byte) error {
+			var session Session
+			if err := json.Unmarshal(value, &session); err != nil {
+				return err
+			}
+			if Contains(tenant, session.Tenants) {
+				revoked = append(revoked, session)
+			}
+			return nil
+		}); err != nil || dryRun {
+			return err
+		}
+
+		for _, session := range revoked {
+			if err := sessions.Delete([]byte(session.ID)); err != nil {
+				return err
+			}
+			if err := putJSON(tx.Bucket([]byte(revocationBucket)), session.ID, now.Add(ACCESS_TOKEN_TTL).UTC()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if dryRun {
+		err = DB.View(update)
+	} else {
+		err = DB.Update(update)
+	}
+	return revoked, err
+}
+
+// IsSessionRevoked returns true if the session is on the revocation list
+func IsSessionRevoked(id string) (bool, error) {
+	revoked := false
+	err := DB.View(func(tx *bolt.Tx) error {
+		revoked = tx.Bucket([]byte(revocationBucket)).Get([]byte(id)) != nil
+		return nil
+	})
+	return revoked, err
+}
+
+// PruneSessions deletes the sessions, the revocations and the login states which have expired before now
+func PruneSessions(now time.Time) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		if err := pruneBucket(tx.Bucket([]byte(sessionBucket)), now, func(value []byte) (time.Time, error) {
+			var session Session
+			err := json.Unmarshal(value, &session)
+			// the sessions stored before the tokens were encrypted have the token in plaintext
+			if session.EncryptedToken == "" {
+				return time.Time{}, err
+			}
+			return session.ExpiresAt, err
+		}); err != nil {
+			return err
+		}
+		if err := pruneBucket(tx.Bucket([]byte(revocationBucket)), now, func(value []byte) (time.Time, error) {
+			var expiresAt time.Time
+			err := json.Unmarshal(value, &expiresAt)
+			return expiresAt, err
+		}); err != nil {
+			return err
+		}
+		return pruneBucket(tx.Bucket([]byte(loginStateBucket)), now, func(value []byte) (time.Time, error) {
+			var loginState LoginState
+			err := json.Unmarshal(value, &loginState)
+			return loginState.ExpiresAt, err
+		})
+	})
+}
+
+// CreateLoginState stores a new login state of the identity provider and the redirect uri and returns the state with the PKCE code verifier
+func CreateLoginState(provider string, redirectURI string, now time.Time) (string, string, error) {
+	state, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	codeVerifier, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	loginState := LoginState{
+		Provider:     provider,
+		RedirectURI:  redirectURI,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    now.Add(loginStateTTL).UTC(),
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket([]byte(loginStateBucket)), state, loginState)
+	})
+	return state, codeVerifier, err
+}
+
+// ConsumeLoginState deletes the login state of the identity provider and the redirect uri and returns its PKCE code verifier
+func ConsumeLoginState(state string, provider string, redirectURI string, now time.Time) (string, error) {
+	var loginState LoginState
+	err := DB.Update(func(tx *bolt.Tx) error {
+		loginStates := tx.Bucket([]byte(loginStateBucket))
+		data := loginStates.Get([]byte(state))
+		if data == nil {
+			return ErrInvalidLoginState
+		}
+		if err := json.Unmarshal(data, &loginState); err != nil {
+			return err
+		}
+		return loginStates.Delete([]byte(state))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if loginState.Provider != provider || loginState.RedirectURI != redirectURI || !now.Before(loginState.ExpiresAt) {
+		return "", ErrInvalidLoginState
+	}
+	return loginState.CodeVerifier, nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge of the code verifier
+func CodeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// pruneBucket deletes the values of the bucket which have expired before now or are invalid
+func pruneBucket(bucket *bolt.Bucket, now time.Time, expiresAt func(value []byte) (time.Time, error)) error {
+	// collect the keys first, deleting in ForEach is not allowed
+	keys := make([][]byte, 0)
+	if err := bucket.ForEach(func(key, value []byte) error {
+		if expiry, err := expiresAt(value); err != nil || expiry.Before(now) {
+			keys = append(keys, append([]byte{}, key...))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSession returns the unexpired session of the refresh token
+func getSession(refreshToken string, now time.Time) (Session, error) {
+	// the refresh token is the session id and a secret
+	parts := strings.SplitN(refreshToken, ".", 2)
+	if len(parts) != 2 {
+		return Session{}, ErrInvalidRefreshToken
+	}
+
+	var session Session
+	err := DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(sessionBucket)).Get([]byte(parts[0]))
+		if data == nil {
+			return ErrInvalidRefreshToken
+		}
+		return json.Unmarshal(data, &session)
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(parts[1])), []byte(session.RefreshTokenHash)) != 1 || !now.Before(session.ExpiresAt) {
+		return Session{}, ErrInvalidRefreshToken
+	}
+	return session, nil
+}
+
+// sealToken encrypts the token of the identity provider with AES-GCM and the active signing key, the ciphertext is bound to the session id
+func (session *Session) sealToken() error {
+	data, err := json.Marshal(session.Token)
+	if err != nil {
+		return err
+	}
+
+	keyID := activeSigningKeyID()
+	aead, err := tokenCipher(keyID)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("nonce could not be generated: %w", err)
+	}
+
+	session.EncryptedToken = base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, data, []byte(session.ID)))
+	session.TokenKeyID = keyID
+	return nil
+}
+
+// openToken decrypts the token of the identity provider, a session encrypted with a removed signing key cannot be refreshed
+func (session *Session) openToken() error {
+	aead, err := tokenCipher(session.TokenKeyID)
+	if err != nil {
+		return fmt.Errorf("%w, the token of the session cannot be decrypted: %s", ErrInvalidRefreshToken, err)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(session.EncryptedToken)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return fmt.Errorf("%w, the token of the session is invalid", ErrInvalidRefreshToken)
+	}
+
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(session.ID))
+	if err != nil {
+		return fmt.Errorf("%w, the token of the session cannot be decrypted: %s", ErrInvalidRefreshToken, err)
+	}
+	return json.Unmarshal(data, &session.Token)
+}
+
+// tokenCipher returns the AES-GCM cipher with the key derived from the signing key with the id
+func tokenCipher(keyID string) (cipher.AEAD, error) {
+	key, err := tokenEncryptionKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// rotateRefreshToken sets the hash of a new refresh token of the session and returns the refresh token
+func rotateRefreshToken(session *Session) (string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	session.RefreshTokenHash = hashToken(secret)
+	return session.ID + "." + secret, nil
+}
+
+// hashToken returns the hex encoded sha256 hash of the token
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// randomToken returns n cryptographically random bytes encoded as url safe base64
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("random token could not be generated: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
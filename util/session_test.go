@@ -0,0 +1,401 @@
+package util
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/oauth2"
+)
+
+// fakeProvider refreshes the identities with its groups and a new token, onRefresh is called before each refresh
+type fakeProvider struct {
+	groups    []string
+	err       error
+	refreshed []*oauth2.Token
+	onRefresh func()
+}
+
+func (provider *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (provider *fakeProvider) AuthCodeURL(redirectURL string, state string, codeChallenge string) string {
+	return "http://idp.example.com/authorize?state=" + state
+}
+
+func (provider *fakeProvider) Authenticate(ctx context.Context, code string, redirectURL string, codeVerifier string) (Identity, error) {
+	return provider.identity(code), provider.err
+}
+
+func (provider *fakeProvider) Refresh(ctx context.Context, token *oauth2.Token) (Identity, error) {
+	provider.refreshed = append(provider.refreshed, token)
+	if provider.onRefresh != nil {
+		provider.onRefresh()
+	}
+	if provider.err != nil {
+		return Identity{}, provider.err
+	}
+	return provider.identity(token.AccessToken + "+"), nil
+}
+
+// identity returns the identity of jane with the groups and the access token
+func (provider *fakeProvider) identity(accessToken string) Identity {
+	return Identity{
+		Provider: "fake",
+		Subject:  "jane",
+		Groups:   provider.groups,
+		Token:    &oauth2.Token{AccessToken: accessToken, RefreshToken: "idp-refresh"},
+	}
+}
+
+// setupSessions uses an empty store and the fake provider, the groups are viewers of the tenants of the same name
+func setupSessions(t *testing.T) *fakeProvider {
+	t.Helper()
+	setupStore(t)
+	setupSecretKey(t)
+
+	previousProviders, previousRoleMapping, previousDefaultRoles := IdentityProviders, ROLE_MAPPING, DEFAULT_ROLES
+	previousAccessTokenTTL, previousRefreshTokenTTL := ACCESS_TOKEN_TTL, REFRESH_TOKEN_TTL
+	t.Cleanup(func() {
+		IdentityProviders, ROLE_MAPPING, DEFAULT_ROLES = previousProviders, previousRoleMapping, previousDefaultRoles
+		ACCESS_TOKEN_TTL, REFRESH_TOKEN_TTL = previousAccessTokenTTL, previousRefreshTokenTTL
+	})
+
+	provider := &fakeProvider{groups: []string{"acme", "globex"}}
+	IdentityProviders = map[string]IdentityProvider{provider.Name(): provider}
+	ROLE_MAPPING, DEFAULT_ROLES = nil, []Role{RoleViewer}
+	ACCESS_TOKEN_TTL, REFRESH_TOKEN_TTL = 15*time.Minute, 24*time.Hour
+	return provider
+}
+
+func TestRefreshSessionRotatesRefreshToken(t *testing.T) {
+	provider := setupSessions(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	session, refreshToken, err := CreateSession(provider.identity("access"), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(session.Tenants, []string{"acme", "globex"}) || !session.ExpiresAt.Equal(now.Add(REFRESH_TOKEN_TTL)) {
+		t.Errorf("unexpected session %+v", session)
+	}
+
+	// the refresh checks the groups again with the token of the identity provider
+	provider.groups = []string{"acme"}
+	identity, refreshed, newRefreshToken, err := RefreshSession(context.Background(), refreshToken, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(provider.refreshed) != 1 || provider.refreshed[0].AccessToken != "access" {
+		t.Errorf("expected the token of the login to be refreshed, got %v", provider.refreshed)
+	}
+	if identity.Subject != "jane" || refreshed.ID != session.ID || !reflect.DeepEqual(refreshed.Tenants, []string{"acme"}) {
+		t.Errorf("expected the session with the current tenants, got %+v", refreshed)
+	}
+	if newRefreshToken == refreshToken {
+		t.Errorf("expected a new refresh token")
+	}
+
+	// the previous refresh token cannot be reused
+	if _, _, _, err := RefreshSession(context.Background(), refreshToken, now.Add(time.Hour)); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected the reused refresh token to be invalid, got %v", err)
+	}
+
+	// the new refresh token refreshes with the token of the previous refresh
+	if _, _, _, err := RefreshSession(context.Background(), newRefreshToken, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(provider.refreshed) != 2 || provider.refreshed[1].AccessToken != "access+" {
+		t.Errorf("expected the refreshed token to be stored, got %v", provider.refreshed)
+	}
+}
+
+func TestRefreshSessionFails(t *testing.T) {
+	provider := setupSessions(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	session, refreshToken, err := CreateSession(provider.identity("access"), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, invalid := range []string{"", "no-separator", session.ID + ".wrong-secret", "unknown." + refreshToken} {
+		if _, _, _, err := RefreshSession(context.Background(), invalid, now); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("expected %q to be invalid, got %v", invalid, err)
+		}
+	}
+	if _, _, _, err := RefreshSession(context.Background(), refreshToken, session.ExpiresAt); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected the expired refresh token to be invalid, got %v", err)
+	}
+
+	// a failed refresh with the identity provider keeps the refresh token
+	provider.err = errors.New("refresh failed")
+	if _, _, _, err := RefreshSession(context.Background(), refreshToken, now); err == nil {
+		t.Errorf("expected the refresh to fail")
+	}
+	provider.err = nil
+
+	// of two refreshes with the same refresh token only the first one succeeds
+	var concurrentErr error
+	provider.onRefresh = func() {
+		provider.onRefresh = nil
+		_, _, _, concurrentErr = RefreshSession(context.Background(), refreshToken, now)
+	}
+	if _, _, _, err := RefreshSession(context.Background(), refreshToken, now); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected the refresh after the concurrent refresh to fail, got %v", err)
+	}
+	if concurrentErr != nil {
+		t.Errorf("expected the concurrent refresh to succeed, got %v", concurrentErr)
+	}
+}
+
+func TestRevokeRefreshSession(t *testing.T) {
+	provider := setupSessions(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	session, refreshToken, err := CreateSession(provider.identity("access"), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	other, otherRefreshToken, err := CreateSession(provider.identity("other"), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := RevokeRefreshSession(refreshToken, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if revoked, _ := IsSessionRevoked(session.ID); !revoked {
+		t.Errorf("expected the access tokens of the session to be revoked")
+	}
+	if _, _, _, err := RefreshSession(context.Background(), refreshToken, now); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected the refresh token of the logout to be invalid, got %v", err)
+	}
+	if err := RevokeRefreshSession(refreshToken, now); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected a second logout to fail, got %v", err)
+	}
+
+	// the other sessions of the user stay valid
+	if revoked, _ := IsSessionRevoked(other.ID); revoked {
+		t.Errorf("expected the other session not to be revoked")
+	}
+	if _, _, _, err := RefreshSession(context.Background(), otherRefreshToken, now); err != nil {
+		t.Errorf("expected the other session to be refreshed, got %v", err)
+	}
+
+	// the revocation is kept until the last access token has expired
+	if err := PruneSessions(now.Add(ACCESS_TOKEN_TTL - time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if revoked, _ := IsSessionRevoked(session.ID); !revoked {
+		t.Errorf("expected the revocation to be kept while the access tokens are valid")
+	}
+	if err := PruneSessions(now.Add(ACCESS_TOKEN_TTL + time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if revoked, _ := IsSessionRevoked(session.ID); revoked {
+		t.Errorf("expected the revocation to be pruned")
+	}
+}
+
+func TestConsumeLoginState(t *testing.T) {
+	setupSessions(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	redirectURI := "https://tenant-api.example.com/login/fake/callback"
+
+	state, codeVerifier, err := CreateLoginState("fake", redirectURI, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if state == "" || codeVerifier == "" || state == codeVerifier {
+		t.Fatalf("expected a random state and code verifier, got %q and %q", state, codeVerifier)
+	}
+	if challenge := CodeChallenge(codeVerifier); challenge == codeVerifier || len(challenge) != 43 {
+		t.Errorf("expected the S256 code challenge of the verifier, got %q", challenge)
+	}
+
+	consumed, err := ConsumeLoginState(state, "fake", redirectURI, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if consumed != codeVerifier {
+		t.Errorf("expected the code verifier of the login, got %q", consumed)
+	}
+
+	// the state can be used once
+	if _, err := ConsumeLoginState(state, "fake", redirectURI, now.Add(time.Minute)); !errors.Is(err, ErrInvalidLoginState) {
+		t.Errorf("expected the used state to be invalid, got %v", err)
+	}
+}
+
+func TestConsumeLoginStateIsBound(t *testing.T) {
+	setupSessions(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	redirectURI := "https://tenant-api.example.com/login/fake/callback"
+
+	tests := []struct {
+		name        string
+		provider    string
+		redirectURI string
+		at          time.Time
+	}{
+		{name: "other provider", provider: "github", redirectURI: redirectURI, at: now},
+		{name: "other redirect uri", provider: "fake", redirectURI: "https://evil.example.com/callback", at: now},
+		{name: "expired", provider: "fake", redirectURI: redirectURI, at: now.Add(loginStateTTL)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, _, err := CreateLoginState("fake", redirectURI, now)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, err := ConsumeLoginState(state, test.provider, test.redirectURI, test.at); !errors.Is(err, ErrInvalidLoginState) {
+				t.Errorf("expected the state to be invalid, got %v", err)
+			}
+			// a mismatch consumes the state as well
+			if _, err := ConsumeLoginState(state, "fake", redirectURI, now); !errors.Is(err, ErrInvalidLoginState) {
+				t.Errorf("expected the state to be consumed, got %v", err)
+			}
+		})
+	}
+
+	if _, err := ConsumeLoginState("unknown", "fake", redirectURI, now); !errors.Is(err, ErrInvalidLoginState) {
+		t.Errorf("expected an unknown state to be invalid, got %v", err)
+	}
+
+	// each login has its own code verifier
+	first, firstVerifier, _ := CreateLoginState("fake", redirectURI, now)
+	second, secondVerifier, _ := CreateLoginState("fake", redirectURI, now)
+	if first == second || firstVerifier == secondVerifier {
+		t.Errorf("expected distinct states and code verifiers")
+	}
+	if verifier, _ := ConsumeLoginState(second, "fake", redirectURI, now); verifier != secondVerifier {
+		t.Errorf("expected the code verifier of the second login, got %q", verifier)
+	}
+
+	// the expired login states are pruned
+	if err := PruneSessions(now.Add(loginStateTTL + time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := ConsumeLoginState(first, "fake", redirectURI, now); !errors.Is(err, ErrInvalidLoginState) {
+		t.Errorf("expected the expired state to be pruned, got %v", err)
+	}
+}
+
+func TestSessionTokenIsEncrypted(t *testing.T) {
+	provider := setupSessions(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	session, refreshToken, err := CreateSession(provider.identity("idp-access"), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	other, otherRefreshToken, err := CreateSession(provider.identity("other-access"), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	DB.View(func(tx *bolt.Tx) error {
+		data := string(tx.Bucket([]byte(sessionBucket)).Get([]byte(session.ID)))
+		if strings.Contains(data, "idp-access") || strings.Contains(data, "idp-refresh") || !strings.Contains(data, "encrypted_token") {
+			t.Errorf("expected only the encrypted token to be stored, got %s", data)
+		}
+		return nil
+	})
+
+	// the encrypted token is bound to its session
+	DB.Update(func(tx *bolt.Tx) error {
+		other.EncryptedToken = session.EncryptedToken
+		return putJSON(tx.Bucket([]byte(sessionBucket)), other.ID, other)
+	})
+	if _, _, _, err := RefreshSession(context.Background(), otherRefreshToken, now); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected the token of another session not to be decrypted, got %v", err)
+	}
+
+	if _, _, _, err := RefreshSession(context.Background(), refreshToken, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(provider.refreshed) != 1 || provider.refreshed[0].AccessToken != "idp-access" || provider.refreshed[0].RefreshToken != "idp-refresh" {
+		t.Errorf("expected the decrypted token to be refreshed, got %v", provider.refreshed)
+	}
+}
+
+func TestSessionTokenKeyRotation(t *testing.T) {
+	provider := setupSessions(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	previousKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	currentKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	setupSigningKeys(t, map[string]interface{}{"previous.pem": previousKey})
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	session, refreshToken, err := CreateSession(provider.identity("access"), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, staleRefreshToken, err := CreateSession(provider.identity("stale"), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if session.TokenKeyID != "previous" {
+		t.Errorf("expected the token to be encrypted with the active key, got %q", session.TokenKeyID)
+	}
+
+	// the refresh decrypts with the previous key and encrypts with the current key
+	setupSigningKeys(t, map[string]interface{}{"previous.pem": previousKey, "current.pem": currentKey})
+	SIGNING_KEY_ID = "current"
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, refreshed, refreshToken, err := RefreshSession(context.Background(), refreshToken, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if refreshed.TokenKeyID != "current" {
+		t.Errorf("expected the token to be encrypted with the current key, got %q", refreshed.TokenKeyID)
+	}
+
+	// without the previous key only the refreshed session can be refreshed
+	os.Remove(filepath.Join(SIGNING_KEYS_DIR, "previous.pem"))
+	if err := InitSigningKeys(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, _, _, err := RefreshSession(context.Background(), refreshToken, now); err != nil {
+		t.Errorf("expected the refreshed session to be refreshed, got %v", err)
+	}
+	if _, _, _, err := RefreshSession(context.Background(), staleRefreshToken, now); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected the session of the removed key to be invalid, got %v", err)
+	}
+}
+
+func TestPruneSessionsWithPlaintextToken(t *testing.T) {
+	setupSessions(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// a session stored before the tokens were encrypted
+	DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(sessionBucket)).Put([]byte("plaintext"),
+			[]byte(`{"id":"plaintext","provider":"fake","subject":"jane","token":{"access_token":"idp-access"},"expires_at":"2026-01-02T00:00:00Z"}`))
+	})
+
+	if err := PruneSessions(now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	DB.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(sessionBucket)).Get([]byte("plaintext")) != nil {
+			t.Errorf("expected the session with the plaintext token to be deleted")
+		}
+		return nil
+	})
+}
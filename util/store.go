@@ -0,0 +1,48 @@
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	DB        *bolt.DB
+	DATA_PATH string
+	// buckets of the embedded database
+	storeBuckets = []string{historyBucket, invoiceBucket, sessionBucket, revocationBucket, loginStateBucket, apiTokenBucket, budgetBucket, quotaRequestBucket, auditBucket}
+)
+
+// InitStore opens the embedded database in DATA_PATH and creates the buckets
+func InitStore() error {
+	if err := os.MkdirAll(DATA_PATH, 0700); err != nil {
+		return err
+	}
+
+	var err error
+	DB, err = bolt.Open(filepath.Join(DATA_PATH, "tenant-api.db"), 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+
+	return DB.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range storeBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// putJSON stores the value as json with the key in the bucket
+func putJSON(bucket *bolt.Bucket, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), data)
+}
@@ -0,0 +1,322 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// CostItem is the cost of a category, subtotal is without and total with the discounts applied
+type CostItem struct {
+	Subtotal float64 `json:"subtotal"`
+	Discount float64 `json:"discount"`
+	Total    float64 `json:"total"`
+}
+
+// StorageCostItem is the storage cost over all storage classes and the cost of each storage class
+type StorageCostItem struct {
+	CostItem
+	StorageClasses map[string]CostItem `json:"storage_classes"`
+}
+
+// CostSummary is the cost breakdown of a tenant in its currency with the grand total over all categories
+type CostSummary struct {
+	Currency string          `json:"currency"`
+	CPU      CostItem        `json:"cpu"`
+	Memory   CostItem        `json:"memory"`
+	Storage  StorageCostItem `json:"storage"`
+	Ingress  CostItem        `json:"ingress"`
+	CostItem
+}
+
+// FleetCostSummary is the cost summary over all tenants in one currency with the tenants ranked by their cost
+type FleetCostSummary struct {
+	CostSummary
+	Tenants []TenantCostRank `json:"tenants"`
+}
+
+// TenantCostRank is the rank of a tenant by its cost in a category and its share of the fleet cost
+type TenantCostRank struct {
+	Rank   int     `json:"rank"`
+	Tenant string  `json:"tenant"`
+	Cost   float64 `json:"cost"`
+	Share  float64 `json:"share"`
+}
+
+// CostCategories are the categories the tenants can be ranked by
+var CostCategories = []string{"total", "cpu", "memory", "storage", "ingress"}
+
+var ErrInvalidCostCategory = errors.New("invalid cost category")
+
+// GetFleetCostSummary sums the cost summaries of the tenants converted to the currency and ranks the tenants by the total of the category
+func GetFleetCostSummary(tenantSummaries map[string]CostSummary, currency string, category string) (FleetCostSummary, error) {
+	if !Contains(category, CostCategories) {
+		return FleetCostSummary{}, fmt.Errorf("%w %s", ErrInvalidCostCategory, category)
+	}
+
+	fleet := FleetCostSummary{
+		CostSummary: CostSummary{
+			Currency: currency,
+			Storage: StorageCostItem{
+				StorageClasses: make(map[string]CostItem),
+			},
+		},
+		Tenants: make([]TenantCostRank, 0, len(tenantSummaries)),
+	}
+	for tenant, summary := range tenantSummaries {
+		summary, err := summary.convert(currency)
+		if err != nil {
+			return FleetCostSummary{}, err
+		}
+
+		fleet.CPU.add(summary.CPU)
+		fleet.Memory.add(summary.Memory)
+		fleet.Ingress.add(summary.Ingress)
+		fleet.Storage.add(summary.Storage.CostItem)
+		for storageClass, storageCost := range summary.Storage.StorageClasses {
+			storageClassCost := fleet.Storage.StorageClasses[storageClass]
+			storageClassCost.add(storageCost)
+			fleet.Storage.StorageClasses[storageClass] = storageClassCost
+		}
+		fleet.add(summary.CostItem)
+
+		fleet.Tenants = append(fleet.Tenants, TenantCostRank{
+			Tenant: tenant,
+			Cost:   summary.categoryTotal(category),
+		})
+	}
+
+	// most expensive tenants first, ties by name
+	sort.Slice(fleet.Tenants, func(i, j int) bool {
+		if fleet.Tenants[i].Cost != fleet.Tenants[j].Cost {
+			return fleet.Tenants[i].Cost > fleet.Tenants[j].Cost
+		}
+		return fleet.Tenants[i].Tenant < fleet.Tenants[j].Tenant
+	})
+	fleetTotal := fleet.categoryTotal(category)
+	for i := range fleet.Tenants {
+		fleet.Tenants[i].Rank = i + 1
+		if fleetTotal != 0 {
+			fleet.Tenants[i].Share = fleet.Tenants[i].Cost / fleetTotal
+		}
+	}
+
+	return fleet, nil
+}
+
+// GetCostSummaryByClusterByTenant returns the cost summary of each tenant keyed by cluster
+func GetCostSummaryByClusterByTenant(tenants []string) (map[string]map[string]CostSummary, error) {
+	clusterTenantSummaries := make(map[string]map[string]CostSummary)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot, err = snapshot.withBilledUsage(tenants); err != nil {
+			return nil, err
+		}
+		tenantSummaries, err := snapshot.GetCostSummaryByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantSummaries[cluster.Name] = tenantSummaries
+	}
+	return clusterTenantSummaries, nil
+}
+
+// SumCostSummaryByTenant sums the cost summary of each tenant over all clusters
+func SumCostSummaryByTenant(clusterTenantSummaries map[string]map[string]CostSummary) map[string]CostSummary {
+	tenantSummaries := make(map[string]CostSummary)
+	for _, clusterSummaries := range clusterTenantSummaries {
+		for tenant, clusterSummary := range clusterSummaries {
+			summary, ok := tenantSummaries[tenant]
+			if !ok {
+				summary = newCostSummary(tenant, time.Now())
+			}
+			summary.CPU.add(clusterSummary.CPU)
+			summary.Memory.add(clusterSummary.Memory)
+			summary.Ingress.add(clusterSummary.Ingress)
+			summary.Storage.add(clusterSummary.Storage.CostItem)
+			for storageClass, storageCost := range clusterSummary.Storage.StorageClasses {
+				storageClassCost := summary.Storage.StorageClasses[storageClass]
+				storageClassCost.add(storageCost)
+				summary.Storage.StorageClasses[storageClass] = storageClassCost
+			}
+			summary.add(clusterSummary.CostItem)
+			tenantSummaries[tenant] = summary
+		}
+	}
+	return tenantSummaries
+}
+
+// GetCostSummaryByTenant returns the cost summary of each tenant in the cluster, listing the pods, pvcs and ingresses of each tenant once
+func (cluster *Cluster) GetCostSummaryByTenant(tenants []string) (map[string]CostSummary, error) {
+	tenantSummaries := make(map[string]CostSummary)
+	for _, tenant := range tenants {
+		summary := newCostSummary(tenant, cluster.pricedAt())
+
+		pods, err := cluster.listBilledPods(tenant)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods {
+			discount, err := GetDiscount(pod.Labels)
+			if err != nil {
+				return nil, fmt.Errorf("pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+
+			cpu := float64(pod.Resources.Cpu().MilliValue())
+			memory := float64(pod.Resources.Memory().Value())
+			summary.CPU.add(newCostItem(GetCPUCost(cluster.pricedAt(), cluster.Name, tenant, cpu, 0), GetCPUCost(cluster.pricedAt(), cluster.Name, tenant, cpu, discount)))
+			summary.Memory.add(newCostItem(GetMemoryCost(cluster.pricedAt(), cluster.Name, tenant, memory, 0), GetMemoryCost(cluster.pricedAt(), cluster.Name, tenant, memory, discount)))
+		}
+
+		pvcs, err := cluster.PVCLister.PersistentVolumeClaims(tenant).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, pvc := range pvcs {
+			if pvc.Spec.StorageClassName == nil {
+				continue
+			}
+
+			discount, err := GetDiscount(pvc.Labels)
+			if err != nil {
+				return nil, fmt.Errorf("pvc %s/%s: %w", pvc.Namespace, pvc.Name, err)
+			}
+
+			storageClass := *pvc.Spec.StorageClassName
+			size := float64(pvc.Spec.Resources.Requests.Storage().Value())
+			if size == 0 {
+				continue
+			}
+			storageListCost, err := GetStorageCost(cluster.pricedAt(), cluster.Name, tenant, storageClass, size, 0)
+			if err != nil {
+				return nil, err
+			}
+			storageCost, err := GetStorageCost(cluster.pricedAt(), cluster.Name, tenant, storageClass, size, discount)
+			if err != nil {
+				return nil, err
+			}
+
+			storageClassCost := summary.Storage.StorageClasses[storageClass]
+			storageClassCost.add(newCostItem(storageListCost, storageCost))
+			summary.Storage.StorageClasses[storageClass] = storageClassCost
+			summary.Storage.add(newCostItem(storageListCost, storageCost))
+		}
+
+		ingresses, err := cluster.listBilledIngresses(tenant)
+		if err != nil {
+			return nil, err
+		}
+		hostnameDiscounts := make(map[string]float64)
+		hostnames := make(map[string]float64)
+		for _, ingress := range ingresses {
+			discount, err := GetDiscount(ingress.Labels)
+			if err != nil {
+				return nil, fmt.Errorf("ingress %s/%s: %w", ingress.Namespace, ingress.Name, err)
+			}
+
+			if INGRESS_COST_PER_DOMAIN {
+				for _, rule := range ingress.Spec.Rules {
+					if hostnameDiscount, ok := hostnameDiscounts[rule.Host]; !ok || discount > hostnameDiscount {
+						hostnameDiscounts[rule.Host] = discount
+					}
+					hostnames[rule.Host] = 0
+				}
+			} else {
+				// every hostname of the ingress is billed
+				summary.Ingress.add(newCostItem(GetIngressCost(cluster.pricedAt(), cluster.Name, tenant, len(ingress.Spec.Rules), 0), GetIngressCost(cluster.pricedAt(), cluster.Name, tenant, len(ingress.Spec.Rules), discount)))
+			}
+		}
+		if INGRESS_COST_PER_DOMAIN && len(hostnames) != 0 {
+			summary.Ingress.add(newCostItem(GetIngressCostByDomain(cluster.pricedAt(), cluster.Name, tenant, hostnames), GetIngressCostByDomain(cluster.pricedAt(), cluster.Name, tenant, hostnameDiscounts)))
+		}
+
+		summary.add(summary.CPU)
+		summary.add(summary.Memory)
+		summary.add(summary.Storage.CostItem)
+		summary.add(summary.Ingress)
+		tenantSummaries[tenant] = summary
+	}
+	return tenantSummaries, nil
+}
+
+// newCostSummary returns an empty cost summary in the currency of the tenant effective at the time
+func newCostSummary(tenant string, at time.Time) CostSummary {
+	return CostSummary{
+		Currency: GetTenantCurrencyAt(tenant, at),
+		Storage: StorageCostItem{
+			StorageClasses: make(map[string]CostItem),
+		},
+	}
+}
+
+// newCostItem returns the cost item of the cost without and with the discount applied
+func newCostItem(subtotal float64, total float64) CostItem {
+	return CostItem{
+		Subtotal: subtotal,
+		Discount: subtotal - total,
+		Total:    total,
+	}
+}
+
+// convert returns the cost summary with its costs converted to the currency
+func (summary CostSummary) convert(currency string) (CostSummary, error) {
+	// conversion factor of one unit of the summary currency
+	rate, err := ConvertCurrency(1, summary.Currency, currency)
+	if err != nil {
+		return CostSummary{}, err
+	}
+
+	converted := CostSummary{
+		Currency: currency,
+		CPU:      summary.CPU.scale(rate),
+		Memory:   summary.Memory.scale(rate),
+		Storage: StorageCostItem{
+			CostItem:       summary.Storage.CostItem.scale(rate),
+			StorageClasses: make(map[string]CostItem, len(summary.Storage.StorageClasses)),
+		},
+		Ingress:  summary.Ingress.scale(rate),
+		CostItem: summary.CostItem.scale(rate),
+	}
+	for storageClass, storageCost := range summary.Storage.StorageClasses {
+		converted.Storage.StorageClasses[storageClass] = storageCost.scale(rate)
+	}
+	return converted, nil
+}
+
+// categoryTotal returns the total of the cost category
+func (summary CostSummary) categoryTotal(category string) float64 {
+	switch category {
+	case "cpu":
+		return summary.CPU.Total
+	case "memory":
+		return summary.Memory.Total
+	case "storage":
+		return summary.Storage.Total
+	case "ingress":
+		return summary.Ingress.Total
+	default:
+		return summary.Total
+	}
+}
+
+// scale returns the cost item with its costs multiplied by the factor
+func (item CostItem) scale(factor float64) CostItem {
+	return CostItem{
+		Subtotal: item.Subtotal * factor,
+		Discount: item.Discount * factor,
+		Total:    item.Total * factor,
+	}
+}
+
+// add adds the costs of the other cost item
+func (item *CostItem) add(other CostItem) {
+	item.Subtotal += other.Subtotal
+	item.Discount += other.Discount
+	item.Total += other.Total
+}
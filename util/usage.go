@@ -0,0 +1,342 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// metricsTimeout is the timeout of a request to the metrics API of a cluster
+const metricsTimeout = 10 * time.Second
+
+var (
+	// BILL_ON_USAGE bills the cpu and memory of each pod on the max of its requests and its usage
+	BILL_ON_USAGE bool
+
+	ErrMetricsUnavailable = errors.New("metrics API is not available")
+)
+
+// PodUsage is the actual CPU in millicores and memory in bytes used by the containers of a pod
+type PodUsage struct {
+	CPU    int64 `json:"cpu"`
+	Memory int64 `json:"memory"`
+}
+
+// ResourceEfficiency is the usage of a resource compared to its requests, a ratio above 1 uses more than requested
+type ResourceEfficiency struct {
+	Requests int64   `json:"requests"`
+	Usage    int64   `json:"usage"`
+	Ratio    float64 `json:"ratio"`
+}
+
+// Efficiency is the usage of the cpu in millicores and the memory in bytes of a tenant compared to its requests
+type Efficiency struct {
+	CPU    ResourceEfficiency `json:"cpu"`
+	Memory ResourceEfficiency `json:"memory"`
+}
+
+// podMetricsList is the PodMetricsList of the metrics.k8s.io/v1beta1 API served by the metrics-server
+type podMetricsList struct {
+	Items []struct {
+		metav1.ObjectMeta `json:"metadata"`
+		Containers        []struct {
+			Name  string          `json:"name"`
+			Usage v1.ResourceList `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// GetPodUsageByTenant returns the usage of each pod for each tenant of all clusters, pods with the same name in several clusters are summed
+func GetPodUsageByTenant(tenants []string) (map[string]map[string]PodUsage, error) {
+	clusterTenantPodUsage, err := GetPodUsageByClusterByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	return SumPodUsageByTenant(clusterTenantPodUsage), nil
+}
+
+// GetPodUsageByClusterByTenant returns the usage of each pod for each tenant keyed by cluster
+func GetPodUsageByClusterByTenant(tenants []string) (map[string]map[string]map[string]PodUsage, error) {
+	clusterTenantPodUsage := make(map[string]map[string]map[string]PodUsage)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		tenantPodUsage, err := snapshot.GetPodUsageByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantPodUsage[cluster.Name] = tenantPodUsage
+	}
+	return clusterTenantPodUsage, nil
+}
+
+// GetPodUsageByTenant returns the usage of each pod for each tenant reported by the metrics API of the cluster
+func (cluster *Cluster) GetPodUsageByTenant(tenants []string) (map[string]map[string]PodUsage, error) {
+	tenantPodUsage := make(map[string]map[string]PodUsage)
+	for _, tenant := range tenants {
+		podUsage, err := cluster.getPodUsage(tenant)
+		if err != nil {
+			return nil, err
+		}
+		tenantPodUsage[tenant] = podUsage
+	}
+	return tenantPodUsage, nil
+}
+
+// SumPodUsageByTenant sums the usage of each pod of each tenant over all clusters
+func SumPodUsageByTenant(clusterTenantPodUsage map[string]map[string]map[string]PodUsage) map[string]map[string]PodUsage {
+	tenantPodUsage := make(map[string]map[string]PodUsage)
+	for _, clusterValues := range clusterTenantPodUsage {
+		for tenant, podUsage := range clusterValues {
+			if tenantPodUsage[tenant] == nil {
+				tenantPodUsage[tenant] = make(map[string]PodUsage)
+			}
+			for pod, usage := range podUsage {
+				tenantPodUsage[tenant][pod] = tenantPodUsage[tenant][pod].add(usage)
+			}
+		}
+	}
+	return tenantPodUsage
+}
+
+// GetUsageSumByTenant sums the usage of the pods of each tenant
+func GetUsageSumByTenant(tenantPodUsage map[string]map[string]PodUsage) map[string]PodUsage {
+	tenantUsage := make(map[string]PodUsage)
+	for tenant, podUsage := range tenantPodUsage {
+		var usage PodUsage
+		for _, podUsage := range podUsage {
+			usage = usage.add(podUsage)
+		}
+		tenantUsage[tenant] = usage
+	}
+	return tenantUsage
+}
+
+// GetEfficiencyByTenant returns the efficiency of each tenant over all clusters
+func GetEfficiencyByTenant(tenants []string) (map[string]Efficiency, error) {
+	clusterTenantEfficiency, err := GetEfficiencyByClusterByTenant(tenants)
+	if err != nil {
+		return nil, err
+	}
+	return SumEfficiencyByTenant(clusterTenantEfficiency), nil
+}
+
+// GetEfficiencyByClusterByTenant returns the efficiency of each tenant keyed by cluster
+func GetEfficiencyByClusterByTenant(tenants []string) (map[string]map[string]Efficiency, error) {
+	clusterTenantEfficiency := make(map[string]map[string]Efficiency)
+	for _, cluster := range Clusters {
+		snapshot, err := cluster.snapshot(tenants)
+		if err != nil {
+			return nil, err
+		}
+		tenantEfficiency, err := snapshot.GetEfficiencyByTenant(tenants)
+		if err != nil {
+			return nil, err
+		}
+		clusterTenantEfficiency[cluster.Name] = tenantEfficiency
+	}
+	return clusterTenantEfficiency, nil
+}
+
+// GetEfficiencyByTenant returns the usage of all pods compared to the requests of the requesting pods for each tenant in the cluster
+func (cluster *Cluster) GetEfficiencyByTenant(tenants []string) (map[string]Efficiency, error) {
+	tenantEfficiency := make(map[string]Efficiency)
+	for _, tenant := range tenants {
+		pods, err := cluster.listRequestingPods(tenant)
+		if err != nil {
+			return nil, err
+		}
+		podUsage, err := cluster.getPodUsage(tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		var efficiency Efficiency
+		for _, pod := range pods {
+			podRequests := GetPodRequests(pod)
+			efficiency.CPU.Requests += podRequests.Cpu().MilliValue()
+			efficiency.Memory.Requests += podRequests.Memory().Value()
+		}
+		for _, usage := range podUsage {
+			efficiency.CPU.Usage += usage.CPU
+			efficiency.Memory.Usage += usage.Memory
+		}
+		tenantEfficiency[tenant] = efficiency.withRatios()
+	}
+	return tenantEfficiency, nil
+}
+
+// SumEfficiencyByTenant sums the requests and usage of each tenant over all clusters and recalculates the ratios
+func SumEfficiencyByTenant(clusterTenantEfficiency map[string]map[string]Efficiency) map[string]Efficiency {
+	tenantEfficiency := make(map[string]Efficiency)
+	for _, clusterValues := range clusterTenantEfficiency {
+		for tenant, efficiency := range clusterValues {
+			sum := tenantEfficiency[tenant]
+			sum.CPU.Requests += efficiency.CPU.Requests
+			sum.CPU.Usage += efficiency.CPU.Usage
+			sum.Memory.Requests += efficiency.Memory.Requests
+			sum.Memory.Usage += efficiency.Memory.Usage
+			tenantEfficiency[tenant] = sum
+		}
+	}
+	for tenant, efficiency := range tenantEfficiency {
+		tenantEfficiency[tenant] = efficiency.withRatios()
+	}
+	return tenantEfficiency
+}
+
+// getPodUsage returns the usage of each pod of the tenant from the metrics API of the cluster or from Prometheus
+func (cluster *Cluster) getPodUsage(tenant string) (map[string]PodUsage, error) {
+	if cluster.prometheusUsage != nil {
+		return cluster.prometheusUsage[tenant], nil
+	}
+	if DATASOURCE == DatasourcePrometheus {
+		return cluster.getPrometheusPodUsage(tenant)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+	defer cancel()
+
+	data, err := cluster.Clientset.Discovery().RESTClient().Get().
+		AbsPath("/apis/metrics.k8s.io/v1beta1/namespaces", tenant, "pods").
+		DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w in cluster %s: %s", ErrMetricsUnavailable, cluster.Name, err)
+	}
+
+	var metrics podMetricsList
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return nil, fmt.Errorf("invalid pod metrics of tenant %s in cluster %s: %w", tenant, cluster.Name, err)
+	}
+
+	podUsage := make(map[string]PodUsage, len(metrics.Items))
+	for _, item := range metrics.Items {
+		var usage PodUsage
+		for _, container := range item.Containers {
+			usage.CPU += container.Usage.Cpu().MilliValue()
+			usage.Memory += container.Usage.Memory().Value()
+		}
+		podUsage[item.Name] = usage
+	}
+	return podUsage, nil
+}
+
+// billedPod is a pod with its billed cpu and memory
+type billedPod struct {
+	*v1.Pod
+	Resources v1.ResourceList
+}
+
+// listBilledPods returns the requesting pods of the tenant with their requests as billed resources,
+// with BILL_ON_USAGE the cpu and memory of the pods are the max of their requests and usage
+func (cluster *Cluster) listBilledPods(tenant string) ([]billedPod, error) {
+	pods, err := cluster.listRequestingPods(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	var podUsage map[string]PodUsage
+	if cluster.billedUsage != nil {
+		podUsage = cluster.billedUsage[tenant]
+	} else if BILL_ON_USAGE && len(pods) > 0 {
+		// without metrics the pods are billed on their requests
+		if podUsage, err = cluster.getPodUsage(tenant); err != nil {
+			WarningLogger.Printf("billing the requests of tenant %s: %s", tenant, err)
+		}
+	}
+
+	billedPods := make([]billedPod, 0, len(pods))
+	for _, pod := range pods {
+		resources := GetPodRequests(pod)
+		if usage, ok := podUsage[pod.Name]; ok {
+			maxResourceList(resources, usage.resourceList())
+		}
+		billedPods = append(billedPods, billedPod{Pod: pod, Resources: resources})
+	}
+	return billedPods, nil
+}
+
+// withBilledUsage returns a copy of the cluster which bills the pods of the tenants on their usage read once,
+// so the costs of a request or a cost sample are calculated from the same usage. Without BILL_ON_USAGE the cluster is returned
+func (cluster *Cluster) withBilledUsage(tenants []string) (*Cluster, error) {
+	if !BILL_ON_USAGE {
+		return cluster, nil
+	}
+
+	billed := *cluster
+	billed.billedUsage = make(map[string]map[string]PodUsage, len(tenants))
+	for _, tenant := range tenants {
+		pods, err := cluster.listRequestingPods(tenant)
+		if err != nil {
+			return nil, err
+		}
+		if len(pods) == 0 {
+			continue
+		}
+		// without metrics the pods are billed on their requests
+		podUsage, err := cluster.getPodUsage(tenant)
+		if err != nil {
+			WarningLogger.Printf("billing the requests of tenant %s: %s", tenant, err)
+		}
+		billed.billedUsage[tenant] = podUsage
+	}
+	return &billed, nil
+}
+
+// getBilledResourcesSumByTenant returns the sum of the billed cpu and memory of the pods for each tenant in the cluster
+func (cluster *Cluster) getBilledResourcesSumByTenant(tenants []string) (map[string]PodUsage, error) {
+	tenantResources := make(map[string]PodUsage)
+	for _, tenant := range tenants {
+		pods, err := cluster.listBilledPods(tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		var resources PodUsage
+		for _, pod := range pods {
+			resources.CPU += pod.Resources.Cpu().MilliValue()
+			resources.Memory += pod.Resources.Memory().Value()
+		}
+		tenantResources[tenant] = resources
+	}
+	return tenantResources, nil
+}
+
+// add returns the sum of both usages
+func (usage PodUsage) add(other PodUsage) PodUsage {
+	return PodUsage{
+		CPU:    usage.CPU + other.CPU,
+		Memory: usage.Memory + other.Memory,
+	}
+}
+
+// resourceList returns the usage as cpu and memory quantities
+func (usage PodUsage) resourceList() v1.ResourceList {
+	return v1.ResourceList{
+		v1.ResourceCPU:    *resource.NewMilliQuantity(usage.CPU, resource.DecimalSI),
+		v1.ResourceMemory: *resource.NewQuantity(usage.Memory, resource.BinarySI),
+	}
+}
+
+// withRatios returns the efficiency with the ratios of usage to requests, the ratio is 0 without requests
+func (efficiency Efficiency) withRatios() Efficiency {
+	efficiency.CPU.Ratio = ratio(efficiency.CPU.Usage, efficiency.CPU.Requests)
+	efficiency.Memory.Ratio = ratio(efficiency.Memory.Usage, efficiency.Memory.Requests)
+	return efficiency
+}
+
+// ratio returns value divided by total or 0 if total is 0
+func ratio(value int64, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(value) / float64(total)
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeMetrics serves the metrics API with a cpu usage of the web pod which grows with each request
+type fakeMetrics struct {
+	mutex    sync.Mutex
+	requests int
+}
+
+func (metrics *fakeMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	metrics.mutex.Lock()
+	metrics.requests++
+	cpu := metrics.requests * 1000
+	metrics.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"kind":"PodMetricsList","apiVersion":"metrics.k8s.io/v1beta1","items":[{"metadata":{"name":"web","namespace":"acme"},"containers":[{"name":"web","usage":{"cpu":"%dm","memory":"2Gi"}}]}]}`, cpu)
+}
+
+// setupMetrics uses a cluster with a discounted pod of the tenant acme which is billed on the usage of the fake metrics API
+func setupMetrics(t *testing.T) *fakeMetrics {
+	t.Helper()
+	setupStore(t)
+	pod := runningPod("acme", "web", "500m", "1Gi")
+	pod.Labels = map[string]string{"natron.io/discount": "0.5"}
+	cluster := setupCluster(t, "cpu: 1\nmemory: 1\n", tenantNamespace("acme"), pod)
+	BILL_ON_USAGE = true
+	t.Cleanup(func() { BILL_ON_USAGE = false })
+
+	metrics := &fakeMetrics{}
+	server := httptest.NewServer(metrics)
+	t.Cleanup(server.Close)
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cluster.Clientset = clientset
+	return metrics
+}
+
+func TestCostSampleBillsUsageReadOnce(t *testing.T) {
+	metrics := setupMetrics(t)
+
+	if err := RecordCostSamples(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if metrics.requests != 1 {
+		t.Errorf("expected the usage to be read once per tenant, got %d requests", metrics.requests)
+	}
+
+	samples, err := GetCostSamples("acme", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(samples) != 1 {
+		t.Fatalf("expected a sample, got %v %v", samples, err)
+	}
+	sample := samples[0]
+	if sample.CPU != 1000 || sample.CPUListCost != 1 || sample.CPUCost != 0.5 {
+		t.Errorf("expected the cpu usage of 1 core with a discount of 50%%, got %+v", sample)
+	}
+	if sample.Memory != 2*1024*1024*1024 || sample.MemoryListCost != 2 || sample.MemoryCost != 1 {
+		t.Errorf("expected the memory usage of 2 GB with a discount of 50%%, got %+v", sample)
+	}
+}
+
+func TestCostSummaryBillsUsageReadOnce(t *testing.T) {
+	metrics := setupMetrics(t)
+
+	clusterTenantSummaries, err := GetCostSummaryByClusterByTenant([]string{"acme"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if metrics.requests != 1 {
+		t.Errorf("expected the usage to be read once per tenant, got %d requests", metrics.requests)
+	}
+	summary := clusterTenantSummaries["prod"]["acme"]
+	if summary.CPU.Subtotal != 1 || math.Abs(summary.CPU.Total-0.5) > 1e-9 {
+		t.Errorf("expected the list and discounted cost of the same usage, got %+v", summary.CPU)
+	}
+
+	// a second request reads the current usage, 2 cores with a discount of 50%
+	cpuCosts, err := GetCPUCostSumByClusterByTenant([]string{"acme"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if metrics.requests != 2 || cpuCosts["prod"]["acme"] != 1 {
+		t.Errorf("expected the usage of a second request, got %d requests and %v", metrics.requests, cpuCosts)
+	}
+}